@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"go-crud-database/repository"
+	"go-crud-database/utils"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiKeyPrefix makes the keys easy to recognise, e.g. by secret scanners
+const apiKeyPrefix = "gcd_"
+
+var (
+	ErrInvalidAPIKey = errors.New("invalid api key")
+	// ErrAPIKeyUserDisabled is returned for a valid key of a disabled user
+	ErrAPIKeyUserDisabled = errors.New("api key belongs to a disabled user")
+)
+
+// APIKeyIdentity is who an API key acts for. Permissions are the current
+// permissions of the user, narrowed to the scopes of the key.
+type APIKeyIdentity struct {
+	KeyId       int
+	UserId      int
+	IsAdmin     bool
+	Roles       []string
+	Permissions []string
+}
+
+// GenerateAPIKey returns a new key of the form gcd_<prefix>_<secret>, the
+// prefix to look it up by and the hash of the secret to store.
+func GenerateAPIKey() (string, string, string, error) {
+	prefixBytes := make([]byte, 6)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return "", "", "", err
+	}
+	prefix := hex.EncodeToString(prefixBytes)
+
+	secret, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	return apiKeyPrefix + prefix + "_" + secret, prefix, utils.HashToken(secret), nil
+}
+
+func parseAPIKey(key string) (string, string, bool) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return "", "", false
+	}
+
+	prefix, secret, ok := strings.Cut(strings.TrimPrefix(key, apiKeyPrefix), "_")
+	if !ok || prefix == "" || secret == "" {
+		return "", "", false
+	}
+
+	return prefix, secret, true
+}
+
+type APIKeyAuthenticator struct {
+	repo     repository.APIKeyRepository
+	roleRepo repository.RoleRepository
+	users    repository.UserRepository
+}
+
+func NewAPIKeyAuthenticator(repo repository.APIKeyRepository, roleRepo repository.RoleRepository, users repository.UserRepository) *APIKeyAuthenticator {
+	return &APIKeyAuthenticator{repo: repo, roleRepo: roleRepo, users: users}
+}
+
+// Authenticate checks the key and loads the roles and permissions of its
+// owner. Roles and the disabled state are read on every request, so a
+// demotion or a disabled account applies right away.
+func (a *APIKeyAuthenticator) Authenticate(ctx context.Context, key string) (APIKeyIdentity, error) {
+	prefix, secret, ok := parseAPIKey(key)
+	if !ok {
+		return APIKeyIdentity{}, ErrInvalidAPIKey
+	}
+
+	apiKey, err := a.repo.GetByPrefix(ctx, prefix)
+	if err == sql.ErrNoRows {
+		return APIKeyIdentity{}, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return APIKeyIdentity{}, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(apiKey.SecretHash), []byte(utils.HashToken(secret))) != 1 {
+		return APIKeyIdentity{}, ErrInvalidAPIKey
+	}
+
+	now := time.Now()
+	if apiKey.RevokedAt != nil || (apiKey.ExpiresAt != nil && now.After(*apiKey.ExpiresAt)) {
+		return APIKeyIdentity{}, ErrInvalidAPIKey
+	}
+
+	// disabling revokes the keys as well, this covers keys created after
+	// that or a revocation that failed
+	user, err := a.users.GetUserById(ctx, nil, strconv.Itoa(apiKey.UserId))
+	if err == sql.ErrNoRows {
+		return APIKeyIdentity{}, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return APIKeyIdentity{}, err
+	}
+	if user.DisabledAt != nil {
+		return APIKeyIdentity{}, ErrAPIKeyUserDisabled
+	}
+
+	roles, err := a.roleRepo.GetRolesByUserId(ctx, nil, apiKey.UserId)
+	if err != nil {
+		return APIKeyIdentity{}, err
+	}
+
+	permissions, err := a.roleRepo.GetPermissionsByUserId(ctx, nil, apiKey.UserId)
+	if err != nil {
+		return APIKeyIdentity{}, err
+	}
+
+	if err := a.repo.TouchLastUsed(ctx, apiKey.KeyId, now); err != nil {
+		log.Printf("error updating last use of api key %d: %v", apiKey.KeyId, err)
+	}
+
+	identity := APIKeyIdentity{
+		KeyId:       apiKey.KeyId,
+		UserId:      apiKey.UserId,
+		Roles:       roles,
+		Permissions: ScopePermissions(permissions, apiKey.Scopes),
+	}
+	for _, role := range roles {
+		if role == RoleAdmin {
+			identity.IsAdmin = true
+		}
+	}
+
+	return identity, nil
+}
+
+// ScopePermissions keeps the permissions that are in scopes. A key without
+// scopes has all permissions of its user.
+func ScopePermissions(permissions, scopes []string) []string {
+	if len(scopes) == 0 {
+		return permissions
+	}
+
+	result := []string{}
+	for _, permission := range permissions {
+		for _, scope := range scopes {
+			if permission == scope {
+				result = append(result, permission)
+				break
+			}
+		}
+	}
+
+	return result
+}
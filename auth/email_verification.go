@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"go-crud-database/mailer"
+	"log"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	// PurposeEmailVerification marks tokens that may only be used to verify
+	// an email address. ValidateToken rejects every token with a purpose.
+	PurposeEmailVerification = "email_verification"
+
+	// verification links expire after 24 hours
+	emailVerificationTTL = 24 * time.Hour
+
+	// Remind sends at most one link per user and email in this time, the
+	// same window the magic link limit uses
+	verificationReminderInterval = 15 * time.Minute
+)
+
+var ErrInvalidVerificationToken = errors.New("invalid email verification token")
+
+// EmailVerifier sends signed verification links and checks them. The link
+// contains the email it was sent to, so it stops working once the user
+// changes the address.
+type EmailVerifier struct {
+	keys      *KeyManager
+	mailer    mailer.Mailer
+	verifyURL string
+	required  bool
+
+	mu       sync.Mutex
+	reminded map[string]time.Time
+}
+
+// NewEmailVerifier creates the verifier. verifyURL is the address of the
+// verify-email endpoint; when required is set, unverified users can't log in.
+func NewEmailVerifier(keys *KeyManager, mailer mailer.Mailer, verifyURL string, required bool) *EmailVerifier {
+	return &EmailVerifier{keys: keys, mailer: mailer, verifyURL: verifyURL, required: required, reminded: map[string]time.Time{}}
+}
+
+// Required reports whether login is refused until the email is verified.
+func (v *EmailVerifier) Required() bool {
+	return v.required
+}
+
+// SendVerification emails a verification link in the background, so the
+// request doesn't wait for the mail server.
+func (v *EmailVerifier) SendVerification(userId int, username, email string) error {
+	token, err := v.keys.Sign(jwt.MapClaims{
+		"purpose": PurposeEmailVerification,
+		"sub":     strconv.Itoa(userId),
+		"email":   email,
+		"exp":     time.Now().Add(emailVerificationTTL).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	msg := mailer.Message{
+		To:      email,
+		Subject: "Verify your email address",
+		Body: "Hi " + username + ",\n\n" +
+			"Please confirm your email address by opening the link below. It expires in 24 hours.\n\n" +
+			v.verifyURL + "?token=" + url.QueryEscape(token),
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if err := v.mailer.Send(ctx, msg); err != nil {
+			log.Printf("error sending verification email to user %d: %v", userId, err)
+		}
+	}()
+
+	return nil
+}
+
+// Remind sends a verification link like SendVerification unless one was
+// sent for the same user and email in the last 15 minutes, the earlier link
+// still works then. It reports whether a link was sent. Logins use it, so
+// guessing the password right again doesn't flood the inbox.
+func (v *EmailVerifier) Remind(userId int, username, email string) (bool, error) {
+	key := strconv.Itoa(userId) + ":" + email
+	now := time.Now()
+
+	v.mu.Lock()
+	for k, sentAt := range v.reminded {
+		if now.Sub(sentAt) >= verificationReminderInterval {
+			delete(v.reminded, k)
+		}
+	}
+	if _, ok := v.reminded[key]; ok {
+		v.mu.Unlock()
+		return false, nil
+	}
+	v.reminded[key] = now
+	v.mu.Unlock()
+
+	if err := v.SendVerification(userId, username, email); err != nil {
+		v.mu.Lock()
+		delete(v.reminded, key)
+		v.mu.Unlock()
+		return false, err
+	}
+	return true, nil
+}
+
+// ParseVerificationToken checks the signature, expiry and purpose of token
+// and returns the user id and email it was issued for.
+func (v *EmailVerifier) ParseVerificationToken(token string) (int, string, error) {
+	claims := jwt.MapClaims{}
+	parsed, err := v.keys.Parse(token, &claims)
+	if err != nil || !parsed.Valid {
+		return 0, "", ErrInvalidVerificationToken
+	}
+
+	if claims["purpose"] != PurposeEmailVerification {
+		return 0, "", ErrInvalidVerificationToken
+	}
+
+	sub, _ := claims["sub"].(string)
+	userId, err := strconv.Atoi(sub)
+	if err != nil {
+		return 0, "", ErrInvalidVerificationToken
+	}
+
+	email, _ := claims["email"].(string)
+	if email == "" {
+		return 0, "", ErrInvalidVerificationToken
+	}
+
+	return userId, email, nil
+}
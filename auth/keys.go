@@ -0,0 +1,257 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const minRSAKeyBits = 2048
+
+// Key is a single JWT key. Keys loaded from a private key file can sign and
+// verify, keys loaded from a public key file can only verify. The latter are
+// kept during a rotation until every token signed with them has expired.
+type Key struct {
+	ID         string
+	Method     jwt.SigningMethod
+	PrivateKey crypto.Signer
+	PublicKey  crypto.PublicKey
+}
+
+// KeyManager signs access tokens with the active key and verifies them
+// against every known key, looked up by the kid header.
+type KeyManager struct {
+	signing *Key
+	keys    map[string]*Key
+}
+
+// LoadKeyManager loads every *.pem file in dir. The file name without the
+// extension is used as the key id. signingKeyId selects the key used to sign
+// new tokens; it can be empty when dir contains exactly one private key.
+func LoadKeyManager(dir, signingKeyId string) (*KeyManager, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no *.pem key files found in %s", dir)
+	}
+
+	var keys []*Key
+	for _, file := range files {
+		kid := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := parseKey(kid, data)
+		if err != nil {
+			return nil, fmt.Errorf("loading key %s: %w", file, err)
+		}
+		keys = append(keys, key)
+	}
+
+	return NewKeyManager(signingKeyId, keys...)
+}
+
+// NewKeyManager builds a KeyManager from keys that are already loaded.
+func NewKeyManager(signingKeyId string, keys ...*Key) (*KeyManager, error) {
+	m := &KeyManager{keys: make(map[string]*Key)}
+
+	var privateKeys []*Key
+	for _, key := range keys {
+		if _, exists := m.keys[key.ID]; exists {
+			return nil, fmt.Errorf("duplicate key id %q", key.ID)
+		}
+		m.keys[key.ID] = key
+
+		if key.PrivateKey != nil {
+			privateKeys = append(privateKeys, key)
+		}
+	}
+
+	if signingKeyId == "" {
+		if len(privateKeys) != 1 {
+			return nil, fmt.Errorf("found %d private keys, the signing key id must be set", len(privateKeys))
+		}
+		signingKeyId = privateKeys[0].ID
+	}
+
+	signing, ok := m.keys[signingKeyId]
+	if !ok {
+		return nil, fmt.Errorf("signing key %q not found", signingKeyId)
+	}
+	if signing.PrivateKey == nil {
+		return nil, fmt.Errorf("signing key %q has no private key", signingKeyId)
+	}
+	m.signing = signing
+
+	return m, nil
+}
+
+// NewEphemeralKeyManager generates a throwaway Ed25519 key. Tokens signed with
+// it stop being valid when the process restarts, so it is only meant for
+// local development.
+func NewEphemeralKeyManager() (*KeyManager, error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	kid, err := thumbprint(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewKeyManager(kid, &Key{ID: kid, Method: SigningMethodEd25519, PrivateKey: privateKey, PublicKey: publicKey})
+}
+
+func parseKey(kid string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{ID: kid}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Method, key.PrivateKey, key.PublicKey = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.Method, key.PublicKey = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.Method, key.PrivateKey, key.PublicKey = SigningMethodEd25519, k, k.Public()
+	case ed25519.PublicKey:
+		key.Method, key.PublicKey = SigningMethodEd25519, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, only RSA and Ed25519 keys are supported", parsed)
+	}
+
+	if rsaKey, ok := key.PublicKey.(*rsa.PublicKey); ok && rsaKey.N.BitLen() < minRSAKeyBits {
+		return nil, fmt.Errorf("RSA key is %d bits, at least %d are required", rsaKey.N.BitLen(), minRSAKeyBits)
+	}
+
+	return key, nil
+}
+
+// SigningKeyID returns the kid of the key used for new tokens.
+func (m *KeyManager) SigningKeyID() string {
+	return m.signing.ID
+}
+
+// Sign signs the claims with the active key and sets the kid header.
+func (m *KeyManager) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(m.signing.Method, claims)
+	token.Header["kid"] = m.signing.ID
+
+	return token.SignedString(m.signing.PrivateKey)
+}
+
+// Parse verifies tokenString and decodes it into claims. The key is selected
+// by the kid header and the alg header has to match the algorithm of that
+// key, so a token can't pick a weaker algorithm than the one it was issued with.
+func (m *KeyManager) Parse(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := m.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %q for key %q", token.Method.Alg(), kid)
+		}
+
+		return key.PublicKey, nil
+	})
+}
+
+// JSONWebKey is the public part of a key as described in RFC 7517.
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JWKS returns every verification key, sorted by kid.
+func (m *KeyManager) JWKS() JSONWebKeySet {
+	set := JSONWebKeySet{Keys: make([]JSONWebKey, 0, len(m.keys))}
+	for _, key := range m.keys {
+		set.Keys = append(set.Keys, key.jwk())
+	}
+
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].KeyID < set.Keys[j].KeyID
+	})
+
+	return set
+}
+
+func (k *Key) jwk() JSONWebKey {
+	jwk := JSONWebKey{KeyID: k.ID, Use: "sig", Algorithm: k.Method.Alg()}
+
+	switch publicKey := k.PublicKey.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(publicKey)
+	}
+
+	return jwk
+}
+
+// thumbprint derives a stable key id from the public key.
+func thumbprint(publicKey crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(der)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])[:16], nil
+}
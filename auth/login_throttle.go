@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"go-crud-database/repository"
+	"time"
+)
+
+const (
+	// failures older than this are forgotten
+	loginFailureWindow = 24 * time.Hour
+
+	DefaultMaxLoginFailures = 5
+	DefaultLockoutBaseDelay = 30 * time.Second
+	DefaultLockoutMaxDelay  = time.Hour
+)
+
+// LockReason tells which counter caused a lock.
+type LockReason int
+
+const (
+	NotLocked LockReason = iota
+	// LockedAccount means the username had too many failures
+	LockedAccount
+	// LockedClient means the client IP had too many failures
+	LockedClient
+)
+
+// LoginThrottle counts failed logins per username and per client IP. Once a
+// counter reaches maxFailures, every further failure locks the key for
+// baseDelay, doubled with each failure and capped at maxDelay. The counters
+// live in the database, so a restart doesn't reset them.
+type LoginThrottle struct {
+	repo        repository.LoginAttemptRepository
+	maxFailures int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+}
+
+func NewLoginThrottle(repo repository.LoginAttemptRepository, maxFailures int, baseDelay, maxDelay time.Duration) *LoginThrottle {
+	return &LoginThrottle{repo: repo, maxFailures: maxFailures, baseDelay: baseDelay, maxDelay: maxDelay}
+}
+
+// Check reports whether the username or the client IP is locked and for how
+// much longer.
+func (t *LoginThrottle) Check(ctx context.Context, username, ip string) (LockReason, time.Duration, error) {
+	now := time.Now()
+
+	for _, check := range []struct {
+		key    string
+		reason LockReason
+	}{
+		{userAttemptKey(username), LockedAccount},
+		{ipAttemptKey(ip), LockedClient},
+	} {
+		attempt, err := t.repo.GetLoginAttempt(ctx, check.key)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return NotLocked, 0, err
+		}
+
+		if attempt.LockedUntil.Valid && attempt.LockedUntil.Time.After(now) {
+			return check.reason, attempt.LockedUntil.Time.Sub(now), nil
+		}
+	}
+
+	return NotLocked, 0, nil
+}
+
+// RecordFailure counts a failed login for the username and the client IP. An
+// empty username only counts against the IP.
+func (t *LoginThrottle) RecordFailure(ctx context.Context, username, ip string) error {
+	keys := []string{ipAttemptKey(ip)}
+	if username != "" {
+		keys = append(keys, userAttemptKey(username))
+	}
+
+	now := time.Now()
+	for _, key := range keys {
+		failures, err := t.repo.RecordFailure(ctx, key, now, loginFailureWindow)
+		if err != nil {
+			return err
+		}
+
+		if delay := t.LockoutDelay(failures); delay > 0 {
+			if err := t.repo.LockUntil(ctx, key, now.Add(delay)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// RecordSuccess clears the counter of the username. The IP counter is kept,
+// otherwise one valid account would reset the guesses against all others.
+func (t *LoginThrottle) RecordSuccess(ctx context.Context, username string) error {
+	return t.repo.Reset(ctx, userAttemptKey(username))
+}
+
+// Unlock clears the counter and the lock of the username.
+func (t *LoginThrottle) Unlock(ctx context.Context, username string) error {
+	return t.repo.Reset(ctx, userAttemptKey(username))
+}
+
+// LockoutDelay returns how long a key is locked after the given number of
+// consecutive failures.
+func (t *LoginThrottle) LockoutDelay(failures int) time.Duration {
+	if failures < t.maxFailures {
+		return 0
+	}
+
+	delay := t.baseDelay
+	for i := t.maxFailures; i < failures; i++ {
+		delay *= 2
+		if delay >= t.maxDelay {
+			return t.maxDelay
+		}
+	}
+
+	if delay > t.maxDelay {
+		return t.maxDelay
+	}
+	return delay
+}
+
+func userAttemptKey(username string) string {
+	return "user:" + username
+}
+
+func ipAttemptKey(ip string) string {
+	return "ip:" + ip
+}
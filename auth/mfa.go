@@ -0,0 +1,248 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"go-crud-database/repository"
+	"go-crud-database/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	// PurposeMFAPending marks the token returned by login when the password
+	// was correct but a second factor is still needed.
+	PurposeMFAPending = "mfa_pending"
+
+	// MFAPendingTTL is how long the user has to enter the code
+	MFAPendingTTL = 5 * time.Minute
+
+	recoveryCodeCount = 10
+)
+
+var (
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnrolled    = errors.New("two-factor authentication is not enrolled")
+	ErrMFANotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrInvalidMFACode    = errors.New("invalid two-factor authentication code")
+	ErrInvalidMFAToken   = errors.New("invalid mfa token")
+)
+
+// MFAService manages TOTP enrollment and checks second-factor codes. Secrets
+// are stored encrypted, recovery codes only as hashes.
+type MFAService struct {
+	repo   repository.MFARepository
+	box    *SecretBox
+	keys   *KeyManager
+	issuer string
+}
+
+// NewMFAService creates the service. issuer is the name shown in the
+// authenticator app.
+func NewMFAService(repo repository.MFARepository, box *SecretBox, keys *KeyManager, issuer string) *MFAService {
+	return &MFAService{repo: repo, box: box, keys: keys, issuer: issuer}
+}
+
+// Enabled reports whether the user has confirmed two-factor authentication.
+func (s *MFAService) Enabled(ctx context.Context, tx *sql.Tx, userId int) (bool, error) {
+	mfa, err := s.repo.GetByUserIdForUpdate(ctx, tx, userId)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return mfa.EnabledAt.Valid, nil
+}
+
+// Enroll stores a new secret for the user and returns it together with the
+// otpauth URI. The secret isn't used for login until Confirm succeeds.
+func (s *MFAService) Enroll(ctx context.Context, tx *sql.Tx, userId int, accountName string) (string, string, error) {
+	enabled, err := s.Enabled(ctx, tx, userId)
+	if err != nil {
+		return "", "", err
+	}
+	if enabled {
+		return "", "", ErrMFAAlreadyEnabled
+	}
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	encrypted, err := s.box.Encrypt(secret)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := s.repo.SavePending(ctx, tx, userId, encrypted); err != nil {
+		return "", "", err
+	}
+
+	return secret, TOTPURI(s.issuer, accountName, secret), nil
+}
+
+// Confirm enables two-factor authentication once the user proves the
+// authenticator works, and returns a fresh set of recovery codes.
+func (s *MFAService) Confirm(ctx context.Context, tx *sql.Tx, userId int, code string) ([]string, error) {
+	mfa, err := s.repo.GetByUserIdForUpdate(ctx, tx, userId)
+	if err == sql.ErrNoRows {
+		return nil, ErrMFANotEnrolled
+	}
+	if err != nil {
+		return nil, err
+	}
+	if mfa.EnabledAt.Valid {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := s.box.Decrypt(mfa.SecretEncrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	step, ok := ValidateTOTP(secret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	if err := s.repo.Enable(ctx, tx, userId, step); err != nil {
+		return nil, err
+	}
+
+	return s.newRecoveryCodes(ctx, tx, userId)
+}
+
+// Verify checks a TOTP code or an unused recovery code. A TOTP code is only
+// accepted once, so a code seen by an attacker can't be replayed.
+func (s *MFAService) Verify(ctx context.Context, tx *sql.Tx, userId int, code string) error {
+	return s.verify(ctx, tx, userId, code, true)
+}
+
+// verify checks a TOTP code, and an unused recovery code when recovery is
+// set.
+func (s *MFAService) verify(ctx context.Context, tx *sql.Tx, userId int, code string, recovery bool) error {
+	mfa, err := s.repo.GetByUserIdForUpdate(ctx, tx, userId)
+	if err == sql.ErrNoRows {
+		return ErrMFANotEnabled
+	}
+	if err != nil {
+		return err
+	}
+	if !mfa.EnabledAt.Valid {
+		return ErrMFANotEnabled
+	}
+
+	code = strings.TrimSpace(code)
+
+	secret, err := s.box.Decrypt(mfa.SecretEncrypted)
+	if err != nil {
+		return err
+	}
+
+	if step, ok := ValidateTOTP(secret, code, time.Now()); ok {
+		if step <= mfa.LastUsedStep {
+			return ErrInvalidMFACode
+		}
+
+		return s.repo.UpdateLastUsedStep(ctx, tx, userId, step)
+	}
+	if !recovery {
+		return ErrInvalidMFACode
+	}
+
+	used, err := s.repo.UseRecoveryCode(ctx, tx, userId, utils.HashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidMFACode
+	}
+
+	return nil
+}
+
+// Disable turns two-factor authentication off after checking a current code.
+func (s *MFAService) Disable(ctx context.Context, tx *sql.Tx, userId int, code string) error {
+	if err := s.Verify(ctx, tx, userId, code); err != nil {
+		return err
+	}
+
+	return s.repo.Delete(ctx, tx, userId)
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes of the user. Only a
+// code of the authenticator app is accepted, one recovery code that got out
+// must not be enough to replace the others.
+func (s *MFAService) RegenerateRecoveryCodes(ctx context.Context, tx *sql.Tx, userId int, code string) ([]string, error) {
+	if err := s.verify(ctx, tx, userId, code, false); err != nil {
+		return nil, err
+	}
+
+	return s.newRecoveryCodes(ctx, tx, userId)
+}
+
+func (s *MFAService) newRecoveryCodes(ctx context.Context, tx *sql.Tx, userId int) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		raw := hex.EncodeToString(b)
+
+		codes[i] = raw[:5] + "-" + raw[5:]
+		hashes[i] = utils.HashToken(normalizeRecoveryCode(codes[i]))
+	}
+
+	if err := s.repo.ReplaceRecoveryCodes(ctx, tx, userId, hashes); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// IssuePendingToken signs the short-lived token that lets the user finish
+// login with a second factor.
+func (s *MFAService) IssuePendingToken(userId int) (string, error) {
+	return s.keys.Sign(jwt.MapClaims{
+		"purpose": PurposeMFAPending,
+		"sub":     strconv.Itoa(userId),
+		"exp":     time.Now().Add(MFAPendingTTL).Unix(),
+	})
+}
+
+// ParsePendingToken returns the user id of a valid mfa pending token.
+func (s *MFAService) ParsePendingToken(token string) (int, error) {
+	claims := jwt.MapClaims{}
+	parsed, err := s.keys.Parse(token, &claims)
+	if err != nil || !parsed.Valid {
+		return 0, ErrInvalidMFAToken
+	}
+
+	if claims["purpose"] != PurposeMFAPending {
+		return 0, ErrInvalidMFAToken
+	}
+
+	sub, _ := claims["sub"].(string)
+	userId, err := strconv.Atoi(sub)
+	if err != nil {
+		return 0, ErrInvalidMFAToken
+	}
+
+	return userId, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
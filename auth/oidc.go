@@ -0,0 +1,440 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-crud-database/utils"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// jwksRefreshInterval limits how often an unknown kid makes us fetch the
+// provider keys again
+const jwksRefreshInterval = time.Minute
+
+var ErrInvalidIDToken = errors.New("invalid id token")
+
+// OIDCClaims are the parts of a validated ID token this service uses.
+type OIDCClaims struct {
+	Issuer            string
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+}
+
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCProvider runs the authorization code flow with PKCE against one
+// OpenID Connect provider.
+type OIDCProvider struct {
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	client       *http.Client
+	metadata     oidcMetadata
+
+	mu            sync.RWMutex
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+}
+
+// DiscoverOIDCProvider reads the provider configuration from
+// <issuer>/.well-known/openid-configuration.
+func DiscoverOIDCProvider(ctx context.Context, issuer, clientID, clientSecret, redirectURL string, client *http.Client) (*OIDCProvider, error) {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	p := &OIDCProvider{clientID: clientID, clientSecret: clientSecret, redirectURL: redirectURL, client: client}
+
+	wellKnown := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, wellKnown, &p.metadata); err != nil {
+		return nil, fmt.Errorf("discovering %s: %w", issuer, err)
+	}
+
+	// a provider answering for another issuer could mint tokens for it
+	if p.metadata.Issuer != issuer {
+		return nil, fmt.Errorf("discovery returned issuer %q, expected %q", p.metadata.Issuer, issuer)
+	}
+	if p.metadata.AuthorizationEndpoint == "" || p.metadata.TokenEndpoint == "" || p.metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery document of %s is incomplete", issuer)
+	}
+
+	return p, nil
+}
+
+// Issuer returns the issuer the ID tokens have to come from.
+func (p *OIDCProvider) Issuer() string {
+	return p.metadata.Issuer
+}
+
+// NewPKCEVerifier returns a code verifier and its S256 challenge.
+func NewPKCEVerifier() (string, string, error) {
+	verifier, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// AuthCodeURL is where the user is sent to sign in.
+func (p *OIDCProvider) AuthCodeURL(state, nonce, codeChallenge string) string {
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.clientID)
+	query.Set("redirect_uri", p.redirectURL)
+	query.Set("scope", "openid email profile")
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(p.metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return p.metadata.AuthorizationEndpoint + separator + query.Encode()
+}
+
+// Exchange trades the authorization code for the validated ID token claims.
+func (p *OIDCProvider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (OIDCClaims, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.redirectURL)
+	form.Set("code_verifier", codeVerifier)
+	if p.clientSecret == "" {
+		form.Set("client_id", p.clientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return OIDCClaims{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return OIDCClaims{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return OIDCClaims{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return OIDCClaims{}, fmt.Errorf("token endpoint returned %d: %s", resp.StatusCode, body)
+	}
+
+	var tokenResponse struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tokenResponse); err != nil {
+		return OIDCClaims{}, err
+	}
+	if tokenResponse.IDToken == "" {
+		return OIDCClaims{}, errors.New("token response has no id_token")
+	}
+
+	return p.VerifyIDToken(ctx, tokenResponse.IDToken, nonce)
+}
+
+// VerifyIDToken checks signature, issuer, audience, expiry and nonce.
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (OIDCClaims, error) {
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(rawIDToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+		default:
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+
+		kid, _ := token.Header["kid"].(string)
+		return p.publicKey(ctx, kid)
+	})
+	if err != nil || !token.Valid {
+		return OIDCClaims{}, ErrInvalidIDToken
+	}
+
+	if claims["iss"] != p.metadata.Issuer || !p.audienceAllowed(claims) {
+		return OIDCClaims{}, ErrInvalidIDToken
+	}
+
+	// ID tokens must expire, jwt-go only checks exp when it is present
+	if _, ok := claims["exp"].(float64); !ok {
+		return OIDCClaims{}, ErrInvalidIDToken
+	}
+
+	if tokenNonce, _ := claims["nonce"].(string); nonce == "" || tokenNonce != nonce {
+		return OIDCClaims{}, ErrInvalidIDToken
+	}
+
+	result := OIDCClaims{Issuer: p.metadata.Issuer}
+	result.Subject, _ = claims["sub"].(string)
+	result.Email, _ = claims["email"].(string)
+	result.PreferredUsername, _ = claims["preferred_username"].(string)
+
+	// some providers send email_verified as a string
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		result.EmailVerified = verified
+	case string:
+		result.EmailVerified = verified == "true"
+	}
+
+	if result.Subject == "" {
+		return OIDCClaims{}, ErrInvalidIDToken
+	}
+
+	return result, nil
+}
+
+func (p *OIDCProvider) audienceAllowed(claims jwt.MapClaims) bool {
+	var audiences []string
+	switch aud := claims["aud"].(type) {
+	case string:
+		audiences = []string{aud}
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				audiences = append(audiences, s)
+			}
+		}
+	}
+
+	found := false
+	for _, aud := range audiences {
+		if aud == p.clientID {
+			found = true
+		}
+	}
+	if !found {
+		return false
+	}
+
+	// with several audiences the token must have been issued to us
+	if len(audiences) > 1 {
+		if azp, _ := claims["azp"].(string); azp != p.clientID {
+			return false
+		}
+	}
+
+	return true
+}
+
+// publicKey returns the provider key with the id. Unknown ids trigger one
+// refresh of the key set, so key rotation at the provider just works.
+func (p *OIDCProvider) publicKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.mu.RLock()
+	key, ok := p.keys[kid]
+	fetchedAt := p.keysFetchedAt
+	p.mu.RUnlock()
+
+	if ok {
+		return key, nil
+	}
+
+	if time.Since(fetchedAt) < jwksRefreshInterval {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	if err := p.refreshKeys(ctx); err != nil {
+		return nil, err
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	// providers with a single key sometimes leave out the kid
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, nil
+		}
+	}
+
+	key, ok = p.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	return key, nil
+}
+
+func (p *OIDCProvider) refreshKeys(ctx context.Context) error {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.metadata.JWKSURI, &set); err != nil {
+		return err
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		switch k.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				continue
+			}
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+	p.mu.Unlock()
+
+	return nil
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %d", url, resp.StatusCode)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// PurposeOIDCLogin marks the signed cookie that carries the state, nonce and
+// PKCE verifier from the redirect to the callback.
+const PurposeOIDCLogin = "oidc_login"
+
+// OIDCLoginState is what the callback needs to finish a login it started.
+// LinkUserId is set when a logged in user links an external account instead
+// of logging in.
+type OIDCLoginState struct {
+	State        string
+	Nonce        string
+	CodeVerifier string
+	LinkUserId   int
+}
+
+// NewOIDCLoginState creates random values for one login and returns the PKCE
+// challenge to send to the provider.
+func NewOIDCLoginState() (OIDCLoginState, string, error) {
+	state, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return OIDCLoginState{}, "", err
+	}
+
+	nonce, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return OIDCLoginState{}, "", err
+	}
+
+	verifier, challenge, err := NewPKCEVerifier()
+	if err != nil {
+		return OIDCLoginState{}, "", err
+	}
+
+	return OIDCLoginState{State: state, Nonce: nonce, CodeVerifier: verifier}, challenge, nil
+}
+
+// SignOIDCLoginState signs the state so it can be kept in a cookie.
+func SignOIDCLoginState(keys *KeyManager, state OIDCLoginState, ttl time.Duration) (string, error) {
+	claims := jwt.MapClaims{
+		"purpose":  PurposeOIDCLogin,
+		"state":    state.State,
+		"nonce":    state.Nonce,
+		"verifier": state.CodeVerifier,
+		"exp":      time.Now().Add(ttl).Unix(),
+	}
+	if state.LinkUserId != 0 {
+		claims["link"] = state.LinkUserId
+	}
+	return keys.Sign(claims)
+}
+
+// ParseOIDCLoginState checks the signature and expiry of the cookie value.
+func ParseOIDCLoginState(keys *KeyManager, token string) (OIDCLoginState, error) {
+	claims := jwt.MapClaims{}
+	parsed, err := keys.Parse(token, &claims)
+	if err != nil || !parsed.Valid || claims["purpose"] != PurposeOIDCLogin {
+		return OIDCLoginState{}, errors.New("invalid oidc login state")
+	}
+
+	var state OIDCLoginState
+	state.State, _ = claims["state"].(string)
+	state.Nonce, _ = claims["nonce"].(string)
+	state.CodeVerifier, _ = claims["verifier"].(string)
+	if link, ok := claims["link"].(float64); ok {
+		state.LinkUserId = int(link)
+	}
+	if state.State == "" || state.Nonce == "" || state.CodeVerifier == "" {
+		return OIDCLoginState{}, errors.New("invalid oidc login state")
+	}
+
+	return state, nil
+}
@@ -0,0 +1,21 @@
+package auth
+
+// Permissions checked by middleware.RequirePermission. They are granted to
+// roles in the role_permissions table and copied into the access token.
+const (
+	PermissionUsersRead   = "users:read"
+	PermissionUsersUpdate = "users:update"
+	PermissionUsersDelete = "users:delete"
+	// PermissionUsersPromote allows granting and removing admin rights
+	PermissionUsersPromote = "users:promote"
+	// PermissionUsersImpersonate allows acting as another user for a short time
+	PermissionUsersImpersonate = "users:impersonate"
+	PermissionRolesManage      = "roles:manage"
+)
+
+// Built-in roles. Users with is_admin set always have RoleAdmin.
+const (
+	RoleAdmin   = "admin"
+	RoleSupport = "support"
+	RoleMember  = "member"
+)
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"context"
+	"time"
+)
+
+// AuthMethod tells how the caller of a request was authenticated.
+type AuthMethod string
+
+const (
+	AuthMethodToken  AuthMethod = "token"
+	AuthMethodAPIKey AuthMethod = "api_key"
+)
+
+// Principal is the authenticated caller of a request. The token middleware
+// puts it in the request context, handlers read it with FromContext.
+type Principal struct {
+	UserId  int
+	IsAdmin bool
+	Roles   []string
+	// Scopes are the permissions the request may use. For access tokens they
+	// are the permissions of the user, for API keys the ones left after
+	// applying the key's scopes.
+	Scopes []string
+	// TokenId and TokenExpiresAt identify the access token, they are empty
+	// for API keys.
+	TokenId        string
+	TokenExpiresAt time.Time
+	APIKeyId       int
+	// ImpersonatorId is the admin acting as the user, 0 for the user's own
+	// sessions.
+	ImpersonatorId int
+	Method         AuthMethod
+}
+
+// HasScope reports whether the request may use the permission.
+func (p *Principal) HasScope(permission string) bool {
+	for _, scope := range p.Scopes {
+		if scope == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsImpersonated reports whether an admin is acting as the user.
+func (p *Principal) IsImpersonated() bool {
+	return p.ImpersonatorId != 0
+}
+
+// principalKey is unexported so no other package can overwrite the
+// principal by accident.
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx that carries p.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal of an authenticated request. ok is false
+// when the request didn't pass the token middleware.
+func FromContext(ctx context.Context) (p *Principal, ok bool) {
+	p, ok = ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"go-crud-database/repository"
+	"sync"
+	"time"
+)
+
+// negativeCacheTTL is how long a "not revoked" answer is trusted before the
+// database is asked again. Revocations made by this process are visible
+// immediately, revocations made by another instance after at most this long.
+const negativeCacheTTL = 10 * time.Second
+
+type tokenEntry struct {
+	revoked bool
+	// until is the token expiry for revoked tokens and the end of the
+	// negative cache window otherwise
+	until time.Time
+}
+
+type userEntry struct {
+	revokedAt time.Time
+	checkedAt time.Time
+}
+
+// RevocationStore keeps track of revoked access tokens. Revocations are
+// persisted through the repository and cached in memory so ValidateToken
+// does not hit the database on every request.
+type RevocationStore struct {
+	repo repository.RevocationRepository
+
+	mu        sync.RWMutex
+	tokens    map[string]tokenEntry
+	users     map[int]userEntry
+	lastSweep time.Time
+}
+
+func NewRevocationStore(repo repository.RevocationRepository) *RevocationStore {
+	return &RevocationStore{
+		repo:   repo,
+		tokens: make(map[string]tokenEntry),
+		users:  make(map[int]userEntry),
+	}
+}
+
+// RevokeToken revokes a single access token (logout).
+func (s *RevocationStore) RevokeToken(ctx context.Context, tokenId string, userId int, expiresAt time.Time) error {
+	if err := s.repo.RevokeToken(ctx, tokenId, userId, expiresAt); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.tokens[tokenId] = tokenEntry{revoked: true, until: expiresAt}
+	s.mu.Unlock()
+
+	return nil
+}
+
+// RevokeUser revokes every access token issued to the user so far, for
+// example after the account was deleted.
+func (s *RevocationStore) RevokeUser(ctx context.Context, userId int) error {
+	now := time.Now()
+	if err := s.repo.RevokeUserTokens(ctx, userId, now); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.users[userId] = userEntry{revokedAt: now, checkedAt: now}
+	s.mu.Unlock()
+
+	return nil
+}
+
+// IsRevoked reports whether the token with the given id, issued to userId at
+// issuedAt, has been revoked either on its own or together with all tokens
+// of the user.
+func (s *RevocationStore) IsRevoked(ctx context.Context, tokenId string, userId int, issuedAt, expiresAt time.Time) (bool, error) {
+	revoked, err := s.isTokenRevoked(ctx, tokenId, expiresAt)
+	if err != nil || revoked {
+		return revoked, err
+	}
+
+	revokedAt, err := s.userRevokedAt(ctx, userId)
+	if err != nil {
+		return false, err
+	}
+
+	// compared in microseconds, the precision of iat and of the database. A
+	// token with a whole second in iat counts as issued at its start, so it
+	// is revoked for the rest of that second.
+	return !revokedAt.IsZero() && !issuedAt.Truncate(time.Microsecond).After(revokedAt.Truncate(time.Microsecond)), nil
+}
+
+func (s *RevocationStore) isTokenRevoked(ctx context.Context, tokenId string, expiresAt time.Time) (bool, error) {
+	now := time.Now()
+
+	s.mu.RLock()
+	entry, ok := s.tokens[tokenId]
+	s.mu.RUnlock()
+	if ok && now.Before(entry.until) {
+		return entry.revoked, nil
+	}
+
+	revoked, err := s.repo.IsTokenRevoked(ctx, tokenId)
+	if err != nil {
+		return false, err
+	}
+
+	// a revoked token only needs to stay in the cache until it expires
+	until := now.Add(negativeCacheTTL)
+	if revoked {
+		until = expiresAt
+	}
+
+	s.mu.Lock()
+	s.tokens[tokenId] = tokenEntry{revoked: revoked, until: until}
+	s.sweepLocked(now)
+	s.mu.Unlock()
+
+	return revoked, nil
+}
+
+func (s *RevocationStore) userRevokedAt(ctx context.Context, userId int) (time.Time, error) {
+	now := time.Now()
+
+	s.mu.RLock()
+	entry, ok := s.users[userId]
+	s.mu.RUnlock()
+	if ok && now.Sub(entry.checkedAt) < negativeCacheTTL {
+		return entry.revokedAt, nil
+	}
+
+	revokedAt, err := s.repo.GetUserRevokedAt(ctx, userId)
+	if err != nil && err != sql.ErrNoRows {
+		return time.Time{}, err
+	}
+
+	s.mu.Lock()
+	s.users[userId] = userEntry{revokedAt: revokedAt, checkedAt: now}
+	s.sweepLocked(now)
+	s.mu.Unlock()
+
+	return revokedAt, nil
+}
+
+// sweepLocked drops cache entries that are no longer useful so the maps do
+// not grow without bound. It runs at most once per negativeCacheTTL.
+// s.mu must be held.
+func (s *RevocationStore) sweepLocked(now time.Time) {
+	if now.Sub(s.lastSweep) < negativeCacheTTL {
+		return
+	}
+	s.lastSweep = now
+
+	for tokenId, entry := range s.tokens {
+		if !now.Before(entry.until) {
+			delete(s.tokens, tokenId)
+		}
+	}
+	for userId, entry := range s.users {
+		if now.Sub(entry.checkedAt) >= negativeCacheTTL {
+			delete(s.users, userId)
+		}
+	}
+}
@@ -1,58 +1,223 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"go-crud-database/auth"
 	"go-crud-database/config"
 	"go-crud-database/handler"
+	"go-crud-database/mailer"
 	"go-crud-database/middleware"
 	"go-crud-database/repository"
-	"net/http"
+	"go-crud-database/server"
+	"go-crud-database/utils"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 )
 
 func main() {
-	err := config.LoadEnv(".env")
+	// .env only fills in variables the environment doesn't set already
+	if err := config.LoadEnv(".env"); err != nil {
+		log.Fatal(err)
+	}
+
+	cfg, args, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
+	}
+	// Password hashing parameters and the rules for new passwords
+	if err := utils.SetArgon2Params(cfg.Argon2); err != nil {
+		log.Fatal(err)
+	}
+	if err := utils.SetPasswordPolicy(cfg.PasswordPolicy); err != nil {
+		log.Fatal(err)
+	}
+
+	db := config.ConnectToDB(cfg.DB)
+
+	// one-off commands like "migrate up" or "seed" run instead of the server
+	if len(args) > 0 {
+		err := runCommand(cfg, db, args)
+		db.Close()
+		if err != nil && !errors.Is(err, flag.ErrHelp) {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	db := config.ConnectToDB()
-	defer db.Close()
+	if cfg.DB.AutoMigrate {
+		if err := migrateOnStart(db); err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	// Initialize the User Repository
 	userRepo := repository.NewUserRepository(db)
+	refreshTokenRepo := repository.NewRefreshTokenRepository(db)
+	roleRepo := repository.NewRoleRepository(db)
+	auditRepo := repository.NewAuditRepository(db)
+	passwordResetRepo := repository.NewPasswordResetRepository(db)
+
+	// Access tokens are checked against the revocation store on every request
+	revocationStore := auth.NewRevocationStore(repository.NewRevocationRepository(db))
+
+	// Load the JWT signing and verification keys
+	keyManager := loadKeyManager(cfg.JWT)
 
-	// Create an instance of UserHandler with the repository
-	userHandler := handler.NewUserHandler(userRepo, db)
-
-	// Initialize the RateLimiter middleware
-	// 10 requests per 5 minutes
-	// 1 request per minute per IP
-	rateLimiter := middleware.NewRateLimiter(10, 5, 1*time.Minute)
-
-	// add middleware to endpoint users
-	http.Handle("/api/v1/users", rateLimiter.Limit(middleware.ValidateToken(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		id := r.URL.Query().Get("id")
-
-		switch r.Method {
-		case http.MethodGet:
-			if id == "" {
-				userHandler.GetAllUser(w, r)
-			} else {
-				userHandler.GetUserByID(w, r)
-			}
-		case http.MethodPut:
-			userHandler.UpdateDataUser(w, r)
-		case http.MethodDelete:
-			userHandler.DeleteDataUser(w, r)
-		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	// Emails are delivered through the configured mailer
+	mail := loadMailer(cfg.Mail)
+
+	emailVerifier := auth.NewEmailVerifier(keyManager, mail, cfg.Links.EmailVerificationURL, cfg.Links.RequireEmailVerification)
+
+	mfaService := auth.NewMFAService(repository.NewMFARepository(db), loadMFASecretBox(cfg.MFA.EncryptionKey), keyManager, cfg.MFA.Issuer)
+
+	// Failed logins are counted per username and per client IP
+	loginThrottle := auth.NewLoginThrottle(repository.NewLoginAttemptRepository(db), cfg.Login.MaxFailures, cfg.Login.LockoutBaseDelay, cfg.Login.LockoutMaxDelay)
+
+	// Create an instance of UserHandler with the repositories
+	userHandler := handler.NewUserHandler(userRepo, refreshTokenRepo, roleRepo, revocationStore, keyManager, emailVerifier, mfaService, loginThrottle, db)
+	roleHandler := handler.NewRoleHandler(roleRepo, userRepo, auditRepo, revocationStore, db)
+	jwksHandler := handler.NewJWKSHandler(keyManager)
+	lockoutHandler := handler.NewLockoutHandler(loginThrottle, userRepo, auditRepo, db)
+	impersonationHandler := handler.NewImpersonationHandler(userHandler, auditRepo)
+	passwordResetHandler := handler.NewPasswordResetHandler(userRepo, passwordResetRepo, refreshTokenRepo, revocationStore, mail, cfg.Links.PasswordResetURL, db)
+	magicLinkHandler := handler.NewMagicLinkHandler(repository.NewMagicLinkRepository(db), userHandler, mail, cfg.Links.MagicLinkURL)
+
+	// Initialize the RateLimiter middleware, by default
+	// 10 requests per minute per IP plus a burst of 5
+	rateLimiter := middleware.NewRateLimiter(cfg.RateLimit.Requests, cfg.RateLimit.Burst, cfg.RateLimit.Window)
+
+	// API keys let scripts call the user endpoints without logging in
+	apiKeyRepo := repository.NewAPIKeyRepository(db)
+	apiKeyHandler := handler.NewAPIKeyHandler(apiKeyRepo)
+
+	tokenValidator := middleware.NewTokenValidator(keyManager, revocationStore, auth.NewAPIKeyAuthenticator(apiKeyRepo, roleRepo, userRepo))
+
+	deps := server.Deps{
+		Users:         userHandler,
+		Roles:         roleHandler,
+		APIKeys:       apiKeyHandler,
+		PasswordReset: passwordResetHandler,
+		MagicLinks:    magicLinkHandler,
+		Lockout:       lockoutHandler,
+		Impersonation: impersonationHandler,
+		JWKS:          jwksHandler,
+		Tokens:        tokenValidator,
+		RateLimiter:   rateLimiter,
+	}
+
+	// single sign-on through the company identity provider, when configured
+	if cfg.OIDC.Issuer != "" {
+		deps.OIDC = loadOIDCHandler(cfg.OIDC, repository.NewIdentityRepository(db), userHandler)
+	}
+
+	serverConfig := server.Config{
+		Addr:              ":" + strconv.Itoa(cfg.HTTP.Port),
+		ReadTimeout:       cfg.HTTP.ReadTimeout,
+		ReadHeaderTimeout: cfg.HTTP.ReadHeaderTimeout,
+		WriteTimeout:      cfg.HTTP.WriteTimeout,
+		IdleTimeout:       cfg.HTTP.IdleTimeout,
+		MaxHeaderBytes:    cfg.HTTP.MaxHeaderBytes,
+		ShutdownTimeout:   cfg.HTTP.ShutdownTimeout,
+	}
+	srv := server.NewHTTPServer(serverConfig, server.NewServer(deps))
+
+	// SIGINT or SIGTERM stops accepting connections and lets the requests
+	// in flight finish their transactions before the database is closed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := server.Run(ctx, srv, serverConfig.ShutdownTimeout); err != nil {
+		log.Println("error running server: ", err)
+	}
+
+	if err := db.Close(); err != nil {
+		log.Println("error closing database: ", err)
+	}
+	log.Println("server stopped")
+}
+
+// loadMailer picks the mail delivery of cfg.Driver: smtp, file or log.
+func loadMailer(cfg config.MailConfig) mailer.Mailer {
+	switch cfg.Driver {
+	case "smtp":
+		return mailer.NewSMTPMailer(cfg.SMTPHost, strconv.Itoa(cfg.SMTPPort), cfg.SMTPUsername, cfg.SMTPPassword, cfg.From)
+	case "file":
+		return mailer.NewFileMailer(cfg.FilePath, cfg.From)
+	default:
+		return mailer.NewLogMailer(cfg.From)
+	}
+}
+
+// loadMFASecretBox uses encoded, a base64 encoded 32 byte key, to encrypt
+// TOTP secrets. Without it a random key is used, which makes every
+// enrollment unusable after a restart.
+func loadMFASecretBox(encoded string) *auth.SecretBox {
+	if encoded == "" {
+		log.Println("WARNING: MFA_ENCRYPTION_KEY is not set, using an ephemeral key. Two-factor enrollments will not survive a restart.")
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic(err)
+		}
+		box, err := auth.NewSecretBox(key)
+		if err != nil {
+			panic(err)
 		}
-	}))))
+		return box
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		panic(fmt.Sprintf("invalid MFA_ENCRYPTION_KEY: %v", err))
+	}
+	box, err := auth.NewSecretBox(key)
+	if err != nil {
+		panic(err)
+	}
+	return box
+}
+
+// loadOIDCHandler discovers the provider at startup, so a wrong issuer is
+// noticed right away. The client secret may be empty for public clients.
+func loadOIDCHandler(cfg config.OIDCConfig, identities repository.IdentityRepository, userHandler *handler.UserHandler) *handler.OIDCHandler {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
 
-	http.HandleFunc("/api/v1/login", userHandler.Authentication)
+	provider, err := auth.DiscoverOIDCProvider(ctx, cfg.Issuer, cfg.ClientID, cfg.ClientSecret, cfg.RedirectURL, nil)
+	if err != nil {
+		panic(err)
+	}
 
-	http.HandleFunc("/api/v1/register", userHandler.Register)
+	return handler.NewOIDCHandler(provider, identities, userHandler, strings.HasPrefix(cfg.RedirectURL, "https://"))
+}
 
-	PORT := "8080"
-	http.ListenAndServe(":"+PORT, nil)
+// loadKeyManager loads the keys from cfg.KeysDir. Without it a throwaway key
+// is generated, which is fine for local development only.
+func loadKeyManager(cfg config.JWTConfig) *auth.KeyManager {
+	if cfg.KeysDir == "" {
+		log.Println("WARNING: JWT_KEYS_DIR is not set, using an ephemeral signing key. Tokens will not survive a restart.")
+		keyManager, err := auth.NewEphemeralKeyManager()
+		if err != nil {
+			panic(err)
+		}
+		return keyManager
+	}
+
+	keyManager, err := auth.LoadKeyManager(cfg.KeysDir, cfg.SigningKeyId)
+	if err != nil {
+		panic(err)
+	}
+	return keyManager
 }
\ No newline at end of file
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"go-crud-database/config"
+	"go-crud-database/migrations"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+const migrateUsage = `usage: migrate <command>
+
+  up            apply every pending migration
+  down [steps]  roll back the last steps migrations, default 1
+  goto version  apply or roll back until version is the last applied, 0 rolls back everything
+  status        list the migrations and when they were applied`
+
+// runCommand runs a command given after the flags instead of the server.
+func runCommand(cfg *config.Config, db *sql.DB, args []string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch args[0] {
+	case "migrate":
+		return runMigrate(ctx, db, args[1:])
+	case "seed":
+		return runSeed(ctx, cfg, db, args[1:])
+	default:
+		return fmt.Errorf("unknown command %q, the commands are: migrate, seed", args[0])
+	}
+}
+
+func runMigrate(ctx context.Context, db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case args[0] == "up" && len(args) == 1:
+		done, err := migrator.Up(ctx)
+		printMigrations("applied", done)
+		return err
+	case args[0] == "down" && len(args) <= 2:
+		steps := 1
+		if len(args) == 2 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+		done, err := migrator.Down(ctx, steps)
+		printMigrations("rolled back", done)
+		return err
+	case args[0] == "goto" && len(args) == 2:
+		version, err := strconv.Atoi(args[1])
+		if err != nil || version < 0 {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		done, err := migrator.Goto(ctx, version)
+		printMigrations("migrated", done)
+		return err
+	case args[0] == "status" && len(args) == 1:
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		printStatus(statuses)
+		return nil
+	default:
+		return errors.New(migrateUsage)
+	}
+}
+
+// migrateOnStart applies the pending migrations before the server starts.
+// Other instances starting at the same time wait for the advisory lock.
+func migrateOnStart(db *sql.DB) error {
+	migrator, err := migrations.New(db)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	done, err := migrator.Up(ctx)
+	for _, migration := range done {
+		log.Printf("applied migration %04d_%s", migration.Version, migration.Name)
+	}
+	return err
+}
+
+func printMigrations(verb string, done []migrations.Migration) {
+	if len(done) == 0 {
+		fmt.Println("nothing to do")
+	}
+	for _, migration := range done {
+		fmt.Printf("%s %04d_%s\n", verb, migration.Version, migration.Name)
+	}
+}
+
+func printStatus(statuses []migrations.Status) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT\tNOTE")
+	for _, status := range statuses {
+		appliedAt, note := "pending", ""
+		if status.AppliedAt != nil {
+			appliedAt = status.AppliedAt.Format(time.RFC3339)
+		}
+		switch {
+		case status.Up == "":
+			note = "no migration file"
+		case status.ChecksumChanged:
+			note = "changed after it was applied"
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\t%s\n", status.Version, status.Name, appliedAt, note)
+	}
+	w.Flush()
+}
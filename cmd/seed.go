@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"go-crud-database/config"
+	"go-crud-database/repository"
+	"go-crud-database/seed"
+	"go-crud-database/utils"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// runSeed creates fake users: seed [-n 10] [-seed 1] [-fixture name] [-fast] [-print]
+func runSeed(ctx context.Context, cfg *config.Config, db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	n := fs.Int("n", 10, "number of users to generate")
+	seedValue := fs.Int64("seed", 1, "seed of the generated users, the same seed creates the same users")
+	fixture := fs.String("fixture", "", "create a fixture set instead of generated users: "+strings.Join(seed.FixtureNames(), ", "))
+	fast := fs.Bool("fast", false, "hash passwords with weak Argon2 parameters, they are upgraded on the next login")
+	printUsers := fs.Bool("print", false, "print the usernames and passwords")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	if *n < 0 {
+		return fmt.Errorf("invalid number of users %d", *n)
+	}
+
+	users := seed.Generate(*seedValue, *n)
+	if *fixture != "" {
+		var err error
+		users, err = seed.Fixture(*fixture)
+		if err != nil {
+			return err
+		}
+	}
+
+	if *fast {
+		if err := utils.SetArgon2Params(utils.FastArgon2Params); err != nil {
+			return err
+		}
+	}
+
+	seeder := seed.NewSeeder(db, repository.NewUserRepository(db), repository.NewRoleRepository(db), cfg.Env)
+	created, skipped, err := seeder.Seed(ctx, users)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("created %d users, skipped %d that exist already\n", created, skipped)
+	if *printUsers {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "USERNAME\tEMAIL\tPASSWORD\tADMIN\tROLES")
+		for _, user := range users {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%s\n", user.Username, user.Email, user.Password, user.IsAdmin, strings.Join(user.Roles, ","))
+		}
+		w.Flush()
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"go-crud-database/auth"
+	"go-crud-database/config"
+	"go-crud-database/repository"
+	"go-crud-database/userctl"
+	"go-crud-database/utils"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	// the same configuration as the server, so it finds the same database
+	if err := config.LoadEnv(".env"); err != nil {
+		log.Fatal(err)
+	}
+
+	cfg, args, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := utils.SetArgon2Params(cfg.Argon2); err != nil {
+		log.Fatal(err)
+	}
+	if err := utils.SetPasswordPolicy(cfg.PasswordPolicy); err != nil {
+		log.Fatal(err)
+	}
+
+	db := config.ConnectToDB(cfg.DB)
+
+	app := &userctl.App{
+		DB:          db,
+		Users:       repository.NewUserRepository(db),
+		APIKeys:     repository.NewAPIKeyRepository(db),
+		Audit:       repository.NewAuditRepository(db),
+		Revocations: auth.NewRevocationStore(repository.NewRevocationRepository(db)),
+		Operator:    os.Getenv("USER"),
+		In:          os.Stdin,
+		Out:         os.Stdout,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err = app.Run(ctx, args)
+	stop()
+	db.Close()
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		log.Fatal(err)
+	}
+}
@@ -3,17 +3,18 @@ package config
 import (
 	"bufio"
 	"database/sql"
-	"log"
+	"errors"
+	"fmt"
 	"os"
+	"strconv"
 	"strings"
-	"time"
 
 	_ "github.com/lib/pq"
 )
 
-func ConnectToDB() *sql.DB {
+func ConnectToDB(cfg DBConfig) *sql.DB {
 
-	connStr := "user=" + os.Getenv("DB_USER") + " password=" + os.Getenv("DB_PASSWORD") + " dbname=" + os.Getenv("DB_NAME") + " sslmode=" + os.Getenv("DB_SSLMODE") + " host=" + os.Getenv("DB_HOST") + " port=" + os.Getenv("DB_PORT")
+	connStr := "user=" + quoteConnValue(cfg.User) + " password=" + quoteConnValue(cfg.Password) + " dbname=" + quoteConnValue(cfg.Name) + " sslmode=" + quoteConnValue(cfg.SSLMode) + " host=" + quoteConnValue(cfg.Host) + " port=" + strconv.Itoa(cfg.Port)
 
 	db, err := sql.Open("postgres", connStr)
 	if err != nil {
@@ -24,48 +25,99 @@ func ConnectToDB() *sql.DB {
 		panic(err)
 	}
 
-	// Create a user table if it doesn't exist
-	createTableQuery := `CREATE TABLE IF NOT EXISTS users (
-		user_id serial primary key,
-        username varchar(50) unique not null,
-        email varchar(100) unique not null,
-		password varchar(255) not null,
-		is_admin boolean default false,
-        created_at timestamp default current_timestamp,
-		updated_at timestamp default current_timestamp
-	);`
-
-	_, err = db.Exec(createTableQuery)
-	if err != nil {
-		log.Fatalf("Error creating table: %v", err)
-	}
-
 	// database pooling
-	db.SetMaxIdleConns(10)                  // jumlah minimal koneksi yg dibuat
-	db.SetMaxOpenConns(100)                 // jumlah maksimal koneksi yg dibuat
-	db.SetConnMaxIdleTime(5 * time.Minute)  // jika dalam waktu tertentu tdk digunakan maka akan dihapus
-	db.SetConnMaxLifetime(60 * time.Minute) // membuat koneksi baru setelah waktu yg telah ditentukan
+	db.SetMaxIdleConns(cfg.MaxIdleConns)       // jumlah minimal koneksi yg dibuat
+	db.SetMaxOpenConns(cfg.MaxOpenConns)       // jumlah maksimal koneksi yg dibuat
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime) // jika dalam waktu tertentu tdk digunakan maka akan dihapus
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime) // membuat koneksi baru setelah waktu yg telah ditentukan
 
 	return db
 }
 
+// quoteConnValue quotes a value of the connection string, so passwords with
+// spaces or quotes work.
+func quoteConnValue(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return "'" + value + "'"
+}
+
+// LoadEnv sets the variables of a .env file that are not set yet, so the real
+// environment wins. A missing file is not an error. Lines may start with
+// "export", values may be quoted; double quoted values understand escapes
+// like \n, single quoted ones are taken literally. An unquoted value ends at
+// " #".
 func LoadEnv(filename string) error {
 	file, err := os.Open(filename)
 	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
 		return err
 	}
 	defer file.Close()
 
 	scanner := bufio.NewScanner(file)
+	lineNumber := 0
 	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.TrimSpace(line) != "" && !strings.HasPrefix(line, "#") {
-			parts := strings.SplitN(line, "=", 2)
-			if len(parts) == 2 {
-				os.Setenv(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
-			}
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return fmt.Errorf("%s:%d: expected KEY=value", filename, lineNumber)
+		}
+
+		value, err := parseEnvValue(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", filename, lineNumber, err)
+		}
+
+		if _, exists := os.LookupEnv(key); !exists {
+			os.Setenv(key, value)
 		}
 	}
 
 	return scanner.Err()
 }
+
+func parseEnvValue(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		end := closingQuote(value)
+		if end < 0 {
+			return "", fmt.Errorf("missing closing quote")
+		}
+		return strconv.Unquote(value[:end+1])
+	case strings.HasPrefix(value, "'"):
+		end := strings.Index(value[1:], "'")
+		if end < 0 {
+			return "", fmt.Errorf("missing closing quote")
+		}
+		return value[1 : end+1], nil
+	default:
+		if i := strings.Index(value, " #"); i >= 0 {
+			value = value[:i]
+		}
+		return strings.TrimSpace(value), nil
+	}
+}
+
+// closingQuote returns the index of the quote that ends the double quoted
+// string at the start of value, or -1.
+func closingQuote(value string) int {
+	for i := 1; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,178 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// readConfigFile reads a YAML or TOML file into setting names. Nested keys
+// are joined with an underscore, so "db: {host: x}" in YAML or "[db]
+// host = x" in TOML both set DB_HOST. Only the part of the formats that
+// settings need is supported: nested maps with strings, numbers and booleans.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var values map[string]string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		values, err = parseYAML(string(data))
+	case ".toml":
+		values, err = parseTOML(string(data))
+	default:
+		return nil, fmt.Errorf("%s: %w", path, errUnsupportedConfigFile)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", path, err)
+	}
+
+	return values, nil
+}
+
+func parseYAML(data string) (map[string]string, error) {
+	type section struct {
+		indent int
+		key    string
+	}
+
+	values := map[string]string{}
+	var sections []section
+
+	for i, raw := range strings.Split(data, "\n") {
+		line := strings.TrimRight(stripComment(raw), " \r")
+		content := strings.TrimLeft(line, " ")
+		if content == "" || content == "---" {
+			continue
+		}
+
+		indent := len(line) - len(content)
+		if strings.HasPrefix(content, "\t") {
+			return nil, fmt.Errorf("%d: tabs can't be used for indentation", i+1)
+		}
+		if strings.HasPrefix(content, "- ") || content == "-" {
+			return nil, fmt.Errorf("%d: lists are not supported", i+1)
+		}
+
+		key, value, ok := strings.Cut(content, ":")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("%d: expected key: value", i+1)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		for len(sections) > 0 && sections[len(sections)-1].indent >= indent {
+			sections = sections[:len(sections)-1]
+		}
+
+		if value == "" {
+			sections = append(sections, section{indent: indent, key: key})
+			continue
+		}
+
+		path := make([]string, 0, len(sections)+1)
+		for _, s := range sections {
+			path = append(path, s.key)
+		}
+
+		unquoted, err := unquote(value)
+		if err != nil {
+			return nil, fmt.Errorf("%d: %v", i+1, err)
+		}
+		values[settingName(append(path, key))] = unquoted
+	}
+
+	return values, nil
+}
+
+func parseTOML(data string) (map[string]string, error) {
+	values := map[string]string{}
+	var table []string
+
+	for i, raw := range strings.Split(data, "\n") {
+		line := strings.TrimSpace(stripComment(raw))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if strings.HasPrefix(line, "[[") || !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("%d: only [table] headers are supported", i+1)
+			}
+			table = strings.Split(strings.TrimSpace(line[1:len(line)-1]), ".")
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("%d: expected key = value", i+1)
+		}
+		value = strings.TrimSpace(value)
+		if strings.HasPrefix(value, "[") || strings.HasPrefix(value, "{") || strings.HasPrefix(value, `"""`) || strings.HasPrefix(value, "'''") {
+			return nil, fmt.Errorf("%d: arrays, inline tables and multi-line strings are not supported", i+1)
+		}
+
+		unquoted, err := unquote(value)
+		if err != nil {
+			return nil, fmt.Errorf("%d: %v", i+1, err)
+		}
+
+		path := append(append([]string{}, table...), strings.Split(strings.TrimSpace(key), ".")...)
+		values[settingName(path)] = unquoted
+	}
+
+	return values, nil
+}
+
+// settingName turns the key path db, max-open-conns into DB_MAX_OPEN_CONNS.
+func settingName(path []string) string {
+	for i, key := range path {
+		path[i] = strings.TrimSpace(key)
+	}
+	return strings.ToUpper(strings.ReplaceAll(strings.Join(path, "_"), "-", "_"))
+}
+
+// unquote returns the value of a double quoted string with escapes, a single
+// quoted string taken literally, or a plain value as is.
+func unquote(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid string %s", value)
+		}
+		return unquoted, nil
+	case strings.HasPrefix(value, "'"):
+		if len(value) < 2 || !strings.HasSuffix(value, "'") {
+			return "", fmt.Errorf("invalid string %s", value)
+		}
+		return value[1 : len(value)-1], nil
+	default:
+		return value, nil
+	}
+}
+
+// stripComment removes a # comment that starts the line or follows a space,
+// unless it is inside quotes.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
@@ -0,0 +1,349 @@
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"go-crud-database/utils"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ValidationError lists every setting that is missing or invalid, so all of
+// them can be fixed at once.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// setting binds a name to a field of Config. The name is used as is for
+// environment variables and file keys, and as lower case with dashes for
+// flags (DB_HOST and -db-host).
+type setting struct {
+	name  string
+	usage string
+	set   func(value string) error
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{"APP_ENV", "environment: dev, test or prod", stringValue(&c.Env)},
+
+		{"DB_HOST", "database host", stringValue(&c.DB.Host)},
+		{"DB_PORT", "database port", intValue(&c.DB.Port)},
+		{"DB_USER", "database user", stringValue(&c.DB.User)},
+		{"DB_PASSWORD", "database password", stringValue(&c.DB.Password)},
+		{"DB_NAME", "database name", stringValue(&c.DB.Name)},
+		{"DB_SSLMODE", "sslmode of the database connection", stringValue(&c.DB.SSLMode)},
+		{"DB_MAX_OPEN_CONNS", "maximum open database connections", intValue(&c.DB.MaxOpenConns)},
+		{"DB_MAX_IDLE_CONNS", "maximum idle database connections", intValue(&c.DB.MaxIdleConns)},
+		{"DB_CONN_MAX_IDLE_TIME", "close database connections idle for this long", durationValue(&c.DB.ConnMaxIdleTime)},
+		{"DB_CONN_MAX_LIFETIME", "replace database connections after this long", durationValue(&c.DB.ConnMaxLifetime)},
+		{"DB_AUTO_MIGRATE", "apply pending migrations when the server starts", boolValue(&c.DB.AutoMigrate)},
+
+		{"PORT", "HTTP port", intValue(&c.HTTP.Port)},
+		{"HTTP_READ_TIMEOUT", "time to read a whole request", durationValue(&c.HTTP.ReadTimeout)},
+		{"HTTP_READ_HEADER_TIMEOUT", "time to read the request headers", durationValue(&c.HTTP.ReadHeaderTimeout)},
+		{"HTTP_WRITE_TIMEOUT", "time to write the response", durationValue(&c.HTTP.WriteTimeout)},
+		{"HTTP_IDLE_TIMEOUT", "keep-alive connections are closed after this long", durationValue(&c.HTTP.IdleTimeout)},
+		{"HTTP_MAX_HEADER_BYTES", "maximum size of the request headers", intValue(&c.HTTP.MaxHeaderBytes)},
+		{"SHUTDOWN_TIMEOUT", "time the requests in flight get on shutdown", durationValue(&c.HTTP.ShutdownTimeout)},
+
+		{"RATE_LIMIT_REQUESTS", "requests per client IP and window", intValue(&c.RateLimit.Requests)},
+		{"RATE_LIMIT_BURST", "extra requests per client IP and window", intValue(&c.RateLimit.Burst)},
+		{"RATE_LIMIT_WINDOW", "length of the rate limit window", durationValue(&c.RateLimit.Window)},
+
+		{"JWT_KEYS_DIR", "directory with the JWT signing keys", stringValue(&c.JWT.KeysDir)},
+		{"JWT_SIGNING_KEY_ID", "key id used to sign new tokens", stringValue(&c.JWT.SigningKeyId)},
+
+		{"MAIL_DRIVER", "mail delivery: log, file or smtp", stringValue(&c.Mail.Driver)},
+		{"MAIL_FROM", "sender of the emails", stringValue(&c.Mail.From)},
+		{"MAIL_FILE_PATH", "file the file driver appends to", stringValue(&c.Mail.FilePath)},
+		{"SMTP_HOST", "SMTP server", stringValue(&c.Mail.SMTPHost)},
+		{"SMTP_PORT", "SMTP port", intValue(&c.Mail.SMTPPort)},
+		{"SMTP_USERNAME", "SMTP user", stringValue(&c.Mail.SMTPUsername)},
+		{"SMTP_PASSWORD", "SMTP password", stringValue(&c.Mail.SMTPPassword)},
+
+		{"EMAIL_VERIFICATION_URL", "target of the email verification links", stringValue(&c.Links.EmailVerificationURL)},
+		{"REQUIRE_EMAIL_VERIFICATION", "refuse logins with an unverified email", boolValue(&c.Links.RequireEmailVerification)},
+		{"PASSWORD_RESET_URL", "target of the password reset links", stringValue(&c.Links.PasswordResetURL)},
+		{"MAGIC_LINK_URL", "target of the sign-in links", stringValue(&c.Links.MagicLinkURL)},
+
+		{"MFA_ISSUER", "issuer shown in authenticator apps", stringValue(&c.MFA.Issuer)},
+		{"MFA_ENCRYPTION_KEY", "base64 encoded 32 byte key for TOTP secrets", stringValue(&c.MFA.EncryptionKey)},
+
+		{"LOGIN_MAX_FAILURES", "failed logins before the lockout starts", intValue(&c.Login.MaxFailures)},
+		{"LOGIN_LOCKOUT_BASE_DELAY", "first lockout", durationValue(&c.Login.LockoutBaseDelay)},
+		{"LOGIN_LOCKOUT_MAX_DELAY", "longest lockout", durationValue(&c.Login.LockoutMaxDelay)},
+
+		{"OIDC_ISSUER", "OpenID Connect issuer, enables single sign-on", stringValue(&c.OIDC.Issuer)},
+		{"OIDC_CLIENT_ID", "OpenID Connect client id", stringValue(&c.OIDC.ClientID)},
+		{"OIDC_CLIENT_SECRET", "OpenID Connect client secret", stringValue(&c.OIDC.ClientSecret)},
+		{"OIDC_REDIRECT_URL", "OpenID Connect redirect URL", stringValue(&c.OIDC.RedirectURL)},
+
+		{"ARGON2_MEMORY", "Argon2id memory in KiB", uint32Value(&c.Argon2.Memory)},
+		{"ARGON2_ITERATIONS", "Argon2id iterations", uint32Value(&c.Argon2.Iterations)},
+		{"ARGON2_PARALLELISM", "Argon2id parallelism", uint8Value(&c.Argon2.Parallelism)},
+
+		{"PASSWORD_POLICY_FILE", "JSON password policy", stringValue(&c.PasswordPolicyFile)},
+		{"PASSWORD_MIN_LENGTH", "minimum password length", intValue(&c.PasswordPolicy.MinLength)},
+		{"PASSWORD_MAX_LENGTH", "maximum password length in bytes", intValue(&c.PasswordPolicy.MaxLength)},
+		{"PASSWORD_REQUIRE_UPPER", "passwords need an upper case letter", boolValue(&c.PasswordPolicy.RequireUpper)},
+		{"PASSWORD_REQUIRE_LOWER", "passwords need a lower case letter", boolValue(&c.PasswordPolicy.RequireLower)},
+		{"PASSWORD_REQUIRE_DIGIT", "passwords need a digit", boolValue(&c.PasswordPolicy.RequireDigit)},
+		{"PASSWORD_REQUIRE_SYMBOL", "passwords need a symbol", boolValue(&c.PasswordPolicy.RequireSymbol)},
+		{"PASSWORD_DISALLOW_USER_INFO", "passwords can't contain the username or email", boolValue(&c.PasswordPolicy.DisallowUserInfo)},
+		{"PASSWORD_BREACHED_DIR", "directory with breached password hash ranges", stringValue(&c.PasswordPolicy.BreachedDir)},
+	}
+}
+
+// Load reads the configuration. The layers, each overriding the one before:
+//
+//   - the defaults of Default
+//   - the file given with -config or CONFIG_FILE, YAML or TOML
+//   - environment variables, or NAME_FILE naming a file that holds the value
+//   - command-line flags, like -db-host
+//
+// It returns the arguments left after the flags. All problems are collected
+// into one *ValidationError.
+func Load(args []string) (*Config, []string, error) {
+	cfg := Default()
+	settings := cfg.settings()
+
+	fs := flag.NewFlagSet("go-crud-database", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML configuration file")
+	flags := map[string]*string{}
+	for _, s := range settings {
+		flags[s.name] = fs.String(flagName(s.name), "", s.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	values := map[string]string{}
+	var problems []string
+
+	if *configFile != "" {
+		fileValues, err := readConfigFile(*configFile)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+		for name, value := range fileValues {
+			if !slices.ContainsFunc(settings, func(s setting) bool { return s.name == name }) {
+				problems = append(problems, fmt.Sprintf("%s: unknown setting %s", *configFile, name))
+				continue
+			}
+			values[name] = value
+		}
+	}
+
+	for _, s := range settings {
+		value, ok := os.LookupEnv(s.name)
+		path, fromFile := os.LookupEnv(s.name + "_FILE")
+		switch {
+		case ok && fromFile:
+			problems = append(problems, fmt.Sprintf("%s and %s_FILE are both set", s.name, s.name))
+			continue
+		case fromFile:
+			data, err := os.ReadFile(path)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s_FILE: %v", s.name, err))
+				continue
+			}
+			// editors and echo add a trailing newline that is not part of the secret
+			value, ok = strings.TrimRight(string(data), "\r\n"), true
+		}
+		if ok {
+			values[s.name] = value
+		}
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if flagName(s.name) == f.Name {
+				values[s.name] = *flags[s.name]
+			}
+		}
+	})
+
+	// the policy file is the base the PASSWORD_* settings change
+	if path := values["PASSWORD_POLICY_FILE"]; path != "" {
+		policy, err := utils.LoadPasswordPolicy(path)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("PASSWORD_POLICY_FILE: %v", err))
+		} else {
+			cfg.PasswordPolicy = policy
+		}
+	}
+
+	for _, s := range settings {
+		value, ok := values[s.name]
+		if !ok {
+			continue
+		}
+		if err := s.set(value); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", s.name, err))
+		}
+	}
+
+	problems = append(problems, cfg.validate()...)
+	if len(problems) > 0 {
+		return nil, nil, &ValidationError{Problems: problems}
+	}
+
+	return &cfg, fs.Args(), nil
+}
+
+// validate returns the settings that are missing or don't fit together.
+func (c *Config) validate() []string {
+	var problems []string
+	require := func(name, value string) {
+		if strings.TrimSpace(value) == "" {
+			problems = append(problems, name+" is required")
+		}
+	}
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	require("APP_ENV", c.Env)
+	if c.Env != "" {
+		check(c.Env == EnvDev || c.Env == EnvTest || c.Env == EnvProd, "APP_ENV must be dev, test or prod, got %q", c.Env)
+	}
+
+	require("DB_HOST", c.DB.Host)
+	require("DB_USER", c.DB.User)
+	require("DB_NAME", c.DB.Name)
+	check(c.DB.Port > 0 && c.DB.Port <= 65535, "DB_PORT must be a port number, got %d", c.DB.Port)
+	check(c.DB.MaxOpenConns > 0, "DB_MAX_OPEN_CONNS must be at least 1")
+	check(c.DB.MaxIdleConns >= 0 && c.DB.MaxIdleConns <= c.DB.MaxOpenConns, "DB_MAX_IDLE_CONNS must be between 0 and DB_MAX_OPEN_CONNS")
+	check(c.DB.ConnMaxIdleTime > 0, "DB_CONN_MAX_IDLE_TIME must be positive")
+	check(c.DB.ConnMaxLifetime > 0, "DB_CONN_MAX_LIFETIME must be positive")
+
+	check(c.HTTP.Port > 0 && c.HTTP.Port <= 65535, "PORT must be a port number, got %d", c.HTTP.Port)
+	check(c.HTTP.ReadTimeout > 0, "HTTP_READ_TIMEOUT must be positive")
+	check(c.HTTP.ReadHeaderTimeout > 0, "HTTP_READ_HEADER_TIMEOUT must be positive")
+	check(c.HTTP.WriteTimeout > 0, "HTTP_WRITE_TIMEOUT must be positive")
+	check(c.HTTP.IdleTimeout > 0, "HTTP_IDLE_TIMEOUT must be positive")
+	check(c.HTTP.MaxHeaderBytes > 0, "HTTP_MAX_HEADER_BYTES must be positive")
+	check(c.HTTP.ShutdownTimeout > 0, "SHUTDOWN_TIMEOUT must be positive")
+
+	check(c.RateLimit.Requests > 0, "RATE_LIMIT_REQUESTS must be at least 1")
+	check(c.RateLimit.Burst >= 0, "RATE_LIMIT_BURST can't be negative")
+	check(c.RateLimit.Window > 0, "RATE_LIMIT_WINDOW must be positive")
+
+	switch c.Mail.Driver {
+	case "log", "file":
+	case "smtp":
+		require("SMTP_HOST", c.Mail.SMTPHost)
+		check(c.Mail.SMTPPort > 0 && c.Mail.SMTPPort <= 65535, "SMTP_PORT must be a port number, got %d", c.Mail.SMTPPort)
+	default:
+		problems = append(problems, fmt.Sprintf("MAIL_DRIVER must be log, file or smtp, got %q", c.Mail.Driver))
+	}
+
+	if c.MFA.EncryptionKey != "" {
+		key, err := base64.StdEncoding.DecodeString(c.MFA.EncryptionKey)
+		check(err == nil && len(key) == 32, "MFA_ENCRYPTION_KEY must be a base64 encoded 32 byte key")
+	}
+
+	check(c.Login.MaxFailures > 0, "LOGIN_MAX_FAILURES must be at least 1")
+	check(c.Login.LockoutBaseDelay > 0, "LOGIN_LOCKOUT_BASE_DELAY must be positive")
+	check(c.Login.LockoutMaxDelay >= c.Login.LockoutBaseDelay, "LOGIN_LOCKOUT_MAX_DELAY can't be shorter than LOGIN_LOCKOUT_BASE_DELAY")
+
+	if c.OIDC.Issuer != "" {
+		require("OIDC_CLIENT_ID", c.OIDC.ClientID)
+	}
+
+	check(c.Argon2.Validate() == nil, "ARGON2_MEMORY must be at least 8 KiB per ARGON2_PARALLELISM, ARGON2_ITERATIONS and ARGON2_PARALLELISM at least 1")
+	check(c.PasswordPolicy.Validate() == nil, "PASSWORD_MIN_LENGTH must be at least 1 and not above PASSWORD_MAX_LENGTH")
+
+	// the throwaway keys of local development lose every token and every
+	// two-factor enrollment on restart, and the log driver would put live
+	// reset and sign-in links into the server log
+	if c.Env == EnvProd {
+		require("JWT_KEYS_DIR", c.JWT.KeysDir)
+		require("MFA_ENCRYPTION_KEY", c.MFA.EncryptionKey)
+		check(c.Mail.Driver != "log", "MAIL_DRIVER must be file or smtp in prod, the log driver writes the links of the emails to the server log")
+	}
+
+	return problems
+}
+
+// flagName turns DB_HOST into db-host.
+func flagName(name string) string {
+	return strings.ReplaceAll(strings.ToLower(name), "_", "-")
+}
+
+func stringValue(p *string) func(string) error {
+	return func(value string) error {
+		*p = value
+		return nil
+	}
+}
+
+func intValue(p *int) func(string) error {
+	return func(value string) error {
+		parsed, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		*p = parsed
+		return nil
+	}
+}
+
+func uint32Value(p *uint32) func(string) error {
+	return func(value string) error {
+		parsed, err := strconv.ParseUint(strings.TrimSpace(value), 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid unsigned integer %q", value)
+		}
+		*p = uint32(parsed)
+		return nil
+	}
+}
+
+func uint8Value(p *uint8) func(string) error {
+	return func(value string) error {
+		parsed, err := strconv.ParseUint(strings.TrimSpace(value), 10, 8)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q, must be between 0 and 255", value)
+		}
+		*p = uint8(parsed)
+		return nil
+	}
+}
+
+func boolValue(p *bool) func(string) error {
+	return func(value string) error {
+		parsed, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		*p = parsed
+		return nil
+	}
+}
+
+// durationValue accepts Go durations like "30s" or "1h30m".
+func durationValue(p *time.Duration) func(string) error {
+	return func(value string) error {
+		parsed, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("invalid duration %q, use a value like 30s or 5m", value)
+		}
+		*p = parsed
+		return nil
+	}
+}
+
+var errUnsupportedConfigFile = errors.New("configuration files must end in .yaml, .yml or .toml")
@@ -0,0 +1,171 @@
+package config
+
+import (
+	"go-crud-database/auth"
+	"go-crud-database/utils"
+	"time"
+)
+
+// Environments the service knows. Production refuses the throwaway keys that
+// are generated for local development.
+const (
+	EnvDev  = "dev"
+	EnvTest = "test"
+	EnvProd = "prod"
+)
+
+// Config holds every setting of the service. Load fills it from defaults, an
+// optional YAML or TOML file, environment variables, *_FILE secret files and
+// command-line flags, in that order.
+type Config struct {
+	Env string
+
+	DB        DBConfig
+	HTTP      HTTPConfig
+	RateLimit RateLimitConfig
+	JWT       JWTConfig
+	Mail      MailConfig
+	Links     LinkConfig
+	MFA       MFAConfig
+	Login     LoginConfig
+	OIDC      OIDCConfig
+
+	Argon2 utils.Argon2Params
+	// PasswordPolicyFile is read before the PASSWORD_* settings, which
+	// override single rules of it
+	PasswordPolicyFile string
+	PasswordPolicy     utils.PasswordPolicy
+}
+
+type DBConfig struct {
+	Host            string
+	Port            int
+	User            string
+	Password        string
+	Name            string
+	SSLMode         string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxIdleTime time.Duration
+	ConnMaxLifetime time.Duration
+	// AutoMigrate applies pending migrations when the server starts
+	AutoMigrate bool
+}
+
+type HTTPConfig struct {
+	Port              int
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	ShutdownTimeout   time.Duration
+}
+
+// RateLimitConfig allows Requests plus Burst requests per client IP in every
+// Window.
+type RateLimitConfig struct {
+	Requests int
+	Burst    int
+	Window   time.Duration
+}
+
+type JWTConfig struct {
+	// KeysDir holds the signing keys, without it a throwaway key is used
+	KeysDir      string
+	SigningKeyId string
+}
+
+type MailConfig struct {
+	// Driver is log, file or smtp
+	Driver       string
+	From         string
+	FilePath     string
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+}
+
+// LinkConfig are the pages the links in emails point to.
+type LinkConfig struct {
+	EmailVerificationURL     string
+	RequireEmailVerification bool
+	PasswordResetURL         string
+	MagicLinkURL             string
+}
+
+type MFAConfig struct {
+	Issuer string
+	// EncryptionKey is a base64 encoded 32 byte key
+	EncryptionKey string
+}
+
+type LoginConfig struct {
+	MaxFailures      int
+	LockoutBaseDelay time.Duration
+	LockoutMaxDelay  time.Duration
+}
+
+// OIDCConfig enables single sign-on when Issuer is set.
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+}
+
+// Default returns the settings used when nothing else is configured.
+func Default() Config {
+	// APP_ENV has no default, a deployment that forgets it must not be
+	// taken for development
+	return Config{
+		DB: DBConfig{
+			Port:            5432,
+			SSLMode:         "require",
+			MaxOpenConns:    100,
+			MaxIdleConns:    10,
+			ConnMaxIdleTime: 5 * time.Minute,
+			ConnMaxLifetime: 60 * time.Minute,
+			AutoMigrate:     true,
+		},
+		HTTP: HTTPConfig{
+			Port:              8080,
+			ReadTimeout:       15 * time.Second,
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       60 * time.Second,
+			MaxHeaderBytes:    64 << 10,
+			ShutdownTimeout:   20 * time.Second,
+		},
+		RateLimit: RateLimitConfig{
+			Requests: 10,
+			Burst:    5,
+			Window:   time.Minute,
+		},
+		Mail: MailConfig{
+			Driver:   "log",
+			From:     "no-reply@localhost",
+			FilePath: "mail.log",
+			SMTPPort: 587,
+		},
+		Links: LinkConfig{
+			EmailVerificationURL: "http://localhost:8080/api/v1/verify-email",
+			PasswordResetURL:     "http://localhost:8080/reset-password",
+			MagicLinkURL:         "http://localhost:8080/magic-login",
+		},
+		MFA: MFAConfig{
+			Issuer: "go-crud-database",
+		},
+		Login: LoginConfig{
+			MaxFailures:      auth.DefaultMaxLoginFailures,
+			LockoutBaseDelay: auth.DefaultLockoutBaseDelay,
+			LockoutMaxDelay:  auth.DefaultLockoutMaxDelay,
+		},
+		OIDC: OIDCConfig{
+			RedirectURL: "http://localhost:8080/api/v1/login/oidc/callback",
+		},
+		Argon2:         utils.DefaultArgon2Params,
+		PasswordPolicy: utils.DefaultPasswordPolicy,
+	}
+}
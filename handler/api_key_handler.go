@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"go-crud-database/auth"
+	"go-crud-database/middleware"
+	"go-crud-database/models"
+	"go-crud-database/repository"
+	"go-crud-database/utils"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type APIKeyHandler struct {
+	repo repository.APIKeyRepository
+}
+
+func NewAPIKeyHandler(repo repository.APIKeyRepository) *APIKeyHandler {
+	return &APIKeyHandler{repo: repo}
+}
+
+// CreateAPIKey creates a key for the current user. The key is only returned
+// in this response, afterwards only its prefix is known.
+func (h *APIKeyHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJson(w, http.StatusMethodNotAllowed, "error", nil, "Method Not Allowed")
+		return
+	}
+
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Unauthorized")
+		return
+	}
+	userId := principal.UserId
+
+	var req models.CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Invalid request payload")
+		return
+	}
+
+	if msg, isValid := utils.ValidateCreateAPIKeyRequest(req); !isValid {
+		utils.WriteJson(w, http.StatusConflict, "error", nil, msg)
+		return
+	}
+
+	// a key can't be given more than its owner has
+	for _, scope := range req.Scopes {
+		if !middleware.HasPermission(r, scope) {
+			utils.WriteJson(w, http.StatusForbidden, "error", nil, "Forbidden: missing permission "+scope)
+			return
+		}
+	}
+
+	key, prefix, secretHash, err := auth.GenerateAPIKey()
+	if err != nil {
+		log.Println("error generating api key: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	apiKey := models.APIKey{
+		UserId:     userId,
+		Name:       strings.TrimSpace(req.Name),
+		Prefix:     prefix,
+		SecretHash: secretHash,
+		Scopes:     req.Scopes,
+		ExpiresAt:  req.ExpiresAt,
+	}
+	if apiKey.Scopes == nil {
+		apiKey.Scopes = []string{}
+	}
+
+	if err := h.repo.Create(r.Context(), &apiKey); err != nil {
+		log.Printf("Error creating api key of user with ID %d: %v", userId, err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	utils.WriteJson(w, http.StatusCreated, "success", models.CreateAPIKeyResponse{Key: key, APIKey: apiKey}, "API key created, it will not be shown again")
+}
+
+// ListAPIKeys returns the keys of the current user without their secrets.
+func (h *APIKeyHandler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteJson(w, http.StatusMethodNotAllowed, "error", nil, "Method Not Allowed")
+		return
+	}
+
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Unauthorized")
+		return
+	}
+	userId := principal.UserId
+
+	keys, err := h.repo.ListByUserId(r.Context(), userId)
+	if err != nil {
+		log.Printf("Error listing api keys of user with ID %d: %v", userId, err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	utils.WriteJson(w, http.StatusOK, "success", keys, "API keys retrieved successfully")
+}
+
+// RevokeAPIKey revokes one key of the current user, DELETE /api/v1/me/api-keys/{id}
+func (h *APIKeyHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		utils.WriteJson(w, http.StatusMethodNotAllowed, "error", nil, "Method Not Allowed")
+		return
+	}
+
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Unauthorized")
+		return
+	}
+	userId := principal.UserId
+
+	keyId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Invalid API key ID")
+		return
+	}
+
+	if err := h.repo.Revoke(r.Context(), userId, keyId); err != nil {
+		if err == sql.ErrNoRows {
+			utils.WriteJson(w, http.StatusNotFound, "error", nil, "API key not found")
+			return
+		}
+		log.Printf("Error revoking api key %d: %v", keyId, err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	utils.WriteJson(w, http.StatusOK, "success", nil, "API key revoked successfully")
+}
@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"go-crud-database/auth"
+	"go-crud-database/utils"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// VerifyEmail is the target of the link sent by auth.EmailVerifier.
+func (h *UserHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteJson(w, http.StatusMethodNotAllowed, "error", nil, "Method Not Allowed")
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "missing token")
+		return
+	}
+
+	userId, email, err := h.verifier.ParseVerificationToken(token)
+	if err != nil {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Invalid or expired verification link")
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("error starting transaction: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	// fails when the user was deleted or changed the email after the link was sent
+	if err := h.repo.MarkEmailVerified(ctx, tx, userId, email); err != nil {
+		if err == sql.ErrNoRows {
+			utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Invalid or expired verification link")
+			return
+		}
+		log.Printf("Error verifying email of user with ID %d: %v", userId, err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to commit transaction")
+		return
+	}
+
+	utils.WriteJson(w, http.StatusOK, "success", nil, "Email verified successfully")
+}
+
+// ResendVerification sends a new verification link to the current user.
+func (h *UserHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJson(w, http.StatusMethodNotAllowed, "error", nil, "Method Not Allowed")
+		return
+	}
+
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Unauthorized")
+		return
+	}
+	userId := principal.UserId
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	user, err := h.repo.GetUserById(ctx, nil, strconv.Itoa(userId))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.WriteJson(w, http.StatusNotFound, "error", nil, "User not found")
+			return
+		}
+		log.Println("error getting user by id: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	if user.EmailVerifiedAt != nil {
+		utils.WriteJson(w, http.StatusOK, "info", nil, "Email is already verified")
+		return
+	}
+
+	if err := h.verifier.SendVerification(user.UserId, user.Username, user.Email); err != nil {
+		log.Println("error sending verification email: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	utils.WriteJson(w, http.StatusOK, "success", nil, "Verification email sent")
+}
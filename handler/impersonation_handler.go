@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"go-crud-database/auth"
+	"go-crud-database/models"
+	"go-crud-database/repository"
+	"go-crud-database/utils"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// impersonation tokens expire after 15 minutes and can't be refreshed
+const impersonationTTL = 15 * time.Minute
+
+type ImpersonationHandler struct {
+	users     *UserHandler
+	auditRepo repository.AuditRepository
+}
+
+func NewImpersonationHandler(users *UserHandler, auditRepo repository.AuditRepository) *ImpersonationHandler {
+	return &ImpersonationHandler{users: users, auditRepo: auditRepo}
+}
+
+// Impersonate issues an access token for the user in the path with an act
+// claim naming the admin who asked for it. Every impersonation is written to
+// the audit log together with the reason.
+func (h *ImpersonationHandler) Impersonate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJson(w, http.StatusMethodNotAllowed, "error", nil, "Method Not Allowed")
+		return
+	}
+
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Unauthorized")
+		return
+	}
+	actorId := principal.UserId
+
+	userId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || userId <= 0 {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Invalid user ID")
+		return
+	}
+
+	if userId == actorId {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "You cannot impersonate yourself")
+		return
+	}
+
+	var req models.ImpersonateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Invalid request payload")
+		return
+	}
+
+	if msg, isValid := utils.ValidateImpersonateRequest(req); !isValid {
+		utils.WriteJson(w, http.StatusConflict, "error", nil, msg)
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := h.users.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("error starting transaction: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	user, err := h.users.repo.GetUserById(ctx, tx, strconv.Itoa(userId))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.WriteJson(w, http.StatusNotFound, "error", nil, "User not found")
+			return
+		}
+		log.Println("error getting user by id: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	roles, err := h.users.roleRepo.GetRolesByUserId(ctx, tx, userId)
+	if err != nil {
+		log.Println("error getting roles: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	// acting as another admin would hide who really did something
+	isAdmin := user.IsAdmin
+	for _, role := range roles {
+		if role == auth.RoleAdmin {
+			isAdmin = true
+		}
+	}
+	if isAdmin {
+		utils.WriteJson(w, http.StatusForbidden, "error", nil, "Admins cannot be impersonated")
+		return
+	}
+
+	permissions, err := h.users.roleRepo.GetPermissionsByUserId(ctx, tx, userId)
+	if err != nil {
+		log.Println("error getting permissions: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	accessToken, err := h.users.signAccessToken(userId, false, roles, permissions, impersonationTTL, actorId)
+	if err != nil {
+		log.Println("error signing impersonation token: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	err = h.auditRepo.Record(ctx, tx, &models.AuditLog{
+		ActorUserId:  actorId,
+		Action:       "user.impersonate",
+		TargetUserId: userId,
+		Details:      "username=" + user.Username + "; reason=" + req.Reason,
+	})
+	if err != nil {
+		log.Println("error writing audit log: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to commit transaction")
+		return
+	}
+
+	log.Printf("user %d started impersonating user %d", actorId, userId)
+
+	utils.WriteJson(w, http.StatusOK, "success", models.ImpersonationResponse{
+		AccessToken:    accessToken,
+		TokenType:      "Bearer",
+		ExpiresIn:      int(impersonationTTL.Seconds()),
+		UserId:         userId,
+		ImpersonatorId: actorId,
+	}, "Impersonation started")
+}
@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"encoding/json"
+	"go-crud-database/auth"
+	"go-crud-database/utils"
+	"net/http"
+)
+
+type JWKSHandler struct {
+	keys *auth.KeyManager
+}
+
+func NewJWKSHandler(keys *auth.KeyManager) *JWKSHandler {
+	return &JWKSHandler{keys: keys}
+}
+
+// GetJWKS publishes the public verification keys so other services can
+// verify our access tokens without sharing a secret.
+func (h *JWKSHandler) GetJWKS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteJson(w, http.StatusMethodNotAllowed, "error", nil, "Method Not Allowed")
+		return
+	}
+
+	// the key set is served as is, without the usual response envelope,
+	// because JWKS clients expect the format from RFC 7517
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(h.keys.JWKS())
+}
@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"go-crud-database/auth"
+	"go-crud-database/models"
+	"go-crud-database/repository"
+	"go-crud-database/utils"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// writeLocked answers with 423 when the account is locked and 429 when the
+// client is, and reports whether it did.
+func (h *UserHandler) writeLocked(w http.ResponseWriter, r *http.Request, username, ip string) bool {
+	reason, retryAfter, err := h.throttle.Check(r.Context(), username, ip)
+	if err != nil {
+		log.Println("error checking failed logins: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return true
+	}
+
+	switch reason {
+	case auth.LockedAccount:
+		w.Header().Set("Retry-After", retryAfterSeconds(retryAfter))
+		utils.WriteJson(w, http.StatusLocked, "error", nil, "Account is temporarily locked because of too many failed logins")
+		return true
+	case auth.LockedClient:
+		w.Header().Set("Retry-After", retryAfterSeconds(retryAfter))
+		utils.WriteJson(w, http.StatusTooManyRequests, "error", nil, "Too many failed logins, please try again later")
+		return true
+	}
+
+	return false
+}
+
+// recordLoginFailure is best effort, the login fails either way.
+func (h *UserHandler) recordLoginFailure(r *http.Request, username, ip string) {
+	if err := h.throttle.RecordFailure(r.Context(), username, ip); err != nil {
+		log.Println("error recording failed login: ", err)
+	}
+}
+
+// recordLoginSuccess clears the failed logins of the username after a
+// complete login, both factors included. It is best effort as well.
+func (h *UserHandler) recordLoginSuccess(r *http.Request, username string) {
+	if err := h.throttle.RecordSuccess(r.Context(), username); err != nil {
+		log.Println("error resetting failed logins: ", err)
+	}
+}
+
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+type LockoutHandler struct {
+	throttle  *auth.LoginThrottle
+	userRepo  repository.UserRepository
+	auditRepo repository.AuditRepository
+	db        *sql.DB
+}
+
+func NewLockoutHandler(throttle *auth.LoginThrottle, userRepo repository.UserRepository, auditRepo repository.AuditRepository, db *sql.DB) *LockoutHandler {
+	return &LockoutHandler{throttle: throttle, userRepo: userRepo, auditRepo: auditRepo, db: db}
+}
+
+// UnlockAccount clears the failed logins and the lock of a user.
+func (h *LockoutHandler) UnlockAccount(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJson(w, http.StatusMethodNotAllowed, "error", nil, "Method Not Allowed")
+		return
+	}
+
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Unauthorized")
+		return
+	}
+	actorId := principal.UserId
+
+	var req models.UnlockAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Invalid request payload")
+		return
+	}
+
+	if req.UserId == 0 {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Missing user ID")
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("error starting transaction: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	user, err := h.userRepo.GetUserById(ctx, tx, strconv.Itoa(req.UserId))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.WriteJson(w, http.StatusNotFound, "error", nil, "User not found")
+			return
+		}
+		log.Println("error getting user by id: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	err = h.auditRepo.Record(ctx, tx, &models.AuditLog{
+		ActorUserId:  actorId,
+		Action:       "user.unlock",
+		TargetUserId: req.UserId,
+		Details:      "username=" + user.Username,
+	})
+	if err != nil {
+		log.Println("error writing audit log: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to commit transaction")
+		return
+	}
+
+	// unlocked only once the audit entry is stored, a failed commit leaves
+	// the account locked
+	if err := h.throttle.Unlock(ctx, user.Username); err != nil {
+		log.Printf("Error unlocking user with ID %d: %v", req.UserId, err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	utils.WriteJson(w, http.StatusOK, "success", nil, "Account unlocked successfully")
+}
@@ -0,0 +1,213 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"go-crud-database/auth"
+	"go-crud-database/mailer"
+	"go-crud-database/middleware"
+	"go-crud-database/models"
+	"go-crud-database/repository"
+	"go-crud-database/utils"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	magicLinkTTL = 15 * time.Minute
+
+	// at most magicLinkLimit links per email within magicLinkWindow
+	magicLinkLimit  = 3
+	magicLinkWindow = 15 * time.Minute
+
+	magicLinkMessage = "If the email belongs to an account, a sign-in link has been sent"
+)
+
+// MagicLinkHandler signs users in with a link sent to their email.
+type MagicLinkHandler struct {
+	repo     repository.MagicLinkRepository
+	users    *UserHandler
+	mailer   mailer.Mailer
+	loginURL string
+}
+
+// NewMagicLinkHandler creates the handler. loginURL is the page the link
+// opens, it has to post the token to /api/v1/login/magic/verify. Posting
+// instead of consuming the link on GET keeps mail scanners that follow links
+// from using it up.
+func NewMagicLinkHandler(repo repository.MagicLinkRepository, users *UserHandler, mailer mailer.Mailer, loginURL string) *MagicLinkHandler {
+	return &MagicLinkHandler{repo: repo, users: users, mailer: mailer, loginURL: loginURL}
+}
+
+// RequestMagicLink emails a sign-in link. The answer is the same for unknown
+// emails and for emails that hit the rate limit.
+func (h *MagicLinkHandler) RequestMagicLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJson(w, http.StatusMethodNotAllowed, "error", nil, "Method Not Allowed")
+		return
+	}
+
+	var req models.MagicLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Invalid request payload")
+		return
+	}
+
+	if msg, isValid := utils.ValidateMagicLinkRequest(req); !isValid {
+		utils.WriteJson(w, http.StatusConflict, "error", nil, msg)
+		return
+	}
+
+	// the link is created in the background like the password reset link.
+	// Locking the user, counting the recent links, signing and storing the
+	// token only happen for known emails, so doing them here would make
+	// those answers measurably slower.
+	go h.sendMagicLink(req.Email)
+
+	utils.WriteJson(w, http.StatusOK, "success", nil, magicLinkMessage)
+}
+
+// sendMagicLink emails a sign-in link when email belongs to a user and the
+// limit isn't reached. It runs after RequestMagicLink answered, so errors are
+// only logged.
+func (h *MagicLinkHandler) sendMagicLink(email string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := h.users.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("error starting transaction: ", err)
+		return
+	}
+	defer tx.Rollback()
+
+	// the lock makes a concurrent request for the same user wait until this
+	// one committed, so both can't count below the limit and send a link
+	user, err := h.users.repo.GetUserByEmailForUpdate(ctx, tx, email)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Println("error getting user by email: ", err)
+		}
+		return
+	}
+
+	sent, err := h.repo.CountRecent(ctx, tx, user.UserId, magicLinkWindow)
+	if err != nil {
+		log.Println("error counting magic links: ", err)
+		return
+	}
+	if sent >= magicLinkLimit {
+		log.Printf("magic link rate limit reached for user %d", user.UserId)
+		return
+	}
+
+	token, err := auth.SignMagicLinkToken(h.users.keys, user.UserId, magicLinkTTL)
+	if err != nil {
+		log.Println("error signing magic link: ", err)
+		return
+	}
+
+	err = h.repo.Create(ctx, tx, &models.MagicLinkToken{
+		UserId:    user.UserId,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: time.Now().Add(magicLinkTTL).UTC(),
+	})
+	if err != nil {
+		log.Println("error creating magic link: ", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Println("error committing magic link: ", err)
+		return
+	}
+
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Your sign-in link",
+		Body: "Hi " + user.Username + ",\n\n" +
+			"Use the link below to sign in. It expires in 15 minutes and works only once.\n\n" +
+			h.loginURL + "?token=" + url.QueryEscape(token) + "\n\n" +
+			"If you did not ask to sign in you can ignore this email.",
+	}
+	if err := h.mailer.Send(ctx, msg); err != nil {
+		log.Printf("error sending magic link to user %d: %v", user.UserId, err)
+	}
+}
+
+// LoginWithMagicLink consumes the link and logs the user in like
+// Authentication does, two-factor authentication included.
+func (h *MagicLinkHandler) LoginWithMagicLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJson(w, http.StatusMethodNotAllowed, "error", nil, "Method Not Allowed")
+		return
+	}
+
+	var req models.MagicLinkLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Invalid request payload")
+		return
+	}
+
+	userId, err := auth.ParseMagicLinkToken(h.users.keys, req.Token)
+	if err != nil {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Invalid or expired sign-in link")
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := h.users.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("error starting transaction: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	linkUserId, err := h.repo.Consume(ctx, tx, utils.HashToken(req.Token), time.Now())
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Invalid or expired sign-in link")
+			return
+		}
+		log.Println("error consuming magic link: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+	if linkUserId != userId {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Invalid or expired sign-in link")
+		return
+	}
+
+	user, err := h.users.repo.GetUserById(ctx, tx, strconv.Itoa(userId))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Invalid or expired sign-in link")
+			return
+		}
+		log.Println("error getting user by id: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	// the link doesn't get around a lock after too many failed logins.
+	// Answering rolls back, so the link still works once the lock ends.
+	if h.users.writeLocked(w, r, user.Username, middleware.ClientIP(r)) {
+		return
+	}
+
+	// opening the link proves the user owns the email
+	if user.EmailVerifiedAt == nil {
+		if err := h.users.repo.MarkEmailVerified(ctx, tx, user.UserId, user.Email); err != nil && err != sql.ErrNoRows {
+			log.Printf("Error verifying email of user with ID %d: %v", user.UserId, err)
+			utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+			return
+		}
+	}
+
+	h.users.finishLogin(w, r, tx, user.UserId, user.Username, user.IsAdmin)
+}
@@ -0,0 +1,300 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"go-crud-database/auth"
+	"go-crud-database/models"
+	"go-crud-database/utils"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GetMe returns the profile of the user the access token belongs to.
+func (h *UserHandler) GetMe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteJson(w, http.StatusMethodNotAllowed, "error", nil, "Method Not Allowed")
+		return
+	}
+
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Unauthorized")
+		return
+	}
+	userId := principal.UserId
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	user, err := h.repo.GetUserById(ctx, nil, strconv.Itoa(userId))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.WriteJson(w, http.StatusNotFound, "error", nil, "User not found")
+			return
+		}
+		log.Println("error getting user by id: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	utils.WriteJson(w, http.StatusOK, "success", user, "Successfully retrieved user details")
+}
+
+// UpdateMe changes the username and/or email of the current user. Any other
+// field, isAdmin included, is rejected.
+func (h *UserHandler) UpdateMe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		utils.WriteJson(w, http.StatusMethodNotAllowed, "error", nil, "Method Not Allowed")
+		return
+	}
+
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Unauthorized")
+		return
+	}
+	userId := principal.UserId
+
+	var req models.UpdateProfileRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Invalid request payload, only username and email can be changed")
+		return
+	}
+
+	if msg, isValid := utils.ValidateUpdateProfileRequest(req); !isValid {
+		utils.WriteJson(w, http.StatusConflict, "error", nil, msg)
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("error starting transaction: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	detailUser, err := h.repo.GetUserById(ctx, tx, strconv.Itoa(userId))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.WriteJson(w, http.StatusNotFound, "error", nil, "User not found")
+			return
+		}
+		log.Println("error getting user by id: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	updatedUser := models.UpdateUserRequest{
+		UserId:   detailUser.UserId,
+		Username: detailUser.Username,
+		Email:    detailUser.Email,
+	}
+	if req.Username != nil {
+		updatedUser.Username = *req.Username
+	}
+	if req.Email != nil {
+		updatedUser.Email = *req.Email
+	}
+
+	if detailUser.Username == updatedUser.Username && detailUser.Email == updatedUser.Email {
+		utils.WriteJson(w, http.StatusOK, "info", nil, "No changes detected for the user")
+		return
+	}
+
+	if updatedUser.Username != detailUser.Username {
+		usernameExists, err := h.repo.CheckUsernameExists(ctx, updatedUser.Username)
+		if err != nil {
+			log.Println("error checking username exists: ", err)
+			utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+			return
+		}
+		if usernameExists {
+			utils.WriteJson(w, http.StatusConflict, "error", nil, "Username already exists")
+			return
+		}
+	}
+
+	if updatedUser.Email != detailUser.Email {
+		emailExists, err := h.repo.CheckEmailExists(ctx, updatedUser.Email)
+		if err != nil {
+			log.Println("error checking email exists: ", err)
+			utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+			return
+		}
+		if emailExists {
+			utils.WriteJson(w, http.StatusConflict, "error", nil, "Email already exists")
+			return
+		}
+	}
+
+	if err := h.repo.UpdateUser(ctx, tx, &updatedUser); err != nil {
+		log.Printf("Error updating user with ID %d: %v", userId, err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "update error")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to commit transaction")
+		return
+	}
+
+	// UpdateUser resets the verified state of a new email
+	if updatedUser.Email != detailUser.Email {
+		if err := h.verifier.SendVerification(userId, updatedUser.Username, updatedUser.Email); err != nil {
+			log.Println("error sending verification email: ", err)
+		}
+	}
+
+	utils.WriteJson(w, http.StatusOK, "success", nil, "User updated successfully")
+}
+
+// DeleteMe closes the account of the current user and revokes its tokens.
+func (h *UserHandler) DeleteMe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		utils.WriteJson(w, http.StatusMethodNotAllowed, "error", nil, "Method Not Allowed")
+		return
+	}
+
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Unauthorized")
+		return
+	}
+	userId := principal.UserId
+
+	ctx := r.Context()
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("error starting transaction: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	if err := h.repo.DeleteUser(ctx, tx, strconv.Itoa(userId)); err != nil {
+		log.Printf("Error deleting user with ID %d: %v", userId, err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error during deletion")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to commit transaction")
+		return
+	}
+
+	if err := h.revocations.RevokeUser(ctx, userId); err != nil {
+		log.Printf("Error revoking tokens of user with ID %d: %v", userId, err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error during deletion")
+		return
+	}
+
+	utils.WriteJson(w, http.StatusOK, "success", nil, "Account deleted successfully")
+}
+
+// ChangePassword sets a new password for the current user after checking the
+// current one. Every token of the user is revoked, so all sessions, the
+// current one included, have to log in again.
+func (h *UserHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJson(w, http.StatusMethodNotAllowed, "error", nil, "Method Not Allowed")
+		return
+	}
+
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Unauthorized")
+		return
+	}
+	userId := principal.UserId
+
+	var req models.ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Invalid request payload")
+		return
+	}
+
+	if msg, isValid := utils.ValidateChangePasswordRequest(req); !isValid {
+		utils.WriteJson(w, http.StatusConflict, "error", nil, msg)
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("error starting transaction: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	detailUser, err := h.repo.GetUserById(ctx, tx, strconv.Itoa(userId))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.WriteJson(w, http.StatusNotFound, "error", nil, "User not found")
+			return
+		}
+		log.Println("error getting user by id: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	// GetUserById doesn't return the password hash
+	storedUser, err := h.repo.GetUserByUsername(ctx, tx, detailUser.Username)
+	if err != nil {
+		log.Println("error getting user by username: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	if !utils.CheckPassword(storedUser.Password, req.CurrentPassword) {
+		utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Current password is incorrect")
+		return
+	}
+
+	if violations := utils.ValidatePassword(req.NewPassword, storedUser.Username, storedUser.Email); len(violations) > 0 {
+		utils.WriteJson(w, http.StatusConflict, "error", violations, strings.Join(violations, "; "))
+		return
+	}
+
+	passwordHash, err := utils.EncryptPassword(req.NewPassword)
+	if err != nil {
+		log.Println("error hashing password: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	if err := h.repo.UpdatePassword(ctx, tx, userId, passwordHash); err != nil {
+		log.Printf("Error updating password of user with ID %d: %v", userId, err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	if err := h.refreshTokenRepo.RevokeAllForUser(ctx, tx, userId); err != nil {
+		log.Println("error revoking refresh tokens: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to commit transaction")
+		return
+	}
+
+	// revoked after the commit, a failed password change keeps the sessions
+	if err := h.revocations.RevokeUser(ctx, userId); err != nil {
+		log.Println("error revoking access tokens: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	utils.WriteJson(w, http.StatusOK, "success", nil, "Password changed successfully, please log in again")
+}
@@ -0,0 +1,318 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"go-crud-database/auth"
+	"go-crud-database/middleware"
+	"go-crud-database/models"
+	"go-crud-database/utils"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// EnrollMFA creates a new TOTP secret for the current user. It only becomes
+// active after ConfirmMFA.
+func (h *UserHandler) EnrollMFA(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJson(w, http.StatusMethodNotAllowed, "error", nil, "Method Not Allowed")
+		return
+	}
+
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Unauthorized")
+		return
+	}
+	userId := principal.UserId
+
+	ctx := r.Context()
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("error starting transaction: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	user, err := h.repo.GetUserById(ctx, tx, strconv.Itoa(userId))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.WriteJson(w, http.StatusNotFound, "error", nil, "User not found")
+			return
+		}
+		log.Println("error getting user by id: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	secret, uri, err := h.mfa.Enroll(ctx, tx, userId, user.Username)
+	if err != nil {
+		if err == auth.ErrMFAAlreadyEnabled {
+			utils.WriteJson(w, http.StatusConflict, "error", nil, "Two-factor authentication is already enabled")
+			return
+		}
+		log.Printf("Error enrolling two-factor authentication of user with ID %d: %v", userId, err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to commit transaction")
+		return
+	}
+
+	response := models.MFAEnrollmentResponse{Secret: secret, OtpauthURI: uri}
+	utils.WriteJson(w, http.StatusOK, "success", response, "Scan the code and confirm it with a one-time code")
+}
+
+// ConfirmMFA enables two-factor authentication with the first code from the
+// authenticator app and returns the recovery codes. They are shown only once.
+func (h *UserHandler) ConfirmMFA(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJson(w, http.StatusMethodNotAllowed, "error", nil, "Method Not Allowed")
+		return
+	}
+
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Unauthorized")
+		return
+	}
+	userId := principal.UserId
+
+	var req models.MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Invalid request payload")
+		return
+	}
+
+	if msg, isValid := utils.ValidateMFACodeRequest(req); !isValid {
+		utils.WriteJson(w, http.StatusConflict, "error", nil, msg)
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("error starting transaction: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	codes, err := h.mfa.Confirm(ctx, tx, userId, req.Code)
+	if err != nil {
+		switch err {
+		case auth.ErrMFANotEnrolled:
+			utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Two-factor authentication is not enrolled")
+		case auth.ErrMFAAlreadyEnabled:
+			utils.WriteJson(w, http.StatusConflict, "error", nil, "Two-factor authentication is already enabled")
+		case auth.ErrInvalidMFACode:
+			utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Invalid code")
+		default:
+			log.Printf("Error confirming two-factor authentication of user with ID %d: %v", userId, err)
+			utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		}
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to commit transaction")
+		return
+	}
+
+	utils.WriteJson(w, http.StatusOK, "success", models.RecoveryCodesResponse{RecoveryCodes: codes}, "Two-factor authentication enabled")
+}
+
+// DisableMFA turns two-factor authentication off. A current code or a
+// recovery code is required, so a stolen session alone can't do it.
+func (h *UserHandler) DisableMFA(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJson(w, http.StatusMethodNotAllowed, "error", nil, "Method Not Allowed")
+		return
+	}
+
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Unauthorized")
+		return
+	}
+	userId := principal.UserId
+
+	var req models.MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Invalid request payload")
+		return
+	}
+
+	if msg, isValid := utils.ValidateMFACodeRequest(req); !isValid {
+		utils.WriteJson(w, http.StatusConflict, "error", nil, msg)
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("error starting transaction: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	if err := h.mfa.Disable(ctx, tx, userId, req.Code); err != nil {
+		switch err {
+		case auth.ErrMFANotEnabled:
+			utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Two-factor authentication is not enabled")
+		case auth.ErrInvalidMFACode:
+			utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Invalid code")
+		default:
+			log.Printf("Error disabling two-factor authentication of user with ID %d: %v", userId, err)
+			utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		}
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to commit transaction")
+		return
+	}
+
+	utils.WriteJson(w, http.StatusOK, "success", nil, "Two-factor authentication disabled")
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes, e.g. when most of
+// them are used up. A current code of the authenticator app is required,
+// the old recovery codes stop working.
+func (h *UserHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJson(w, http.StatusMethodNotAllowed, "error", nil, "Method Not Allowed")
+		return
+	}
+
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Unauthorized")
+		return
+	}
+	userId := principal.UserId
+
+	var req models.MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Invalid request payload")
+		return
+	}
+
+	if msg, isValid := utils.ValidateMFACodeRequest(req); !isValid {
+		utils.WriteJson(w, http.StatusConflict, "error", nil, msg)
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("error starting transaction: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	codes, err := h.mfa.RegenerateRecoveryCodes(ctx, tx, userId, req.Code)
+	if err != nil {
+		switch err {
+		case auth.ErrMFANotEnabled:
+			utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Two-factor authentication is not enabled")
+		case auth.ErrInvalidMFACode:
+			utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Invalid code")
+		default:
+			log.Printf("Error regenerating recovery codes of user with ID %d: %v", userId, err)
+			utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		}
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to commit transaction")
+		return
+	}
+
+	utils.WriteJson(w, http.StatusOK, "success", models.RecoveryCodesResponse{RecoveryCodes: codes}, "New recovery codes created, the old ones no longer work")
+}
+
+// LoginMFA finishes a login that Authentication answered with a pending
+// token, and issues the same tokens a login without two-factor would.
+func (h *UserHandler) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJson(w, http.StatusMethodNotAllowed, "error", nil, "Method Not Allowed")
+		return
+	}
+
+	var req models.MFALoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Invalid request payload")
+		return
+	}
+
+	if msg, isValid := utils.ValidateMFALoginRequest(req); !isValid {
+		utils.WriteJson(w, http.StatusConflict, "error", nil, msg)
+		return
+	}
+
+	userId, err := h.mfa.ParsePendingToken(req.MFAToken)
+	if err != nil {
+		utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Invalid or expired mfa token")
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("error starting transaction: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	user, err := h.repo.GetUserById(ctx, tx, strconv.Itoa(userId))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Invalid or expired mfa token")
+			return
+		}
+		log.Println("error getting user by id: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	ip := middleware.ClientIP(r)
+	if h.writeLocked(w, r, user.Username, ip) {
+		return
+	}
+
+	if err := h.mfa.Verify(ctx, tx, userId, req.Code); err != nil {
+		switch err {
+		case auth.ErrInvalidMFACode, auth.ErrMFANotEnabled:
+			h.recordLoginFailure(r, user.Username, ip)
+			utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Invalid code")
+		default:
+			log.Printf("Error verifying two-factor code of user with ID %d: %v", userId, err)
+			utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		}
+		return
+	}
+
+	tokens, err := h.issueTokens(ctx, tx, userId, user.IsAdmin, "")
+	if err != nil {
+		writeIssueTokensError(w, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to commit transaction")
+		return
+	}
+	h.recordLoginSuccess(r, user.Username)
+
+	utils.WriteJson(w, http.StatusOK, "success", tokens, "Authentication successful")
+}
@@ -0,0 +1,351 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"go-crud-database/auth"
+	"go-crud-database/models"
+	"go-crud-database/repository"
+	"go-crud-database/utils"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	oidcStateCookie = "oidc_login"
+	oidcLoginTTL    = 10 * time.Minute
+
+	// noLocalPassword never matches a password, users created through the
+	// identity provider can't log in with one until they reset it
+	noLocalPassword = "!"
+)
+
+var (
+	errOIDCEmailMissing     = errors.New("identity provider returned no email")
+	errOIDCEmailNotVerified = errors.New("email is not verified by the identity provider")
+	errOIDCLinkNeedsLogin   = errors.New("admins and users with two-factor authentication must log in and link the account from their profile")
+	errOIDCLinkedElsewhere  = errors.New("external account is linked to another user")
+)
+
+var usernameDisallowed = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// OIDCHandler signs users in through an OpenID Connect provider. Accounts
+// are linked by issuer and subject, the first login links an existing user
+// with the same verified email or creates a new one. Admins and users with
+// two-factor authentication are only linked from a logged in session, see
+// LinkAccount.
+type OIDCHandler struct {
+	provider     *auth.OIDCProvider
+	identities   repository.IdentityRepository
+	users        *UserHandler
+	secureCookie bool
+}
+
+func NewOIDCHandler(provider *auth.OIDCProvider, identities repository.IdentityRepository, users *UserHandler, secureCookie bool) *OIDCHandler {
+	return &OIDCHandler{provider: provider, identities: identities, users: users, secureCookie: secureCookie}
+}
+
+// Login redirects to the provider. State, nonce and PKCE verifier travel in
+// a signed cookie, so nothing has to be stored on the server.
+func (h *OIDCHandler) Login(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteJson(w, http.StatusMethodNotAllowed, "error", nil, "Method Not Allowed")
+		return
+	}
+
+	state, challenge, err := auth.NewOIDCLoginState()
+	if err != nil {
+		log.Println("error creating oidc state: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	if err := h.setStateCookie(w, state); err != nil {
+		log.Println("error signing oidc state: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	http.Redirect(w, r, h.provider.AuthCodeURL(state.State, state.Nonce, challenge), http.StatusFound)
+}
+
+// LinkAccount starts linking an external account to the logged in user.
+// It answers with the provider URL instead of redirecting, the request
+// carries the access token and can't be a plain browser navigation. The
+// callback then links the account instead of logging in.
+func (h *OIDCHandler) LinkAccount(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJson(w, http.StatusMethodNotAllowed, "error", nil, "Method Not Allowed")
+		return
+	}
+
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Unauthorized")
+		return
+	}
+
+	state, challenge, err := auth.NewOIDCLoginState()
+	if err != nil {
+		log.Println("error creating oidc state: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+	state.LinkUserId = principal.UserId
+
+	if err := h.setStateCookie(w, state); err != nil {
+		log.Println("error signing oidc state: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	response := models.OIDCLinkResponse{AuthorizationURL: h.provider.AuthCodeURL(state.State, state.Nonce, challenge)}
+	utils.WriteJson(w, http.StatusOK, "success", response, "Open the authorization URL to link the account")
+}
+
+// setStateCookie keeps the signed state for the callback.
+func (h *OIDCHandler) setStateCookie(w http.ResponseWriter, state auth.OIDCLoginState) error {
+	cookieValue, err := auth.SignOIDCLoginState(h.users.keys, state, oidcLoginTTL)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    cookieValue,
+		Path:     "/api/v1/login/oidc",
+		MaxAge:   int(oidcLoginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   h.secureCookie,
+		// the callback is a top level redirect from the provider
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// Callback finishes the login and returns this service's own tokens.
+func (h *OIDCHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteJson(w, http.StatusMethodNotAllowed, "error", nil, "Method Not Allowed")
+		return
+	}
+
+	// the cookie is single use
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Value: "", Path: "/api/v1/login/oidc", MaxAge: -1, HttpOnly: true, Secure: h.secureCookie})
+
+	query := r.URL.Query()
+	if providerError := query.Get("error"); providerError != "" {
+		utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Login was cancelled or denied: "+providerError)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Login session expired, please try again")
+		return
+	}
+
+	state, err := auth.ParseOIDCLoginState(h.users.keys, cookie.Value)
+	if err != nil || subtle.ConstantTimeCompare([]byte(state.State), []byte(query.Get("state"))) != 1 {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Invalid login state, please try again")
+		return
+	}
+
+	code := query.Get("code")
+	if code == "" {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "missing code")
+		return
+	}
+
+	ctx := r.Context()
+	claims, err := h.provider.Exchange(ctx, code, state.CodeVerifier, state.Nonce)
+	if err != nil {
+		log.Println("error exchanging oidc code: ", err)
+		utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Login with the identity provider failed")
+		return
+	}
+
+	tx, err := h.users.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("error starting transaction: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	if state.LinkUserId != 0 {
+		if err := h.linkUser(ctx, tx, state.LinkUserId, claims); err != nil {
+			if err == errOIDCLinkedElsewhere {
+				utils.WriteJson(w, http.StatusConflict, "error", nil, "Cannot link the account: "+err.Error())
+				return
+			}
+			log.Println("error linking oidc account: ", err)
+			utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to commit transaction")
+			return
+		}
+
+		utils.WriteJson(w, http.StatusOK, "success", nil, "Account linked")
+		return
+	}
+
+	user, err := h.resolveUser(ctx, tx, claims)
+	if err != nil {
+		switch err {
+		case errOIDCEmailMissing, errOIDCEmailNotVerified, errOIDCLinkNeedsLogin:
+			utils.WriteJson(w, http.StatusForbidden, "error", nil, "Cannot link the account: "+err.Error())
+		default:
+			log.Println("error resolving oidc user: ", err)
+			utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		}
+		return
+	}
+
+	// the same as a password login from here, users with two-factor
+	// authentication get a pending token
+	h.users.finishLogin(w, r, tx, user.UserId, user.Username, user.IsAdmin)
+}
+
+// resolveUser finds the user of the external account, linking or creating
+// one on the first login.
+func (h *OIDCHandler) resolveUser(ctx context.Context, tx *sql.Tx, claims auth.OIDCClaims) (models.User, error) {
+	identity, err := h.identities.GetByIssuerAndSubject(ctx, tx, claims.Issuer, claims.Subject)
+	if err == nil {
+		user, err := h.users.repo.GetUserById(ctx, tx, strconv.Itoa(identity.UserId))
+		if err != nil {
+			return models.User{}, err
+		}
+		return models.User{UserId: user.UserId, Username: user.Username, IsAdmin: user.IsAdmin}, nil
+	}
+	if err != sql.ErrNoRows {
+		return models.User{}, err
+	}
+
+	if claims.Email == "" {
+		return models.User{}, errOIDCEmailMissing
+	}
+
+	// an unverified email could belong to anyone, so it is neither linked
+	// nor used for a new account
+	if !claims.EmailVerified {
+		return models.User{}, errOIDCEmailNotVerified
+	}
+
+	user, err := h.users.repo.GetUserByEmail(ctx, tx, claims.Email)
+	if err == nil {
+		// the email alone must not open an account that is protected by
+		// more than a password
+		mfaEnabled, err := h.users.mfa.Enabled(ctx, tx, user.UserId)
+		if err != nil {
+			return models.User{}, err
+		}
+		if user.IsAdmin || mfaEnabled {
+			return models.User{}, errOIDCLinkNeedsLogin
+		}
+	} else if err == sql.ErrNoRows {
+		user, err = h.provisionUser(ctx, tx, claims)
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if user.EmailVerifiedAt == nil {
+		if err := h.users.repo.MarkEmailVerified(ctx, tx, user.UserId, user.Email); err != nil {
+			return models.User{}, err
+		}
+	}
+
+	err = h.identities.Create(ctx, tx, &models.UserIdentity{
+		UserId:  user.UserId,
+		Issuer:  claims.Issuer,
+		Subject: claims.Subject,
+		Email:   claims.Email,
+	})
+	if err != nil {
+		return models.User{}, err
+	}
+
+	return user, nil
+}
+
+// linkUser links the external account to a logged in user. The email of
+// the provider doesn't matter here, the user proved both accounts.
+func (h *OIDCHandler) linkUser(ctx context.Context, tx *sql.Tx, userId int, claims auth.OIDCClaims) error {
+	identity, err := h.identities.GetByIssuerAndSubject(ctx, tx, claims.Issuer, claims.Subject)
+	if err == nil {
+		if identity.UserId != userId {
+			return errOIDCLinkedElsewhere
+		}
+		return nil
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	return h.identities.Create(ctx, tx, &models.UserIdentity{
+		UserId:  userId,
+		Issuer:  claims.Issuer,
+		Subject: claims.Subject,
+		Email:   claims.Email,
+	})
+}
+
+// provisionUser creates a member without a local password.
+func (h *OIDCHandler) provisionUser(ctx context.Context, tx *sql.Tx, claims auth.OIDCClaims) (models.User, error) {
+	username, err := h.availableUsername(ctx, claims)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	newUser := models.RegisterRequest{Username: username, Email: claims.Email, Password: noLocalPassword}
+	if err := h.users.repo.Register(ctx, tx, &newUser); err != nil {
+		return models.User{}, err
+	}
+
+	return h.users.repo.GetUserByUsername(ctx, tx, username)
+}
+
+// availableUsername starts from the preferred username or the local part of
+// the email and adds a random suffix when it is taken.
+func (h *OIDCHandler) availableUsername(ctx context.Context, claims auth.OIDCClaims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	base = usernameDisallowed.ReplaceAllString(base, "")
+	if base == "" {
+		base = "user"
+	}
+	if len(base) > 40 {
+		base = base[:40]
+	}
+
+	candidate := base
+	for i := 0; i < 5; i++ {
+		exists, err := h.users.repo.CheckUsernameExists(ctx, candidate)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return candidate, nil
+		}
+
+		suffix, err := utils.GenerateRandomToken(3)
+		if err != nil {
+			return "", err
+		}
+		candidate = base + "-" + strings.ToLower(usernameDisallowed.ReplaceAllString(suffix, ""))
+	}
+
+	return "", errors.New("no free username for " + base)
+}
@@ -0,0 +1,225 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"go-crud-database/auth"
+	"go-crud-database/mailer"
+	"go-crud-database/models"
+	"go-crud-database/repository"
+	"go-crud-database/utils"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reset links expire after 30 minutes
+const passwordResetTTL = 30 * time.Minute
+
+// forgotPasswordMessage is returned whether or not the email is registered.
+const forgotPasswordMessage = "If the email is registered, a password reset link has been sent"
+
+type PasswordResetHandler struct {
+	userRepo         repository.UserRepository
+	resetRepo        repository.PasswordResetRepository
+	refreshTokenRepo repository.RefreshTokenRepository
+	revocations      *auth.RevocationStore
+	mailer           mailer.Mailer
+	resetURL         string
+	db               *sql.DB
+}
+
+// NewPasswordResetHandler creates the handler. resetURL is the page of the
+// frontend that reads the token query parameter and calls ResetPassword.
+func NewPasswordResetHandler(userRepo repository.UserRepository, resetRepo repository.PasswordResetRepository, refreshTokenRepo repository.RefreshTokenRepository, revocations *auth.RevocationStore, mailer mailer.Mailer, resetURL string, db *sql.DB) *PasswordResetHandler {
+	return &PasswordResetHandler{userRepo: userRepo, resetRepo: resetRepo, refreshTokenRepo: refreshTokenRepo, revocations: revocations, mailer: mailer, resetURL: resetURL, db: db}
+}
+
+func (h *PasswordResetHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJson(w, http.StatusMethodNotAllowed, "error", nil, "Method Not Allowed")
+		return
+	}
+
+	var req models.ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Invalid request payload")
+		return
+	}
+
+	if msg, isValid := utils.ValidateForgotPasswordRequest(req); !isValid {
+		utils.WriteJson(w, http.StatusConflict, "error", nil, msg)
+		return
+	}
+
+	// the link is created in the background. Looking the email up, storing
+	// the token and talking to the mail server only happen for known emails,
+	// so doing them here would make those answers measurably slower.
+	go h.sendResetLink(req.Email)
+
+	utils.WriteJson(w, http.StatusOK, "success", nil, forgotPasswordMessage)
+}
+
+// sendResetLink emails a new reset link when email belongs to a user. It
+// runs after ForgotPassword answered, so errors are only logged.
+func (h *PasswordResetHandler) sendResetLink(email string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("error starting transaction: ", err)
+		return
+	}
+	defer tx.Rollback()
+
+	user, err := h.userRepo.GetUserByEmail(ctx, tx, email)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Println("error getting user by email: ", err)
+		}
+		return
+	}
+
+	token, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		log.Println("error generating reset token: ", err)
+		return
+	}
+
+	// only the newest link stays valid
+	if err := h.resetRepo.InvalidateForUser(ctx, tx, user.UserId); err != nil {
+		log.Println("error invalidating reset tokens: ", err)
+		return
+	}
+
+	err = h.resetRepo.Create(ctx, tx, &models.PasswordResetToken{
+		UserId:    user.UserId,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: time.Now().Add(passwordResetTTL).UTC(),
+	})
+	if err != nil {
+		log.Println("error creating reset token: ", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Println("error committing reset token: ", err)
+		return
+	}
+
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: "Hi " + user.Username + ",\n\n" +
+			"Use the link below to choose a new password. It expires in 30 minutes.\n\n" +
+			h.resetURL + "?token=" + url.QueryEscape(token) + "\n\n" +
+			"If you did not ask for a password reset you can ignore this email.",
+	}
+	if err := h.mailer.Send(ctx, msg); err != nil {
+		log.Printf("error sending password reset email to user %d: %v", user.UserId, err)
+	}
+}
+
+func (h *PasswordResetHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJson(w, http.StatusMethodNotAllowed, "error", nil, "Method Not Allowed")
+		return
+	}
+
+	var req models.ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Invalid request payload")
+		return
+	}
+
+	if msg, isValid := utils.ValidateResetPasswordRequest(req); !isValid {
+		utils.WriteJson(w, http.StatusConflict, "error", nil, msg)
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("error starting transaction: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	resetToken, err := h.resetRepo.GetByHashForUpdate(ctx, tx, utils.HashToken(req.Token))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Invalid or expired reset token")
+			return
+		}
+		log.Println("error getting reset token: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	if resetToken.UsedAt.Valid || time.Now().After(resetToken.ExpiresAt) {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Invalid or expired reset token")
+		return
+	}
+
+	user, err := h.userRepo.GetUserById(ctx, tx, strconv.Itoa(resetToken.UserId))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Invalid or expired reset token")
+			return
+		}
+		log.Println("error getting user by id: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	if violations := utils.ValidatePassword(req.NewPassword, user.Username, user.Email); len(violations) > 0 {
+		utils.WriteJson(w, http.StatusConflict, "error", violations, strings.Join(violations, "; "))
+		return
+	}
+
+	passwordHash, err := utils.EncryptPassword(req.NewPassword)
+	if err != nil {
+		log.Println("error hashing password: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	if err := h.userRepo.UpdatePassword(ctx, tx, resetToken.UserId, passwordHash); err != nil {
+		log.Printf("Error updating password of user with ID %d: %v", resetToken.UserId, err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	if err := h.resetRepo.MarkUsed(ctx, tx, resetToken.TokenId); err != nil {
+		log.Println("error marking reset token as used: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	// whoever knew the old password must not stay logged in
+	if err := h.refreshTokenRepo.RevokeAllForUser(ctx, tx, resetToken.UserId); err != nil {
+		log.Println("error revoking refresh tokens: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to commit transaction")
+		return
+	}
+
+	// only after the commit, a reset that is rolled back keeps the sessions
+	if err := h.revocations.RevokeUser(ctx, resetToken.UserId); err != nil {
+		log.Println("error revoking access tokens: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	utils.WriteJson(w, http.StatusOK, "success", nil, "Password has been reset, please log in")
+}
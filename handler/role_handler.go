@@ -0,0 +1,256 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"go-crud-database/auth"
+	"go-crud-database/models"
+	"go-crud-database/repository"
+	"go-crud-database/utils"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type RoleHandler struct {
+	repo        repository.RoleRepository
+	userRepo    repository.UserRepository
+	auditRepo   repository.AuditRepository
+	revocations *auth.RevocationStore
+	db          *sql.DB
+}
+
+func NewRoleHandler(repo repository.RoleRepository, userRepo repository.UserRepository, auditRepo repository.AuditRepository, revocations *auth.RevocationStore, db *sql.DB) *RoleHandler {
+	return &RoleHandler{repo: repo, userRepo: userRepo, auditRepo: auditRepo, revocations: revocations, db: db}
+}
+
+func (h *RoleHandler) GetAllRoles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteJson(w, http.StatusMethodNotAllowed, "error", nil, "Method Not Allowed")
+		return
+	}
+
+	roles, err := h.repo.GetAllRoles(r.Context())
+	if err != nil {
+		log.Println("error getting roles: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	utils.WriteJson(w, http.StatusOK, "success", roles, "Successfully retrieved roles")
+}
+
+func (h *RoleHandler) AssignRole(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJson(w, http.StatusMethodNotAllowed, "error", nil, "Method Not Allowed")
+		return
+	}
+
+	h.changeRole(w, r, h.repo.AssignRole, "role.assign", "Role assigned successfully")
+}
+
+func (h *RoleHandler) RemoveRole(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		utils.WriteJson(w, http.StatusMethodNotAllowed, "error", nil, "Method Not Allowed")
+		return
+	}
+
+	h.changeRole(w, r, h.repo.RemoveRole, "role.remove", "Role removed successfully")
+}
+
+// changeRole validates the request and applies change (assign or remove)
+// inside a transaction, together with an audit entry of action.
+func (h *RoleHandler) changeRole(w http.ResponseWriter, r *http.Request, change func(ctx context.Context, tx *sql.Tx, userId int, role string) error, action, successMessage string) {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Unauthorized")
+		return
+	}
+	actorId := principal.UserId
+
+	var req models.RoleAssignmentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Invalid request payload")
+		return
+	}
+
+	if req.UserId == 0 {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Missing user ID")
+		return
+	}
+
+	if strings.TrimSpace(req.Role) == "" {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Role cannot be empty")
+		return
+	}
+
+	// admin rights are only granted through SetAdmin, which is audited
+	if req.Role == auth.RoleAdmin {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Admin rights can only be changed with PUT /api/v1/users/admin")
+		return
+	}
+
+	ctx := r.Context()
+
+	roleExists, err := h.repo.CheckRoleExists(ctx, req.Role)
+	if err != nil {
+		log.Println("error checking role exists: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+	if !roleExists {
+		utils.WriteJson(w, http.StatusNotFound, "error", nil, "Role not found")
+		return
+	}
+
+	userExists, err := h.userRepo.CheckUserExists(ctx, strconv.Itoa(req.UserId))
+	if err != nil {
+		log.Println("error checking user exists: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+	if !userExists {
+		utils.WriteJson(w, http.StatusNotFound, "error", nil, "User not found")
+		return
+	}
+
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("error starting transaction: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	if err := change(ctx, tx, req.UserId, req.Role); err != nil {
+		log.Printf("Error changing role %s of user with ID %d: %v", req.Role, req.UserId, err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	// roles grant permissions just like admin rights, so they are audited too
+	err = h.auditRepo.Record(ctx, tx, &models.AuditLog{
+		ActorUserId:  actorId,
+		Action:       action,
+		TargetUserId: req.UserId,
+		Details:      "role=" + req.Role,
+	})
+	if err != nil {
+		log.Println("error writing audit log: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to commit transaction")
+		return
+	}
+
+	// permissions are stored in the access token, so the user's current
+	// tokens are revoked and the next refresh picks up the new permissions.
+	// Revoking before the commit would let a refresh in between get the old
+	// permissions again.
+	if err := h.revocations.RevokeUser(ctx, req.UserId); err != nil {
+		log.Printf("Error revoking tokens of user with ID %d: %v", req.UserId, err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	utils.WriteJson(w, http.StatusOK, "success", nil, successMessage)
+}
+
+// SetAdmin grants or removes admin rights. It is the only way to promote a
+// user, and every change is written to the audit log.
+func (h *RoleHandler) SetAdmin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		utils.WriteJson(w, http.StatusMethodNotAllowed, "error", nil, "Method Not Allowed")
+		return
+	}
+
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Unauthorized")
+		return
+	}
+	actorId := principal.UserId
+
+	var req models.SetAdminRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Invalid request payload")
+		return
+	}
+
+	if req.UserId == 0 {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Missing user ID")
+		return
+	}
+
+	if req.UserId == actorId && !req.IsAdmin {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "You cannot remove your own admin rights")
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("error starting transaction: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	user, err := h.userRepo.GetUserById(ctx, tx, strconv.Itoa(req.UserId))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.WriteJson(w, http.StatusNotFound, "error", nil, "User not found")
+			return
+		}
+		log.Println("error getting user by id: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	if user.IsAdmin == req.IsAdmin {
+		utils.WriteJson(w, http.StatusOK, "info", nil, "No changes detected for the user")
+		return
+	}
+
+	if err := h.userRepo.SetAdmin(ctx, tx, req.UserId, req.IsAdmin); err != nil {
+		log.Printf("Error changing admin rights of user with ID %d: %v", req.UserId, err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	action := "user.promote"
+	if !req.IsAdmin {
+		action = "user.demote"
+	}
+
+	err = h.auditRepo.Record(ctx, tx, &models.AuditLog{
+		ActorUserId:  actorId,
+		Action:       action,
+		TargetUserId: req.UserId,
+		Details:      "username=" + user.Username,
+	})
+	if err != nil {
+		log.Println("error writing audit log: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to commit transaction")
+		return
+	}
+
+	// the old access tokens still carry the old permissions
+	if err := h.revocations.RevokeUser(ctx, req.UserId); err != nil {
+		log.Printf("Error revoking tokens of user with ID %d: %v", req.UserId, err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	utils.WriteJson(w, http.StatusOK, "success", nil, "Admin rights updated successfully")
+}
@@ -0,0 +1,309 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"go-crud-database/auth"
+	"go-crud-database/models"
+	"go-crud-database/utils"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	// access token will expire after 5 minutes
+	accessTokenTTL = 5 * time.Minute
+	// refresh token will expire after 7 days
+	refreshTokenTTL = 7 * 24 * time.Hour
+)
+
+// errAccountDisabled is returned by issueTokens for users disabled by an
+// administrator.
+var errAccountDisabled = errors.New("account is disabled")
+
+func (h *UserHandler) generateAccessToken(userId int, isAdmin bool, roles, permissions []string) (string, error) {
+	return h.signAccessToken(userId, isAdmin, roles, permissions, accessTokenTTL, 0)
+}
+
+// signAccessToken signs an access token valid for ttl. A non zero actorId
+// adds an act claim, it marks the token as issued to that user while
+// impersonating userId.
+func (h *UserHandler) signAccessToken(userId int, isAdmin bool, roles, permissions []string, ttl time.Duration, actorId int) (string, error) {
+	now := time.Now()
+	expirationTime := now.Add(ttl)
+
+	// every token gets a unique id so it can be revoked on logout
+	tokenId, err := utils.GenerateRandomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	// iat keeps the microseconds, so a token issued right after a revocation
+	// in the same second isn't taken for an old one
+	claims := jwt.MapClaims{
+		"userId":      userId,
+		"isAdmin":     isAdmin,
+		"roles":       roles,
+		"permissions": permissions,
+		"jti":         tokenId,
+		"iat":         float64(now.UnixMicro()) / 1e6,
+		"exp":         expirationTime.Unix(),
+	}
+	if actorId != 0 {
+		claims["act"] = map[string]interface{}{"userId": actorId}
+	}
+
+	// sign the claims with the active key of the key manager
+	return h.keys.Sign(claims)
+}
+
+// issueTokens creates a new access token and a refresh token that belongs to
+// familyId. Pass an empty familyId to start a new family (a fresh login).
+func (h *UserHandler) issueTokens(ctx context.Context, tx *sql.Tx, userId int, isAdmin bool, familyId string) (models.TokenResponse, error) {
+	// every login and refresh ends here, so a disabled user gets no tokens
+	// whichever way they sign in
+	user, err := h.repo.GetUserById(ctx, tx, strconv.Itoa(userId))
+	if err != nil {
+		return models.TokenResponse{}, err
+	}
+	if user.DisabledAt != nil {
+		return models.TokenResponse{}, errAccountDisabled
+	}
+
+	// roles and permissions are copied into the token so RequirePermission
+	// doesn't need to query the database on every request
+	roles, err := h.roleRepo.GetRolesByUserId(ctx, tx, userId)
+	if err != nil {
+		return models.TokenResponse{}, err
+	}
+
+	permissions, err := h.roleRepo.GetPermissionsByUserId(ctx, tx, userId)
+	if err != nil {
+		return models.TokenResponse{}, err
+	}
+
+	accessToken, err := h.generateAccessToken(userId, isAdmin, roles, permissions)
+	if err != nil {
+		return models.TokenResponse{}, err
+	}
+
+	if familyId == "" {
+		familyId, err = utils.GenerateRandomToken(16)
+		if err != nil {
+			return models.TokenResponse{}, err
+		}
+	}
+
+	refreshToken, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return models.TokenResponse{}, err
+	}
+
+	// only the hash is stored, the plain token is returned once to the client
+	err = h.refreshTokenRepo.Create(ctx, tx, &models.RefreshToken{
+		UserId:    userId,
+		FamilyId:  familyId,
+		TokenHash: utils.HashToken(refreshToken),
+		ExpiresAt: time.Now().Add(refreshTokenTTL).UTC(),
+	})
+	if err != nil {
+		return models.TokenResponse{}, err
+	}
+
+	return models.TokenResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+	}, nil
+}
+
+// writeIssueTokensError answers a request whose issueTokens failed.
+func writeIssueTokensError(w http.ResponseWriter, err error) {
+	if err == errAccountDisabled {
+		utils.WriteJson(w, http.StatusForbidden, "error", nil, "Account is disabled")
+		return
+	}
+
+	log.Println("error issuing tokens: ", err)
+	utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+}
+
+func (h *UserHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJson(w, http.StatusMethodNotAllowed, "error", nil, "Method Not Allowed")
+		return
+	}
+
+	var req models.RefreshTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Invalid request payload")
+		return
+	}
+
+	if strings.TrimSpace(req.RefreshToken) == "" {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Refresh token cannot be empty")
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("error starting transaction: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	storedToken, err := h.refreshTokenRepo.GetByHashForUpdate(ctx, tx, utils.HashToken(req.RefreshToken))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Invalid refresh token")
+			return
+		}
+		log.Println("error getting refresh token: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	// a refresh token can only be used once, if it shows up again it has
+	// probably been stolen, so every token of the family is revoked
+	if storedToken.UsedAt.Valid || storedToken.RevokedAt.Valid {
+		if err := h.refreshTokenRepo.RevokeFamily(ctx, tx, storedToken.FamilyId); err != nil {
+			log.Println("error revoking refresh token family: ", err)
+			utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to commit transaction")
+			return
+		}
+
+		log.Printf("refresh token reuse detected for user %d, family %s revoked", storedToken.UserId, storedToken.FamilyId)
+		utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Invalid refresh token")
+		return
+	}
+
+	if time.Now().After(storedToken.ExpiresAt) {
+		utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Refresh token expired")
+		return
+	}
+
+	// read the user again so a changed role is reflected in the new access token
+	user, err := h.repo.GetUserById(ctx, tx, strconv.Itoa(storedToken.UserId))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Invalid refresh token")
+			return
+		}
+		log.Println("error getting user by id: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	if err := h.refreshTokenRepo.MarkUsed(ctx, tx, storedToken.TokenId); err != nil {
+		log.Println("error marking refresh token as used: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	tokens, err := h.issueTokens(ctx, tx, user.UserId, user.IsAdmin, storedToken.FamilyId)
+	if err != nil {
+		writeIssueTokensError(w, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to commit transaction")
+		return
+	}
+
+	utils.WriteJson(w, http.StatusOK, "success", tokens, "Token refreshed successfully")
+}
+
+func (h *UserHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJson(w, http.StatusMethodNotAllowed, "error", nil, "Method Not Allowed")
+		return
+	}
+
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Unauthorized")
+		return
+	}
+	userId := principal.UserId
+	tokenId, tokenExp := principal.TokenId, principal.TokenExpiresAt
+
+	// the body is optional, logging out without one only revokes the access token
+	var req models.LogoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Invalid request payload")
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("error starting transaction: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	if req.RefreshToken != "" {
+		storedToken, err := h.refreshTokenRepo.GetByHashForUpdate(ctx, tx, utils.HashToken(req.RefreshToken))
+		if err != nil && err != sql.ErrNoRows {
+			log.Println("error getting refresh token: ", err)
+			utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+			return
+		}
+
+		// a refresh token of another user is silently ignored
+		if err == nil && storedToken.UserId == userId {
+			if err := h.refreshTokenRepo.RevokeFamily(ctx, tx, storedToken.FamilyId); err != nil {
+				log.Println("error revoking refresh token family: ", err)
+				utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+				return
+			}
+		}
+	}
+
+	if req.AllSessions {
+		if err := h.refreshTokenRepo.RevokeAllForUser(ctx, tx, userId); err != nil {
+			log.Println("error revoking refresh tokens: ", err)
+			utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to commit transaction")
+		return
+	}
+
+	// the revocation store writes outside of tx, so access tokens are only
+	// revoked once the refresh tokens are
+	if req.AllSessions {
+		if err := h.revocations.RevokeUser(ctx, userId); err != nil {
+			log.Println("error revoking access tokens: ", err)
+			utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+			return
+		}
+	} else if err := h.revocations.RevokeToken(ctx, tokenId, userId, tokenExp); err != nil {
+		log.Println("error revoking access token: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
+	utils.WriteJson(w, http.StatusOK, "success", nil, "Logged out successfully")
+}
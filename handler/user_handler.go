@@ -4,29 +4,33 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"go-crud-database/auth"
+	"go-crud-database/middleware"
 	"go-crud-database/models"
 	"go-crud-database/repository"
 	"go-crud-database/utils"
 	"log"
 	"net/http"
-	"os"
 	"strconv"
 	"time"
-
-	"github.com/dgrijalva/jwt-go"
 )
 
 type UserHandler struct {
-	repo repository.UserRepository
-	db  *sql.DB
+	repo             repository.UserRepository
+	refreshTokenRepo repository.RefreshTokenRepository
+	roleRepo         repository.RoleRepository
+	revocations      *auth.RevocationStore
+	keys             *auth.KeyManager
+	verifier         *auth.EmailVerifier
+	mfa              *auth.MFAService
+	throttle         *auth.LoginThrottle
+	db               *sql.DB
 }
 
-func NewUserHandler(repo repository.UserRepository, db *sql.DB) *UserHandler {
-	return &UserHandler{repo: repo, db: db}
+func NewUserHandler(repo repository.UserRepository, refreshTokenRepo repository.RefreshTokenRepository, roleRepo repository.RoleRepository, revocations *auth.RevocationStore, keys *auth.KeyManager, verifier *auth.EmailVerifier, mfa *auth.MFAService, throttle *auth.LoginThrottle, db *sql.DB) *UserHandler {
+	return &UserHandler{repo: repo, refreshTokenRepo: refreshTokenRepo, roleRepo: roleRepo, revocations: revocations, keys: keys, verifier: verifier, mfa: mfa, throttle: throttle, db: db}
 }
 
-var jwtKey = []byte(os.Getenv("JWT_SECRET"))
-
 func (h *UserHandler) Authentication(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		utils.WriteJson(w, http.StatusMethodNotAllowed, "error", nil, "Method Not Allowed")
@@ -45,6 +49,11 @@ func (h *UserHandler) Authentication(w http.ResponseWriter, r *http.Request) {
 	}
 
 	ctx := r.Context()
+	ip := middleware.ClientIP(r)
+	if h.writeLocked(w, r, user.Username, ip) {
+		return
+	}
+
 	tx, err := h.db.BeginTx(ctx, nil)
 	if err != nil {
 		log.Println("error starting transaction: ", err)
@@ -57,6 +66,7 @@ func (h *UserHandler) Authentication(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		// if the user is not found, return an error message
 		if err == sql.ErrNoRows {
+			h.recordLoginFailure(r, user.Username, ip)
 			utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Invalid username or password")
 			return
 		}
@@ -67,33 +77,100 @@ func (h *UserHandler) Authentication(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if !utils.CheckPassword(storedUser.Password, user.Password) {
+		h.recordLoginFailure(r, user.Username, ip)
 		utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Invalid username or password")
 		return
 	}
 
-	// token will expire after 5 minutes
-	expirationTime := time.Now().Add(5 * time.Minute)
+	// hashes written with bcrypt or older argon2 parameters are replaced while
+	// the plain password is at hand
+	if utils.NeedsRehash(storedUser.Password) {
+		passwordHash, err := utils.EncryptPassword(user.Password)
+		if err != nil {
+			log.Println("error hashing password: ", err)
+			utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+			return
+		}
+
+		if err := h.repo.UpdatePassword(ctx, tx, storedUser.UserId, passwordHash); err != nil {
+			log.Printf("Error upgrading password hash of user with ID %d: %v", storedUser.UserId, err)
+			utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+			return
+		}
+	}
+
+	// the password was right, so a fresh link is sent in case the old one
+	// got lost, unless one was sent a moment ago
+	if h.verifier.Required() && storedUser.EmailVerifiedAt == nil {
+		sent, err := h.verifier.Remind(storedUser.UserId, storedUser.Username, storedUser.Email)
+		if err != nil {
+			log.Println("error sending verification email: ", err)
+		}
+		if !sent {
+			utils.WriteJson(w, http.StatusForbidden, "error", nil, "Email address is not verified, please use the verification link that was sent recently")
+			return
+		}
+		utils.WriteJson(w, http.StatusForbidden, "error", nil, "Email address is not verified, a new verification link has been sent")
+		return
+	}
+
+	h.finishLogin(w, r, tx, storedUser.UserId, storedUser.Username, storedUser.IsAdmin)
+}
 
-	// create a new token with the claims and the signing method
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"userId":  storedUser.UserId,
-		"isAdmin": storedUser.IsAdmin,
-		"exp":     expirationTime.Unix(),
-	})
+// finishLogin is the common end of every login that proved the first
+// factor. It commits tx and writes either the tokens or the two-factor
+// challenge. The failed logins of the username are only cleared once the
+// tokens are issued, so a right password doesn't reset the guesses at the
+// second factor.
+func (h *UserHandler) finishLogin(w http.ResponseWriter, r *http.Request, tx *sql.Tx, userId int, username string, isAdmin bool) {
+	ctx := r.Context()
 
-	// sign the token with the secret key and get the token string
-	tokenString, err := token.SignedString([]byte(jwtKey))
+	// with two-factor authentication the first factor only earns a pending
+	// token, which is exchanged for the real tokens at /api/v1/login/mfa
+	mfaEnabled, err := h.mfa.Enabled(ctx, tx, userId)
 	if err != nil {
+		log.Println("error checking two-factor authentication: ", err)
 		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
 		return
 	}
 
+	if mfaEnabled {
+		mfaToken, err := h.mfa.IssuePendingToken(userId)
+		if err != nil {
+			log.Println("error issuing mfa token: ", err)
+			utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+			return
+		}
+
+		challenge := models.MFAChallengeResponse{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+			ExpiresIn:   int(auth.MFAPendingTTL.Seconds()),
+		}
+
+		// keeps whatever the login changed, like an upgraded password hash
+		if err := tx.Commit(); err != nil {
+			utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Failed to commit transaction")
+			return
+		}
+
+		utils.WriteJson(w, http.StatusOK, "success", challenge, "Two-factor authentication required")
+		return
+	}
+
+	tokens, err := h.issueTokens(ctx, tx, userId, isAdmin, "")
+	if err != nil {
+		writeIssueTokensError(w, err)
+		return
+	}
+
 	if err := tx.Commit(); err != nil {
-        http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
-        return
-    }
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+	h.recordLoginSuccess(r, username)
 
-	utils.WriteJson(w, http.StatusOK, "success", tokenString, "Authentication successful")
+	utils.WriteJson(w, http.StatusOK, "success", tokens, "Authentication successful")
 }
 
 func (h *UserHandler) GetAllUser(w http.ResponseWriter, r *http.Request) {
@@ -102,13 +179,6 @@ func (h *UserHandler) GetAllUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// check role user
-	isAdmin, ok := r.Context().Value("isAdmin").(bool)
-	if !ok || !isAdmin {
-		utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Unauthorized only admin can access")
-		return
-	}
-
 	// create a context with a timeout
 	// this will cancel the request if it takes longer than 5 seconds
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
@@ -170,19 +240,25 @@ func (h *UserHandler) UpdateDataUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// check role user
-	isAdmin, ok := r.Context().Value("isAdmin").(bool)
-	if !ok || !isAdmin {
-		utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Unauthorized only admin can access")
-		return
-	}
-
 	var updatedUser models.UpdateUserRequest
 	if err := json.NewDecoder(r.Body).Decode(&updatedUser); err != nil {
 		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Invalid request payload")
 		return
 	}
 
+	userId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || userId <= 0 {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Invalid user ID")
+		return
+	}
+
+	// the id in the body is optional, the path decides which user is updated
+	if updatedUser.UserId != 0 && updatedUser.UserId != userId {
+		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "User ID in the body doesn't match the path")
+		return
+	}
+	updatedUser.UserId = userId
+
 	if msg, isValid := utils.ValidateUpdateUserRequest(updatedUser); !isValid {
 		utils.WriteJson(w, http.StatusConflict, "error", nil, msg)
 		return
@@ -197,11 +273,6 @@ func (h *UserHandler) UpdateDataUser(w http.ResponseWriter, r *http.Request) {
 	}
 	defer tx.Rollback()
 
-	if updatedUser.UserId == 0 {
-		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "Missing user ID")
-		return
-	}
-
 	// Check if the user exists
 	detailUser, err := h.repo.GetUserById(ctx, tx, strconv.Itoa(updatedUser.UserId))
 	if err != nil {
@@ -213,8 +284,9 @@ func (h *UserHandler) UpdateDataUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if the data has changed
-	if detailUser.Username == updatedUser.Username && detailUser.Email == updatedUser.Email && detailUser.IsAdmin == updatedUser.IsAdmin {
+	// Check if the data has changed, isAdmin is ignored because admin rights
+	// are only changed through the audited PUT /api/v1/users/admin endpoint
+	if detailUser.Username == updatedUser.Username && detailUser.Email == updatedUser.Email {
 		utils.WriteJson(w, http.StatusOK, "info", nil, "No changes detected for the user")
 		return
 	}
@@ -232,6 +304,20 @@ func (h *UserHandler) UpdateDataUser(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Check if the email already exists
+	if updatedUser.Email != detailUser.Email {
+		emailExists, err := h.repo.CheckEmailExists(ctx, updatedUser.Email)
+		if err != nil {
+			log.Println("error checking email exists: ", err)
+			utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+			return
+		}
+		if emailExists {
+			utils.WriteJson(w, http.StatusConflict, "error", nil, "Email already exists")
+			return
+		}
+	}
+
 	// Proceed with the update
 	err = h.repo.UpdateUser(ctx, tx, &updatedUser)
 	if err != nil {
@@ -245,6 +331,13 @@ func (h *UserHandler) UpdateDataUser(w http.ResponseWriter, r *http.Request) {
         return
     }
 
+	// UpdateUser resets the verified state of a new email
+	if updatedUser.Email != detailUser.Email {
+		if err := h.verifier.SendVerification(updatedUser.UserId, updatedUser.Username, updatedUser.Email); err != nil {
+			log.Println("error sending verification email: ", err)
+		}
+	}
+
 	utils.WriteJson(w, http.StatusOK, "success", nil, "User updated successfully")
 
 }
@@ -267,6 +360,12 @@ func (h *UserHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// admin rights can't be requested on registration, see RoleHandler.SetAdmin
+	if newUser.IsAdmin {
+		utils.WriteJson(w, http.StatusForbidden, "error", nil, "isAdmin cannot be set during registration")
+		return
+	}
+
 	ctx := r.Context()
 	tx, err := h.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -314,18 +413,35 @@ func (h *UserHandler) Register(w http.ResponseWriter, r *http.Request) {
 
 	err = h.repo.Register(ctx, tx, &newUser)
 	if err != nil {
+		if err == repository.ErrElevatedInsert {
+			utils.WriteJson(w, http.StatusForbidden, "error", nil, "isAdmin cannot be set during registration")
+			return
+		}
 		log.Println("error creating new user: ", err)
 		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
 		return
 	}
 
+	// read the new user back to get the generated id for the verification link
+	createdUser, err := h.repo.GetUserByUsername(ctx, tx, newUser.Username)
+	if err != nil {
+		log.Println("error getting user by username: ", err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+		return
+	}
+
 	// tx.Commit() will be called only if no errors occurred
     if err := tx.Commit(); err != nil {
         http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
         return
     }
 
-	utils.WriteJson(w, http.StatusCreated, "success", nil, "New user created successfully")
+	// the account exists now, a failed email can be sent again with the resend endpoint
+	if err := h.verifier.SendVerification(createdUser.UserId, createdUser.Username, createdUser.Email); err != nil {
+		log.Println("error sending verification email: ", err)
+	}
+
+	utils.WriteJson(w, http.StatusCreated, "success", nil, "New user created successfully, please verify your email")
 }
 
 func (h *UserHandler) DeleteDataUser(w http.ResponseWriter, r *http.Request) {
@@ -334,14 +450,7 @@ func (h *UserHandler) DeleteDataUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// check role user
-	isAdmin, ok := r.Context().Value("isAdmin").(bool)
-	if !ok || !isAdmin {
-		utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Unauthorized only admin can access")
-		return
-	}
-
-	id := r.URL.Query().Get("id")
+	id := r.PathValue("id")
 	if id == "" {
 		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "missing user id")
 		return
@@ -380,6 +489,15 @@ func (h *UserHandler) DeleteDataUser(w http.ResponseWriter, r *http.Request) {
         return
     }
 
+	// lock the deleted user out right away instead of waiting for the token
+	// to expire, once the deletion can't be rolled back anymore
+	userId, _ := strconv.Atoi(id)
+	if err := h.revocations.RevokeUser(ctx, userId); err != nil {
+		log.Printf("Error revoking tokens of user with ID %s: %v", id, err)
+		utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error during deletion")
+		return
+	}
+
 	utils.WriteJson(w, http.StatusOK, "success", nil, "User deleted successfully")
 }
 
@@ -389,12 +507,22 @@ func (h *UserHandler) GetUserByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id := r.URL.Query().Get("id")
+	id := r.PathValue("id")
 	if id == "" {
 		utils.WriteJson(w, http.StatusBadRequest, "error", nil, "missing user id")
 		return
 	}
 
+	// plain users can only read their own details
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Unauthorized")
+		return
+	}
+	if id != strconv.Itoa(principal.UserId) && !principal.HasScope(auth.PermissionUsersRead) {
+		utils.WriteJson(w, http.StatusForbidden, "error", nil, "Forbidden: you can only view your own details")
+		return
+	}
 
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
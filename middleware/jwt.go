@@ -1,18 +1,28 @@
 package middleware
 
 import (
-	"context"
+	"go-crud-database/auth"
 	"go-crud-database/utils"
+	"log"
+	"math"
 	"net/http"
-	"os"
 	"strings"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 )
 
-var jwtKey = []byte(os.Getenv("JWT_SECRET"))
+type TokenValidator struct {
+	keys        *auth.KeyManager
+	revocations *auth.RevocationStore
+	apiKeys     *auth.APIKeyAuthenticator
+}
+
+func NewTokenValidator(keys *auth.KeyManager, revocations *auth.RevocationStore, apiKeys *auth.APIKeyAuthenticator) *TokenValidator {
+	return &TokenValidator{keys: keys, revocations: revocations, apiKeys: apiKeys}
+}
 
-func ValidateToken(next http.HandlerFunc) http.HandlerFunc {
+func (v *TokenValidator) ValidateToken(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 		authHeader := r.Header.Get("Authorization")
@@ -30,15 +40,19 @@ func ValidateToken(next http.HandlerFunc) http.HandlerFunc {
 		tokenString := tokenParts[1]
 
 		claims := jwt.MapClaims{}
-		token, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtKey, nil
-		})
+		token, err := v.keys.Parse(tokenString, &claims)
 
 		if err != nil || !token.Valid {
 			http.Error(w, "Invalid Token", http.StatusUnauthorized)
 			return
 		}
 
+		// tokens issued for something else, like verifying an email, are not access tokens
+		if _, ok := claims["purpose"]; ok {
+			utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Invalid auth token")
+			return
+		}
+
 		userIdFloat, ok := claims["userId"].(float64)
 		if !ok {
 			utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Invalid auth token")
@@ -51,12 +65,125 @@ func ValidateToken(next http.HandlerFunc) http.HandlerFunc {
 			isAdmin = false
 		}
 
+		roles := stringSliceClaim(claims, "roles")
+		permissions := stringSliceClaim(claims, "permissions")
+
+		// tokens without an id can't be revoked, so they are not accepted
+		tokenId, ok := claims["jti"].(string)
+		if !ok || tokenId == "" {
+			utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Invalid auth token")
+			return
+		}
+
+		// iat may have a fraction, see signAccessToken
+		iat, _ := claims["iat"].(float64)
+		issuedAt := time.UnixMicro(int64(math.Round(iat * 1e6)))
+		expiresAt, _ := claims["exp"].(float64)
+		tokenExp := time.Unix(int64(expiresAt), 0)
+
+		revoked, err := v.revocations.IsRevoked(r.Context(), tokenId, userId, issuedAt, tokenExp)
+		if err != nil {
+			log.Println("error checking token revocation: ", err)
+			utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+			return
+		}
+		if revoked {
+			utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Token has been revoked")
+			return
+		}
+
+		// an impersonation token names the admin in the act claim. Revoking
+		// the admin's tokens ends the impersonation as well.
+		impersonatorId := 0
+		if act, ok := claims["act"].(map[string]interface{}); ok {
+			actorIdFloat, ok := act["userId"].(float64)
+			if !ok {
+				utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Invalid auth token")
+				return
+			}
+			impersonatorId = int(actorIdFloat)
+
+			revoked, err := v.revocations.IsRevoked(r.Context(), tokenId, impersonatorId, issuedAt, tokenExp)
+			if err != nil {
+				log.Println("error checking token revocation: ", err)
+				utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+				return
+			}
+			if revoked {
+				utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Token has been revoked")
+				return
+			}
+		}
+
 		// Save user data in request context
-		ctx := context.WithValue(r.Context(), "userId", userId)
-		ctx = context.WithValue(ctx, "isAdmin", isAdmin)
+		ctx := auth.WithPrincipal(r.Context(), &auth.Principal{
+			UserId:         userId,
+			IsAdmin:        isAdmin,
+			Roles:          roles,
+			Scopes:         permissions,
+			TokenId:        tokenId,
+			TokenExpiresAt: tokenExp,
+			ImpersonatorId: impersonatorId,
+			Method:         auth.AuthMethodToken,
+		})
 		r = r.WithContext(ctx)
 
 		next.ServeHTTP(w, r)
 	})
 
 }
+
+// ValidateTokenOrAPIKey accepts an X-API-Key header as well as a bearer token
+// and puts the same user data in the context. It is meant for the routes
+// scripts call, account management keeps using ValidateToken.
+func (v *TokenValidator) ValidateTokenOrAPIKey(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("X-API-Key")
+		if key == "" {
+			v.ValidateToken(next)(w, r)
+			return
+		}
+
+		identity, err := v.apiKeys.Authenticate(r.Context(), key)
+		if err != nil {
+			if err == auth.ErrInvalidAPIKey {
+				utils.WriteJson(w, http.StatusUnauthorized, "error", nil, "Invalid API key")
+				return
+			}
+			if err == auth.ErrAPIKeyUserDisabled {
+				utils.WriteJson(w, http.StatusForbidden, "error", nil, "Account is disabled")
+				return
+			}
+			log.Println("error checking api key: ", err)
+			utils.WriteJson(w, http.StatusInternalServerError, "error", nil, "Internal Server Error")
+			return
+		}
+
+		ctx := auth.WithPrincipal(r.Context(), &auth.Principal{
+			UserId:   identity.UserId,
+			IsAdmin:  identity.IsAdmin,
+			Roles:    identity.Roles,
+			Scopes:   identity.Permissions,
+			APIKeyId: identity.KeyId,
+			Method:   auth.AuthMethodAPIKey,
+		})
+		r = r.WithContext(ctx)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// stringSliceClaim reads a claim that holds a list of strings. JSON arrays are
+// decoded as []interface{}, so every element is converted one by one.
+func stringSliceClaim(claims jwt.MapClaims, name string) []string {
+	values, _ := claims[name].([]interface{})
+
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if s, ok := value.(string); ok {
+			result = append(result, s)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"go-crud-database/auth"
+	"go-crud-database/utils"
+	"net/http"
+)
+
+// RequirePermission only lets the request through when the access token
+// grants the permission. It has to run after ValidateToken.
+func RequirePermission(permission string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !HasPermission(r, permission) {
+				utils.WriteJson(w, http.StatusForbidden, "error", nil, "Forbidden: missing permission "+permission)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// HasPermission reports whether the access token of the request grants the
+// permission, for handlers that need a finer check than RequirePermission.
+func HasPermission(r *http.Request, permission string) bool {
+	principal, ok := auth.FromContext(r.Context())
+	return ok && principal.HasScope(permission)
+}
+
+// DenyImpersonation blocks the request when an admin is impersonating the
+// user. It guards the routes that can't be undone or that change how the
+// account is accessed. It has to run after ValidateToken.
+func DenyImpersonation(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if IsImpersonated(r) {
+			utils.WriteJson(w, http.StatusForbidden, "error", nil, "Forbidden: not allowed while impersonating a user")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// IsImpersonated reports whether the access token of the request was issued
+// to an admin impersonating the user.
+func IsImpersonated(r *http.Request) bool {
+	principal, ok := auth.FromContext(r.Context())
+	return ok && principal.IsImpersonated()
+}
@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"go-crud-database/utils"
+	"net"
 	"net/http"
 	"sync"
 	"time"
@@ -11,6 +12,7 @@ type RateLimiter struct {
 	mu         sync.Mutex
 	requests   map[string]int
 	reset      map[string]time.Time
+	nextSweep  time.Time     // when expired clients are dropped next
 	rate       int           // max requests
 	burst      int           // max burst
 	resetAfter time.Duration // duration to reset the rate
@@ -28,34 +30,62 @@ func NewRateLimiter(rate int, burst int, resetAfter time.Duration) *RateLimiter
 
 func (rl *RateLimiter) Limit(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		rl.mu.Lock()
-		defer rl.mu.Unlock()
-
-		ip := r.RemoteAddr // Get the IP address of the client
-		now := time.Now()
-
-		// Check if this IP has a record
-		if _, exists := rl.requests[ip]; !exists {
-			rl.requests[ip] = 0
-			rl.reset[ip] = now.Add(rl.resetAfter)
-		}
-
-		// Check if the rate limit has expired
-		if now.After(rl.reset[ip]) {
-			rl.requests[ip] = 0
-			rl.reset[ip] = now.Add(rl.resetAfter)
-		}
-
-		// Check if the request limit has been reached
-		if rl.requests[ip] >= rl.rate+rl.burst {
+		if !rl.allow(ClientIP(r)) {
 			utils.WriteJson(w, http.StatusTooManyRequests, "error", nil, "Too Many Requests")
 			return
 		}
 
-		// Increment the request count
-		rl.requests[ip]++
-
-		// Call the next middleware/handler
+		// Call the next middleware/handler, without holding the lock
 		next.ServeHTTP(w, r)
 	})
 }
+
+// allow counts a request of ip and reports whether it is within the limit.
+func (rl *RateLimiter) allow(ip string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	rl.sweep(now)
+
+	// Check if this IP has a record or the rate limit has expired
+	if reset, exists := rl.reset[ip]; !exists || now.After(reset) {
+		rl.requests[ip] = 0
+		rl.reset[ip] = now.Add(rl.resetAfter)
+	}
+
+	// Check if the request limit has been reached
+	if rl.requests[ip] >= rl.rate+rl.burst {
+		return false
+	}
+
+	// Increment the request count
+	rl.requests[ip]++
+	return true
+}
+
+// sweep drops the clients whose window has ended, at most once per window,
+// so clients that went away don't stay in the maps.
+func (rl *RateLimiter) sweep(now time.Time) {
+	if now.Before(rl.nextSweep) {
+		return
+	}
+
+	for ip, reset := range rl.reset {
+		if now.After(reset) {
+			delete(rl.requests, ip)
+			delete(rl.reset, ip)
+		}
+	}
+	rl.nextSweep = now.Add(rl.resetAfter)
+}
+
+// ClientIP drops the port from RemoteAddr, so every connection of a client
+// shares one counter.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,15 @@
+-- Baseline of the users table. IF NOT EXISTS adopts databases created
+-- before the migrations existed.
+CREATE TABLE IF NOT EXISTS users (
+    user_id serial primary key,
+    username varchar(50) unique not null,
+    email varchar(100) unique not null,
+    password varchar(255) not null,
+    is_admin boolean default false,
+    email_verified_at timestamp,
+    created_at timestamp default current_timestamp,
+    updated_at timestamp default current_timestamp
+);
+
+-- tables created before email verification existed
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at timestamp;
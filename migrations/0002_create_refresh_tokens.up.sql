@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    token_id serial primary key,
+    user_id integer not null references users(user_id) on delete cascade,
+    family_id varchar(64) not null,
+    token_hash varchar(64) unique not null,
+    expires_at timestamp not null,
+    used_at timestamp,
+    revoked_at timestamp,
+    created_at timestamp default current_timestamp
+);
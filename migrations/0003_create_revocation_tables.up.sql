@@ -0,0 +1,13 @@
+-- Revoked access tokens, and users whose tokens issued before revoked_at
+-- are all revoked
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    token_id varchar(64) primary key,
+    user_id integer not null,
+    expires_at timestamp not null,
+    revoked_at timestamp default current_timestamp
+);
+
+CREATE TABLE IF NOT EXISTS revoked_users (
+    user_id integer primary key,
+    revoked_at timestamp not null
+);
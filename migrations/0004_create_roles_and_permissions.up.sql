@@ -0,0 +1,38 @@
+CREATE TABLE IF NOT EXISTS roles (
+    role_id serial primary key,
+    name varchar(50) unique not null,
+    description varchar(255) not null default ''
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+    permission_id serial primary key,
+    name varchar(100) unique not null
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_id integer not null references roles(role_id) on delete cascade,
+    permission_id integer not null references permissions(permission_id) on delete cascade,
+    primary key (role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id integer not null references users(user_id) on delete cascade,
+    role_id integer not null references roles(role_id) on delete cascade,
+    primary key (user_id, role_id)
+);
+
+-- Built-in roles and permissions, existing rows are kept
+INSERT INTO roles (name, description) VALUES
+  ('admin', 'Full access to user management'),
+  ('support', 'Can read users but not change them'),
+  ('member', 'Regular user')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO permissions (name) VALUES
+  ('users:read'), ('users:update'), ('users:delete'), ('users:promote'), ('users:impersonate'), ('roles:manage')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.role_id, p.permission_id FROM roles r, permissions p
+WHERE r.name = 'admin' OR (r.name = 'support' AND p.name = 'users:read')
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+    audit_id serial primary key,
+    actor_user_id integer not null,
+    action varchar(50) not null,
+    target_user_id integer not null,
+    details text not null default '',
+    created_at timestamp default current_timestamp
+);
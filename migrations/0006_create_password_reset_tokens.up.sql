@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+    token_id serial primary key,
+    user_id integer not null references users(user_id) on delete cascade,
+    token_hash varchar(64) unique not null,
+    expires_at timestamp not null,
+    used_at timestamp,
+    created_at timestamp default current_timestamp
+);
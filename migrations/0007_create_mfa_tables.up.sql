@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS user_mfa (
+    user_id integer primary key references users(user_id) on delete cascade,
+    secret_encrypted text not null,
+    enabled_at timestamp,
+    last_used_step bigint not null default 0,
+    created_at timestamp default current_timestamp
+);
+
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+    code_id serial primary key,
+    user_id integer not null references users(user_id) on delete cascade,
+    code_hash varchar(64) not null,
+    used_at timestamp
+);
@@ -0,0 +1,7 @@
+-- Failed login counters per username and per client IP
+CREATE TABLE IF NOT EXISTS login_attempts (
+    attempt_key varchar(300) primary key,
+    failures integer not null default 0,
+    last_failed_at timestamp not null,
+    locked_until timestamp
+);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+    key_id serial primary key,
+    user_id integer not null references users(user_id) on delete cascade,
+    name varchar(100) not null,
+    prefix varchar(16) unique not null,
+    secret_hash varchar(64) not null,
+    scopes text[] not null default '{}',
+    expires_at timestamp,
+    last_used_at timestamp,
+    revoked_at timestamp,
+    created_at timestamp default current_timestamp
+);
@@ -0,0 +1,10 @@
+-- Accounts at external identity providers linked to users
+CREATE TABLE IF NOT EXISTS user_identities (
+    identity_id serial primary key,
+    user_id integer not null references users(user_id) on delete cascade,
+    issuer varchar(255) not null,
+    subject varchar(255) not null,
+    email varchar(255) not null default '',
+    created_at timestamp default current_timestamp,
+    unique (issuer, subject)
+);
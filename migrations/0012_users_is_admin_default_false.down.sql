@@ -0,0 +1,2 @@
+ALTER TABLE users ALTER COLUMN is_admin DROP NOT NULL;
+-- the old default of true is not restored, it was never intended
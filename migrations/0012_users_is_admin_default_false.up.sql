@@ -0,0 +1,6 @@
+-- The Docker init script created users with is_admin defaulting to true, so
+-- a row inserted without the column became an admin. New users are never
+-- admins unless promoted.
+ALTER TABLE users ALTER COLUMN is_admin SET DEFAULT false;
+UPDATE users SET is_admin = false WHERE is_admin IS NULL;
+ALTER TABLE users ALTER COLUMN is_admin SET NOT NULL;
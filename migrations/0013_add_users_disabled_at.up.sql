@@ -0,0 +1,2 @@
+-- Disabled users keep their data but can't get new tokens
+ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled_at timestamp;
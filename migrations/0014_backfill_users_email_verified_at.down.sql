@@ -0,0 +1 @@
+-- the backfilled timestamps can't be told apart from real verifications
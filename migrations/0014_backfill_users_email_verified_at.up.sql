@@ -0,0 +1,8 @@
+-- Users who registered before email verification existed never got a link,
+-- with REQUIRE_EMAIL_VERIFICATION they couldn't log in anymore. They count
+-- as verified since they registered. The column came with migration 1, so
+-- only users older than it are backfilled; later users without a verified
+-- email simply haven't opened their link yet.
+UPDATE users SET email_verified_at = created_at
+WHERE email_verified_at IS NULL
+  AND created_at < (SELECT applied_at FROM schema_migrations WHERE version = 1);
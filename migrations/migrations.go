@@ -0,0 +1,378 @@
+// Package migrations holds the database schema as numbered SQL files and
+// applies them. Every version has an up and a down file:
+//
+//	0001_create_users.up.sql
+//	0001_create_users.down.sql
+//
+// Applied versions are recorded in schema_migrations with the checksum of
+// their up file, so a migration edited after it ran is noticed.
+package migrations
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// DefaultTable records the applied migrations of the schema.
+const DefaultTable = "schema_migrations"
+
+var (
+	ErrUnknownVersion   = errors.New("unknown migration version")
+	ErrChecksumMismatch = errors.New("migration was changed after it was applied")
+	ErrMissingMigration = errors.New("applied migration is missing from the migration files")
+)
+
+var fileNamePattern = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+	// Checksum is the hex encoded SHA-256 of Up
+	Checksum string
+}
+
+// Status is a migration and whether it is applied. A Status without Up is an
+// applied version the migration files don't have, e.g. from a newer release.
+type Status struct {
+	Migration
+	AppliedAt       *time.Time
+	ChecksumChanged bool
+}
+
+// All returns the embedded migrations of the schema, ordered by version.
+func All() ([]Migration, error) {
+	return Load(files)
+}
+
+// Load reads the migrations in the root of fsys, ordered by version. Every
+// version needs an up and a down file, a down file may hold only comments.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+
+		match := fileNamePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("%s: migration files are named like 0001_create_users.up.sql", entry.Name())
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		if version < 1 {
+			return nil, fmt.Errorf("%s: versions start at 1", entry.Name())
+		}
+
+		data, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("%s: version %d is also used by %s", entry.Name(), version, migration.Name)
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(data)
+			migration.Checksum = checksum(migration.Up)
+		} else {
+			migration.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Checksum == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", migration.Version, migration.Name)
+		}
+		if migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s has no down file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+func checksum(sql string) string {
+	sum := sha256.Sum256([]byte(sql))
+	return hex.EncodeToString(sum[:])
+}
+
+// Migrator applies migrations to a database. Every change holds a Postgres
+// advisory lock, so app instances starting at the same time migrate one
+// after the other instead of running the same migration twice.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+	table      string
+}
+
+// New returns a Migrator for the embedded migrations of the schema.
+func New(db *sql.DB) (*Migrator, error) {
+	migrations, err := All()
+	if err != nil {
+		return nil, err
+	}
+	return NewMigrator(db, migrations, DefaultTable), nil
+}
+
+// NewMigrator returns a Migrator that records migrations in table.
+func NewMigrator(db *sql.DB, migrations []Migration, table string) *Migrator {
+	return &Migrator{db: db, migrations: migrations, table: table}
+}
+
+// Up applies every pending migration and returns the applied ones.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	if len(m.migrations) == 0 {
+		return nil, nil
+	}
+	return m.Goto(ctx, m.migrations[len(m.migrations)-1].Version)
+}
+
+// Down rolls back the last steps applied migrations and returns them in the
+// order they were rolled back.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			if _, ok := applied[m.migrations[i].Version]; !ok {
+				continue
+			}
+			if err := m.run(ctx, conn, m.migrations[i], false); err != nil {
+				return err
+			}
+			done = append(done, m.migrations[i])
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Goto applies or rolls back migrations until version is the last applied
+// one. Version 0 rolls back everything.
+func (m *Migrator) Goto(ctx context.Context, version int) ([]Migration, error) {
+	if version != 0 && !m.known(version) {
+		return nil, fmt.Errorf("%w %d", ErrUnknownVersion, version)
+	}
+
+	var done []Migration
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		// roll back from the newest, then apply from the oldest
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; ok && migration.Version > version {
+				if err := m.run(ctx, conn, migration, false); err != nil {
+					return err
+				}
+				done = append(done, migration)
+			}
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; !ok && migration.Version <= version {
+				if err := m.run(ctx, conn, migration, true); err != nil {
+					return err
+				}
+				done = append(done, migration)
+			}
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Status lists every migration and whether it is applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		rows, err := conn.QueryContext(ctx, "SELECT version, name, checksum, applied_at FROM "+m.table+" ORDER BY version")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		recorded := map[int]Status{}
+		for rows.Next() {
+			var status Status
+			var appliedAt time.Time
+			if err := rows.Scan(&status.Version, &status.Name, &status.Checksum, &appliedAt); err != nil {
+				return err
+			}
+			status.AppliedAt = &appliedAt
+			recorded[status.Version] = status
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			status := Status{Migration: migration}
+			if record, ok := recorded[migration.Version]; ok {
+				status.AppliedAt = record.AppliedAt
+				status.ChecksumChanged = record.Checksum != migration.Checksum
+				delete(recorded, migration.Version)
+			}
+			statuses = append(statuses, status)
+		}
+
+		// applied versions without a file, e.g. from a newer release
+		for _, record := range recorded {
+			statuses = append(statuses, record)
+		}
+		sort.SliceStable(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+		return nil
+	})
+	return statuses, err
+}
+
+// locked runs fn on one connection holding the advisory lock of the table.
+// The lock belongs to the session, so it has to be taken and released on
+// the same connection.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock(hashtext($1))", m.table); err != nil {
+		return fmt.Errorf("error locking migrations: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock(hashtext($1))", m.table)
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+m.table+` (
+		version integer primary key,
+		name varchar(255) not null,
+		checksum varchar(64) not null,
+		applied_at timestamp not null default current_timestamp
+	)`)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+// applied returns the applied versions. It fails when an applied migration
+// was changed or is gone, the schema would not match the files then.
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int]struct{}, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, name, checksum FROM "+m.table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]struct{}{}
+	for rows.Next() {
+		var version int
+		var name, sum string
+		if err := rows.Scan(&version, &name, &sum); err != nil {
+			return nil, err
+		}
+
+		migration, ok := m.find(version)
+		if !ok {
+			return nil, fmt.Errorf("%w: %d_%s", ErrMissingMigration, version, name)
+		}
+		if migration.Checksum != sum {
+			return nil, fmt.Errorf("%w: %d_%s", ErrChecksumMismatch, version, name)
+		}
+		applied[version] = struct{}{}
+	}
+
+	return applied, rows.Err()
+}
+
+// run applies or rolls back one migration in a transaction together with its
+// row in the table, so a failing migration leaves nothing behind.
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, migration Migration, up bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := migration.Down
+	if up {
+		query = migration.Up
+	}
+	if hasStatements(query) {
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("error running migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx, "INSERT INTO "+m.table+" (version, name, checksum) VALUES ($1, $2, $3)", migration.Version, migration.Name, migration.Checksum)
+	} else {
+		_, err = tx.ExecContext(ctx, "DELETE FROM "+m.table+" WHERE version = $1", migration.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m *Migrator) find(version int) (Migration, bool) {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			return migration, true
+		}
+	}
+	return Migration{}, false
+}
+
+func (m *Migrator) known(version int) bool {
+	_, ok := m.find(version)
+	return ok
+}
+
+// hasStatements reports whether query is more than comments and blank lines.
+func hasStatements(query string) bool {
+	for _, line := range strings.Split(query, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "--") {
+			return true
+		}
+	}
+	return false
+}
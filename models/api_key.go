@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// APIKey is a long-lived credential for scripts. Only a hash of the secret
+// part is stored, the prefix identifies the key.
+type APIKey struct {
+	KeyId      int        `json:"keyId"`
+	UserId     int        `json:"userId"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	SecretHash string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// CreateAPIKeyResponse is the only time the full key is shown.
+type CreateAPIKeyResponse struct {
+	Key string `json:"key"`
+	APIKey
+}
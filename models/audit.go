@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+type AuditLog struct {
+	AuditId      int       `json:"auditId"`
+	ActorUserId  int       `json:"actorUserId"`
+	Action       string    `json:"action"`
+	TargetUserId int       `json:"targetUserId"`
+	Details      string    `json:"details"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// UserIdentity links a user to an account at an external identity provider.
+type UserIdentity struct {
+	IdentityId int
+	UserId     int
+	Issuer     string
+	Subject    string
+	Email      string
+	CreatedAt  time.Time
+}
+
+// OIDCLinkResponse is the provider URL that links an external account to the
+// logged in user.
+type OIDCLinkResponse struct {
+	AuthorizationURL string `json:"authorizationUrl"`
+}
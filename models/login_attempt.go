@@ -0,0 +1,18 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// LoginAttempt counts failed logins for one key, a username or a client IP.
+type LoginAttempt struct {
+	AttemptKey   string
+	Failures     int
+	LastFailedAt time.Time
+	LockedUntil  sql.NullTime
+}
+
+type UnlockAccountRequest struct {
+	UserId int `json:"userId"`
+}
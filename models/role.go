@@ -0,0 +1,18 @@
+package models
+
+type Role struct {
+	RoleId      int      `json:"roleId"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+type RoleAssignmentRequest struct {
+	UserId int    `json:"userId"`
+	Role   string `json:"role"`
+}
+
+type SetAdminRequest struct {
+	UserId  int  `json:"userId"`
+	IsAdmin bool `json:"isAdmin"`
+}
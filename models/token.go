@@ -0,0 +1,47 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+type RefreshToken struct {
+	TokenId   int          `json:"tokenId"`
+	UserId    int          `json:"userId"`
+	FamilyId  string       `json:"familyId"`
+	TokenHash string       `json:"-"`
+	ExpiresAt time.Time    `json:"expiresAt"`
+	UsedAt    sql.NullTime `json:"-"`
+	RevokedAt sql.NullTime `json:"-"`
+	CreatedAt time.Time    `json:"createdAt"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refreshToken"`
+	AllSessions  bool   `json:"allSessions"`
+}
+
+type TokenResponse struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	TokenType    string `json:"tokenType"`
+	ExpiresIn    int    `json:"expiresIn"`
+}
+
+type ImpersonateRequest struct {
+	Reason string `json:"reason"`
+}
+
+// ImpersonationResponse has no refresh token, the session ends when the
+// access token expires.
+type ImpersonationResponse struct {
+	AccessToken    string `json:"accessToken"`
+	TokenType      string `json:"tokenType"`
+	ExpiresIn      int    `json:"expiresIn"`
+	UserId         int    `json:"userId"`
+	ImpersonatorId int    `json:"impersonatorId"`
+}
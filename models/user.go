@@ -5,22 +5,26 @@ import (
 )
 
 type User struct {
-	UserId    int       `json:"userId"`
-	Username  string    `json:"username"`
-	Email     string    `json:"email"`
-	Password  string    `json:"password"`
-	IsAdmin   bool      `json:"isAdmin"`
-	CreatedAt time.Time `json:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt"`
+	UserId          int        `json:"userId"`
+	Username        string     `json:"username"`
+	Email           string     `json:"email"`
+	Password        string     `json:"password"`
+	IsAdmin         bool       `json:"isAdmin"`
+	EmailVerifiedAt *time.Time `json:"emailVerifiedAt"`
+	DisabledAt      *time.Time `json:"disabledAt"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	UpdatedAt       time.Time  `json:"updatedAt"`
 }
 
 type DetailUser struct {
-	UserId    int       `json:"userId"`
-	Username  string    `json:"username"`
-	Email     string    `json:"email"`
-	IsAdmin   bool      `json:"isAdmin"`
-	CreatedAt time.Time `json:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt"`
+	UserId          int        `json:"userId"`
+	Username        string     `json:"username"`
+	Email           string     `json:"email"`
+	IsAdmin         bool       `json:"isAdmin"`
+	EmailVerifiedAt *time.Time `json:"emailVerifiedAt"`
+	DisabledAt      *time.Time `json:"disabledAt"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	UpdatedAt       time.Time  `json:"updatedAt"`
 }
 
 type RegisterRequest struct {
@@ -39,8 +43,16 @@ type UpdateUserRequest struct {
 	UserId   int    `json:"userId"`
 	Username string `json:"username"`
 	Email    string `json:"email"`
-	Password string `json:"password"`
-	IsAdmin  bool   `json:"isAdmin"`
+}
+
+type UpdateProfileRequest struct {
+	Username *string `json:"username"`
+	Email    *string `json:"email"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
 }
 
 type PaginationMeta struct {
@@ -0,0 +1,15 @@
+package repository
+
+import (
+	"context"
+	"go-crud-database/models"
+	"time"
+)
+
+type APIKeyRepository interface {
+	Create(ctx context.Context, key *models.APIKey) error
+	GetByPrefix(ctx context.Context, prefix string) (models.APIKey, error)
+	ListByUserId(ctx context.Context, userId int) ([]models.APIKey, error)
+	Revoke(ctx context.Context, userId, keyId int) error
+	TouchLastUsed(ctx context.Context, keyId int, usedAt time.Time) error
+}
@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"go-crud-database/models"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// lastUsedResolution limits how often last_used_at is written for a busy key
+const lastUsedResolution = time.Minute
+
+const apiKeyColumns = "key_id, user_id, name, prefix, secret_hash, scopes, expires_at, last_used_at, revoked_at, created_at"
+
+type apiKeyRepositoryImpl struct {
+	DB *sql.DB
+}
+
+func NewAPIKeyRepository(db *sql.DB) APIKeyRepository {
+	return &apiKeyRepositoryImpl{DB: db}
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAPIKey(row rowScanner) (models.APIKey, error) {
+	var key models.APIKey
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+
+	err := row.Scan(&key.KeyId, &key.UserId, &key.Name, &key.Prefix, &key.SecretHash, pq.Array(&key.Scopes), &expiresAt, &lastUsedAt, &revokedAt, &key.CreatedAt)
+	if err != nil {
+		return key, err
+	}
+
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	if key.Scopes == nil {
+		key.Scopes = []string{}
+	}
+
+	return key, nil
+}
+
+func (r *apiKeyRepositoryImpl) Create(ctx context.Context, key *models.APIKey) error {
+	sqlQuery := `INSERT INTO api_keys(user_id, name, prefix, secret_hash, scopes, expires_at) VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING key_id, created_at`
+
+	var expiresAt interface{}
+	if key.ExpiresAt != nil {
+		expiresAt = key.ExpiresAt.UTC()
+	}
+
+	return r.DB.QueryRowContext(ctx, sqlQuery, key.UserId, key.Name, key.Prefix, key.SecretHash, pq.Array(key.Scopes), expiresAt).Scan(&key.KeyId, &key.CreatedAt)
+}
+
+// GetByPrefix returns sql.ErrNoRows when no key has the prefix.
+func (r *apiKeyRepositoryImpl) GetByPrefix(ctx context.Context, prefix string) (models.APIKey, error) {
+	sqlQuery := "SELECT " + apiKeyColumns + " FROM api_keys WHERE prefix = $1"
+
+	return scanAPIKey(r.DB.QueryRowContext(ctx, sqlQuery, prefix))
+}
+
+func (r *apiKeyRepositoryImpl) ListByUserId(ctx context.Context, userId int) ([]models.APIKey, error) {
+	sqlQuery := "SELECT " + apiKeyColumns + " FROM api_keys WHERE user_id = $1 ORDER BY created_at DESC"
+
+	rows, err := r.DB.QueryContext(ctx, sqlQuery, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// Revoke returns sql.ErrNoRows when the user has no active key with the id.
+func (r *apiKeyRepositoryImpl) Revoke(ctx context.Context, userId, keyId int) error {
+	sqlQuery := "UPDATE api_keys SET revoked_at = current_timestamp WHERE key_id = $1 AND user_id = $2 AND revoked_at IS NULL"
+
+	result, err := r.DB.ExecContext(ctx, sqlQuery, keyId, userId)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (r *apiKeyRepositoryImpl) TouchLastUsed(ctx context.Context, keyId int, usedAt time.Time) error {
+	sqlQuery := "UPDATE api_keys SET last_used_at = $1 WHERE key_id = $2 AND (last_used_at IS NULL OR last_used_at < $3)"
+
+	_, err := r.DB.ExecContext(ctx, sqlQuery, usedAt.UTC(), keyId, usedAt.Add(-lastUsedResolution).UTC())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
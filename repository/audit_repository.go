@@ -0,0 +1,11 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"go-crud-database/models"
+)
+
+type AuditRepository interface {
+	Record(ctx context.Context, tx *sql.Tx, entry *models.AuditLog) error
+}
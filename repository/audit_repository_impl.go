@@ -0,0 +1,23 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"go-crud-database/models"
+)
+
+type auditRepositoryImpl struct {
+	DB *sql.DB
+}
+
+func NewAuditRepository(db *sql.DB) AuditRepository {
+	return &auditRepositoryImpl{DB: db}
+}
+
+// Record writes the entry inside tx, so the audit log and the audited change
+// are committed or rolled back together.
+func (r *auditRepositoryImpl) Record(ctx context.Context, tx *sql.Tx, entry *models.AuditLog) error {
+	sqlQuery := "INSERT INTO audit_log(actor_user_id, action, target_user_id, details) VALUES ($1, $2, $3, $4) RETURNING audit_id, created_at"
+
+	return tx.QueryRowContext(ctx, sqlQuery, entry.ActorUserId, entry.Action, entry.TargetUserId, entry.Details).Scan(&entry.AuditId, &entry.CreatedAt)
+}
@@ -0,0 +1,12 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"go-crud-database/models"
+)
+
+type IdentityRepository interface {
+	GetByIssuerAndSubject(ctx context.Context, tx *sql.Tx, issuer, subject string) (models.UserIdentity, error)
+	Create(ctx context.Context, tx *sql.Tx, identity *models.UserIdentity) error
+}
@@ -0,0 +1,31 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"go-crud-database/models"
+)
+
+type identityRepositoryImpl struct {
+	DB *sql.DB
+}
+
+func NewIdentityRepository(db *sql.DB) IdentityRepository {
+	return &identityRepositoryImpl{DB: db}
+}
+
+// GetByIssuerAndSubject returns sql.ErrNoRows when the external account isn't linked yet.
+func (r *identityRepositoryImpl) GetByIssuerAndSubject(ctx context.Context, tx *sql.Tx, issuer, subject string) (models.UserIdentity, error) {
+	sqlQuery := "SELECT identity_id, user_id, issuer, subject, email, created_at FROM user_identities WHERE issuer = $1 AND subject = $2"
+
+	var identity models.UserIdentity
+	err := tx.QueryRowContext(ctx, sqlQuery, issuer, subject).Scan(&identity.IdentityId, &identity.UserId, &identity.Issuer, &identity.Subject, &identity.Email, &identity.CreatedAt)
+
+	return identity, err
+}
+
+func (r *identityRepositoryImpl) Create(ctx context.Context, tx *sql.Tx, identity *models.UserIdentity) error {
+	sqlQuery := "INSERT INTO user_identities(user_id, issuer, subject, email) VALUES ($1, $2, $3, $4) RETURNING identity_id, created_at"
+
+	return tx.QueryRowContext(ctx, sqlQuery, identity.UserId, identity.Issuer, identity.Subject, identity.Email).Scan(&identity.IdentityId, &identity.CreatedAt)
+}
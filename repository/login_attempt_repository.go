@@ -0,0 +1,14 @@
+package repository
+
+import (
+	"context"
+	"go-crud-database/models"
+	"time"
+)
+
+type LoginAttemptRepository interface {
+	GetLoginAttempt(ctx context.Context, key string) (models.LoginAttempt, error)
+	RecordFailure(ctx context.Context, key string, failedAt time.Time, window time.Duration) (int, error)
+	LockUntil(ctx context.Context, key string, lockedUntil time.Time) error
+	Reset(ctx context.Context, key string) error
+}
@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"go-crud-database/models"
+	"time"
+)
+
+type loginAttemptRepositoryImpl struct {
+	DB *sql.DB
+}
+
+func NewLoginAttemptRepository(db *sql.DB) LoginAttemptRepository {
+	return &loginAttemptRepositoryImpl{DB: db}
+}
+
+// GetLoginAttempt returns sql.ErrNoRows when the key has no recorded failures.
+func (r *loginAttemptRepositoryImpl) GetLoginAttempt(ctx context.Context, key string) (models.LoginAttempt, error) {
+	var attempt models.LoginAttempt
+	sqlQuery := "SELECT attempt_key, failures, last_failed_at, locked_until FROM login_attempts WHERE attempt_key = $1"
+	err := r.DB.QueryRowContext(ctx, sqlQuery, key).Scan(&attempt.AttemptKey, &attempt.Failures, &attempt.LastFailedAt, &attempt.LockedUntil)
+
+	return attempt, err
+}
+
+// RecordFailure increments the counter of key and returns the new value. A
+// counter whose last failure is older than window starts again at one.
+func (r *loginAttemptRepositoryImpl) RecordFailure(ctx context.Context, key string, failedAt time.Time, window time.Duration) (int, error) {
+	sqlQuery := `INSERT INTO login_attempts(attempt_key, failures, last_failed_at) VALUES ($1, 1, $2)
+		ON CONFLICT (attempt_key) DO UPDATE SET
+			failures = CASE WHEN login_attempts.last_failed_at < $3 THEN 1 ELSE login_attempts.failures + 1 END,
+			last_failed_at = EXCLUDED.last_failed_at
+		RETURNING failures`
+
+	var failures int
+	err := r.DB.QueryRowContext(ctx, sqlQuery, key, failedAt.UTC(), failedAt.Add(-window).UTC()).Scan(&failures)
+	if err != nil {
+		return 0, err
+	}
+
+	return failures, nil
+}
+
+func (r *loginAttemptRepositoryImpl) LockUntil(ctx context.Context, key string, lockedUntil time.Time) error {
+	sqlQuery := "UPDATE login_attempts SET locked_until = $1 WHERE attempt_key = $2"
+
+	_, err := r.DB.ExecContext(ctx, sqlQuery, lockedUntil.UTC(), key)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *loginAttemptRepositoryImpl) Reset(ctx context.Context, key string) error {
+	_, err := r.DB.ExecContext(ctx, "DELETE FROM login_attempts WHERE attempt_key = $1", key)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
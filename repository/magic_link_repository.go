@@ -0,0 +1,14 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"go-crud-database/models"
+	"time"
+)
+
+type MagicLinkRepository interface {
+	Create(ctx context.Context, tx *sql.Tx, token *models.MagicLinkToken) error
+	CountRecent(ctx context.Context, tx *sql.Tx, userId int, window time.Duration) (int, error)
+	Consume(ctx context.Context, tx *sql.Tx, tokenHash string, now time.Time) (int, error)
+}
@@ -0,0 +1,46 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"go-crud-database/models"
+	"time"
+)
+
+type magicLinkRepositoryImpl struct {
+	DB *sql.DB
+}
+
+func NewMagicLinkRepository(db *sql.DB) MagicLinkRepository {
+	return &magicLinkRepositoryImpl{DB: db}
+}
+
+func (r *magicLinkRepositoryImpl) Create(ctx context.Context, tx *sql.Tx, token *models.MagicLinkToken) error {
+	sqlQuery := "INSERT INTO magic_link_tokens(user_id, token_hash, expires_at) VALUES ($1, $2, $3) RETURNING token_id, created_at"
+
+	return tx.QueryRowContext(ctx, sqlQuery, token.UserId, token.TokenHash, token.ExpiresAt).Scan(&token.TokenId, &token.CreatedAt)
+}
+
+// CountRecent returns how many links were sent to the user within window.
+// created_at is set by the database, so the database clock is used.
+func (r *magicLinkRepositoryImpl) CountRecent(ctx context.Context, tx *sql.Tx, userId int, window time.Duration) (int, error) {
+	var count int
+	sqlQuery := "SELECT COUNT(*) FROM magic_link_tokens WHERE user_id = $1 AND created_at > current_timestamp - make_interval(secs => $2)"
+	err := tx.QueryRowContext(ctx, sqlQuery, userId, window.Seconds()).Scan(&count)
+
+	return count, err
+}
+
+// Consume marks an unused, unexpired token as used and returns its user. The
+// check and the update are one statement, so two requests with the same link
+// can't both succeed. It returns sql.ErrNoRows when there is nothing to consume.
+func (r *magicLinkRepositoryImpl) Consume(ctx context.Context, tx *sql.Tx, tokenHash string, now time.Time) (int, error) {
+	sqlQuery := `UPDATE magic_link_tokens SET used_at = $1
+		WHERE token_hash = $2 AND used_at IS NULL AND expires_at > $1
+		RETURNING user_id`
+
+	var userId int
+	err := tx.QueryRowContext(ctx, sqlQuery, now.UTC(), tokenHash).Scan(&userId)
+
+	return userId, err
+}
@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+)
+
+// memoryTxIdQuery asks a memory connection for its open transaction.
+const memoryTxIdQuery = "memory: current transaction"
+
+var errMemoryQuery = errors.New("the memory database only runs transactions of the memory repositories")
+
+// memoryStore keeps the changes of open transactions apart until they are
+// committed.
+type memoryStore interface {
+	begin() int64
+	commit(txId int64) error
+	rollback(txId int64)
+}
+
+// memoryConnector opens connections whose transactions stage their changes
+// in store. It runs no SQL, so a *sql.DB opened with it only begins,
+// commits and rolls back transactions for the memory repositories.
+type memoryConnector struct {
+	store memoryStore
+}
+
+func (c memoryConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return &memoryConn{store: c.store}, nil
+}
+
+func (c memoryConnector) Driver() driver.Driver {
+	return memoryDriver{}
+}
+
+type memoryDriver struct{}
+
+func (memoryDriver) Open(name string) (driver.Conn, error) {
+	return nil, errors.New("the memory database is opened by NewMemoryUserRepository")
+}
+
+type memoryConn struct {
+	store memoryStore
+	txId  int64
+}
+
+func (c *memoryConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errMemoryQuery
+}
+
+func (c *memoryConn) Close() error {
+	if c.txId != 0 {
+		c.store.rollback(c.txId)
+		c.txId = 0
+	}
+	return nil
+}
+
+func (c *memoryConn) Begin() (driver.Tx, error) {
+	if c.txId != 0 {
+		return nil, errors.New("transaction already open")
+	}
+	c.txId = c.store.begin()
+	return &memoryDriverTx{conn: c}, nil
+}
+
+// QueryContext only answers memoryTxIdQuery, with 0 outside a transaction.
+func (c *memoryConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if query != memoryTxIdQuery {
+		return nil, errMemoryQuery
+	}
+	return &memoryTxIdRows{txId: c.txId}, nil
+}
+
+type memoryDriverTx struct {
+	conn *memoryConn
+}
+
+func (t *memoryDriverTx) Commit() error {
+	txId := t.conn.txId
+	t.conn.txId = 0
+	return t.conn.store.commit(txId)
+}
+
+func (t *memoryDriverTx) Rollback() error {
+	t.conn.store.rollback(t.conn.txId)
+	t.conn.txId = 0
+	return nil
+}
+
+type memoryTxIdRows struct {
+	txId int64
+	done bool
+}
+
+func (r *memoryTxIdRows) Columns() []string { return []string{"tx_id"} }
+
+func (r *memoryTxIdRows) Close() error { return nil }
+
+func (r *memoryTxIdRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = r.txId
+	return nil
+}
+
+// memoryTxId returns the transaction tx belongs to, 0 without one. A tx of
+// another database is an error, its changes couldn't be rolled back.
+func memoryTxId(ctx context.Context, tx *sql.Tx) (int64, error) {
+	if tx == nil {
+		return 0, nil
+	}
+
+	var txId int64
+	if err := tx.QueryRowContext(ctx, memoryTxIdQuery).Scan(&txId); err != nil {
+		return 0, err
+	}
+	return txId, nil
+}
@@ -0,0 +1,17 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"go-crud-database/models"
+)
+
+type MFARepository interface {
+	GetByUserIdForUpdate(ctx context.Context, tx *sql.Tx, userId int) (models.UserMFA, error)
+	SavePending(ctx context.Context, tx *sql.Tx, userId int, secretEncrypted string) error
+	Enable(ctx context.Context, tx *sql.Tx, userId int, usedStep int64) error
+	UpdateLastUsedStep(ctx context.Context, tx *sql.Tx, userId int, usedStep int64) error
+	Delete(ctx context.Context, tx *sql.Tx, userId int) error
+	ReplaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userId int, codeHashes []string) error
+	UseRecoveryCode(ctx context.Context, tx *sql.Tx, userId int, codeHash string) (bool, error)
+}
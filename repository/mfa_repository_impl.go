@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"go-crud-database/models"
+)
+
+type mfaRepositoryImpl struct {
+	DB *sql.DB
+}
+
+func NewMFARepository(db *sql.DB) MFARepository {
+	return &mfaRepositoryImpl{DB: db}
+}
+
+// GetByUserIdForUpdate locks the row so the same code can't be accepted by two
+// concurrent logins.
+func (r *mfaRepositoryImpl) GetByUserIdForUpdate(ctx context.Context, tx *sql.Tx, userId int) (models.UserMFA, error) {
+	sqlQuery := "SELECT user_id, secret_encrypted, enabled_at, last_used_step, created_at FROM user_mfa WHERE user_id = $1 FOR UPDATE"
+
+	var mfa models.UserMFA
+	err := tx.QueryRowContext(ctx, sqlQuery, userId).Scan(&mfa.UserId, &mfa.SecretEncrypted, &mfa.EnabledAt, &mfa.LastUsedStep, &mfa.CreatedAt)
+
+	return mfa, err
+}
+
+// SavePending stores a new secret that is not enabled until it is confirmed.
+func (r *mfaRepositoryImpl) SavePending(ctx context.Context, tx *sql.Tx, userId int, secretEncrypted string) error {
+	sqlQuery := `INSERT INTO user_mfa(user_id, secret_encrypted) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret_encrypted = EXCLUDED.secret_encrypted, enabled_at = NULL, last_used_step = 0, created_at = current_timestamp`
+
+	_, err := tx.ExecContext(ctx, sqlQuery, userId, secretEncrypted)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *mfaRepositoryImpl) Enable(ctx context.Context, tx *sql.Tx, userId int, usedStep int64) error {
+	sqlQuery := "UPDATE user_mfa SET enabled_at = current_timestamp, last_used_step = $1 WHERE user_id = $2"
+
+	_, err := tx.ExecContext(ctx, sqlQuery, usedStep, userId)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *mfaRepositoryImpl) UpdateLastUsedStep(ctx context.Context, tx *sql.Tx, userId int, usedStep int64) error {
+	sqlQuery := "UPDATE user_mfa SET last_used_step = $1 WHERE user_id = $2"
+
+	_, err := tx.ExecContext(ctx, sqlQuery, usedStep, userId)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *mfaRepositoryImpl) Delete(ctx context.Context, tx *sql.Tx, userId int) error {
+	_, err := tx.ExecContext(ctx, "DELETE FROM mfa_recovery_codes WHERE user_id = $1", userId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM user_mfa WHERE user_id = $1", userId)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *mfaRepositoryImpl) ReplaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userId int, codeHashes []string) error {
+	_, err := tx.ExecContext(ctx, "DELETE FROM mfa_recovery_codes WHERE user_id = $1", userId)
+	if err != nil {
+		return err
+	}
+
+	sqlQuery := "INSERT INTO mfa_recovery_codes(user_id, code_hash) VALUES ($1, $2)"
+	for _, codeHash := range codeHashes {
+		if _, err := tx.ExecContext(ctx, sqlQuery, userId, codeHash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UseRecoveryCode consumes an unused recovery code and reports whether one was found.
+func (r *mfaRepositoryImpl) UseRecoveryCode(ctx context.Context, tx *sql.Tx, userId int, codeHash string) (bool, error) {
+	sqlQuery := "UPDATE mfa_recovery_codes SET used_at = current_timestamp WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL"
+
+	result, err := tx.ExecContext(ctx, sqlQuery, userId, codeHash)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
@@ -0,0 +1,14 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"go-crud-database/models"
+)
+
+type PasswordResetRepository interface {
+	Create(ctx context.Context, tx *sql.Tx, token *models.PasswordResetToken) error
+	GetByHashForUpdate(ctx context.Context, tx *sql.Tx, tokenHash string) (models.PasswordResetToken, error)
+	MarkUsed(ctx context.Context, tx *sql.Tx, tokenId int) error
+	InvalidateForUser(ctx context.Context, tx *sql.Tx, userId int) error
+}
@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"go-crud-database/models"
+)
+
+type passwordResetRepositoryImpl struct {
+	DB *sql.DB
+}
+
+func NewPasswordResetRepository(db *sql.DB) PasswordResetRepository {
+	return &passwordResetRepositoryImpl{DB: db}
+}
+
+func (r *passwordResetRepositoryImpl) Create(ctx context.Context, tx *sql.Tx, token *models.PasswordResetToken) error {
+	sqlQuery := "INSERT INTO password_reset_tokens(user_id, token_hash, expires_at) VALUES ($1, $2, $3) RETURNING token_id, created_at"
+
+	return tx.QueryRowContext(ctx, sqlQuery, token.UserId, token.TokenHash, token.ExpiresAt).Scan(&token.TokenId, &token.CreatedAt)
+}
+
+// GetByHashForUpdate locks the row so a reset token can't be consumed twice.
+func (r *passwordResetRepositoryImpl) GetByHashForUpdate(ctx context.Context, tx *sql.Tx, tokenHash string) (models.PasswordResetToken, error) {
+	sqlQuery := "SELECT token_id, user_id, token_hash, expires_at, used_at, created_at FROM password_reset_tokens WHERE token_hash = $1 FOR UPDATE"
+
+	var token models.PasswordResetToken
+	err := tx.QueryRowContext(ctx, sqlQuery, tokenHash).Scan(&token.TokenId, &token.UserId, &token.TokenHash, &token.ExpiresAt, &token.UsedAt, &token.CreatedAt)
+
+	return token, err
+}
+
+func (r *passwordResetRepositoryImpl) MarkUsed(ctx context.Context, tx *sql.Tx, tokenId int) error {
+	sqlQuery := "UPDATE password_reset_tokens SET used_at = current_timestamp WHERE token_id = $1"
+
+	_, err := tx.ExecContext(ctx, sqlQuery, tokenId)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// InvalidateForUser marks every unused token of the user as used, so only the
+// most recently requested link works.
+func (r *passwordResetRepositoryImpl) InvalidateForUser(ctx context.Context, tx *sql.Tx, userId int) error {
+	sqlQuery := "UPDATE password_reset_tokens SET used_at = current_timestamp WHERE user_id = $1 AND used_at IS NULL"
+
+	_, err := tx.ExecContext(ctx, sqlQuery, userId)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,15 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"go-crud-database/models"
+)
+
+type RefreshTokenRepository interface {
+	Create(ctx context.Context, tx *sql.Tx, token *models.RefreshToken) error
+	GetByHashForUpdate(ctx context.Context, tx *sql.Tx, tokenHash string) (models.RefreshToken, error)
+	MarkUsed(ctx context.Context, tx *sql.Tx, tokenId int) error
+	RevokeFamily(ctx context.Context, tx *sql.Tx, familyId string) error
+	RevokeAllForUser(ctx context.Context, tx *sql.Tx, userId int) error
+}
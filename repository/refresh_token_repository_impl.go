@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"go-crud-database/models"
+)
+
+type refreshTokenRepositoryImpl struct {
+	DB *sql.DB
+}
+
+func NewRefreshTokenRepository(db *sql.DB) RefreshTokenRepository {
+	return &refreshTokenRepositoryImpl{DB: db}
+}
+
+func (r *refreshTokenRepositoryImpl) Create(ctx context.Context, tx *sql.Tx, token *models.RefreshToken) error {
+	sqlQuery := "INSERT INTO refresh_tokens(user_id, family_id, token_hash, expires_at) VALUES ($1, $2, $3, $4) RETURNING token_id, created_at"
+
+	return tx.QueryRowContext(ctx, sqlQuery, token.UserId, token.FamilyId, token.TokenHash, token.ExpiresAt).Scan(&token.TokenId, &token.CreatedAt)
+}
+
+// GetByHashForUpdate locks the row so two concurrent refreshes of the same
+// token cannot both rotate it.
+func (r *refreshTokenRepositoryImpl) GetByHashForUpdate(ctx context.Context, tx *sql.Tx, tokenHash string) (models.RefreshToken, error) {
+	sqlQuery := "SELECT token_id, user_id, family_id, token_hash, expires_at, used_at, revoked_at, created_at FROM refresh_tokens WHERE token_hash = $1 FOR UPDATE"
+
+	var token models.RefreshToken
+	err := tx.QueryRowContext(ctx, sqlQuery, tokenHash).Scan(&token.TokenId, &token.UserId, &token.FamilyId, &token.TokenHash, &token.ExpiresAt, &token.UsedAt, &token.RevokedAt, &token.CreatedAt)
+
+	return token, err
+}
+
+func (r *refreshTokenRepositoryImpl) MarkUsed(ctx context.Context, tx *sql.Tx, tokenId int) error {
+	sqlQuery := "UPDATE refresh_tokens SET used_at = current_timestamp WHERE token_id = $1"
+
+	_, err := tx.ExecContext(ctx, sqlQuery, tokenId)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *refreshTokenRepositoryImpl) RevokeFamily(ctx context.Context, tx *sql.Tx, familyId string) error {
+	sqlQuery := "UPDATE refresh_tokens SET revoked_at = current_timestamp WHERE family_id = $1 AND revoked_at IS NULL"
+
+	_, err := tx.ExecContext(ctx, sqlQuery, familyId)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *refreshTokenRepositoryImpl) RevokeAllForUser(ctx context.Context, tx *sql.Tx, userId int) error {
+	sqlQuery := "UPDATE refresh_tokens SET revoked_at = current_timestamp WHERE user_id = $1 AND revoked_at IS NULL"
+
+	_, err := tx.ExecContext(ctx, sqlQuery, userId)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
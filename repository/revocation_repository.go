@@ -0,0 +1,13 @@
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+type RevocationRepository interface {
+	RevokeToken(ctx context.Context, tokenId string, userId int, expiresAt time.Time) error
+	IsTokenRevoked(ctx context.Context, tokenId string) (bool, error)
+	RevokeUserTokens(ctx context.Context, userId int, revokedAt time.Time) error
+	GetUserRevokedAt(ctx context.Context, userId int) (time.Time, error)
+}
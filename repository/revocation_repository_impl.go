@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type revocationRepositoryImpl struct {
+	DB *sql.DB
+}
+
+func NewRevocationRepository(db *sql.DB) RevocationRepository {
+	return &revocationRepositoryImpl{DB: db}
+}
+
+func (r *revocationRepositoryImpl) RevokeToken(ctx context.Context, tokenId string, userId int, expiresAt time.Time) error {
+	sqlQuery := "INSERT INTO revoked_tokens(token_id, user_id, expires_at) VALUES ($1, $2, $3) ON CONFLICT (token_id) DO NOTHING"
+
+	_, err := r.DB.ExecContext(ctx, sqlQuery, tokenId, userId, expiresAt.UTC())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *revocationRepositoryImpl) IsTokenRevoked(ctx context.Context, tokenId string) (bool, error) {
+	var revoked bool
+	sqlQuery := "SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE token_id = $1)"
+	err := r.DB.QueryRowContext(ctx, sqlQuery, tokenId).Scan(&revoked)
+	if err != nil {
+		return false, err
+	}
+
+	return revoked, nil
+}
+
+// RevokeUserTokens invalidates every token of the user issued at or before revokedAt.
+func (r *revocationRepositoryImpl) RevokeUserTokens(ctx context.Context, userId int, revokedAt time.Time) error {
+	sqlQuery := "INSERT INTO revoked_users(user_id, revoked_at) VALUES ($1, $2) ON CONFLICT (user_id) DO UPDATE SET revoked_at = EXCLUDED.revoked_at"
+
+	_, err := r.DB.ExecContext(ctx, sqlQuery, userId, revokedAt.UTC())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetUserRevokedAt returns sql.ErrNoRows when the user's tokens were never revoked.
+func (r *revocationRepositoryImpl) GetUserRevokedAt(ctx context.Context, userId int) (time.Time, error) {
+	var revokedAt time.Time
+	sqlQuery := "SELECT revoked_at FROM revoked_users WHERE user_id = $1"
+	err := r.DB.QueryRowContext(ctx, sqlQuery, userId).Scan(&revokedAt)
+
+	return revokedAt, err
+}
@@ -0,0 +1,16 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"go-crud-database/models"
+)
+
+type RoleRepository interface {
+	GetAllRoles(ctx context.Context) ([]models.Role, error)
+	GetRolesByUserId(ctx context.Context, tx *sql.Tx, userId int) ([]string, error)
+	GetPermissionsByUserId(ctx context.Context, tx *sql.Tx, userId int) ([]string, error)
+	AssignRole(ctx context.Context, tx *sql.Tx, userId int, role string) error
+	RemoveRole(ctx context.Context, tx *sql.Tx, userId int, role string) error
+	CheckRoleExists(ctx context.Context, role string) (bool, error)
+}
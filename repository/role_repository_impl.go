@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"go-crud-database/models"
+)
+
+// userRolesQuery selects the role ids of user $1. The legacy is_admin flag
+// still grants the admin role.
+const userRolesQuery = `SELECT ur.role_id FROM user_roles ur WHERE ur.user_id = $1
+	UNION
+	SELECT r.role_id FROM roles r JOIN users u ON u.is_admin WHERE r.name = 'admin' AND u.user_id = $1`
+
+type roleRepositoryImpl struct {
+	DB *sql.DB
+}
+
+func NewRoleRepository(db *sql.DB) RoleRepository {
+	return &roleRepositoryImpl{DB: db}
+}
+
+func (r *roleRepositoryImpl) GetAllRoles(ctx context.Context) ([]models.Role, error) {
+	sqlQuery := `SELECT r.role_id, r.name, r.description, p.name FROM roles r
+		LEFT JOIN role_permissions rp ON rp.role_id = r.role_id
+		LEFT JOIN permissions p ON p.permission_id = rp.permission_id
+		ORDER BY r.role_id, p.name`
+
+	rows, err := r.DB.QueryContext(ctx, sqlQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []models.Role
+	for rows.Next() {
+		var role models.Role
+		var permission sql.NullString
+		err = rows.Scan(&role.RoleId, &role.Name, &role.Description, &permission)
+		if err != nil {
+			return nil, err
+		}
+
+		// rows are ordered by role, so a new role starts when the id changes
+		if len(roles) == 0 || roles[len(roles)-1].RoleId != role.RoleId {
+			role.Permissions = []string{}
+			roles = append(roles, role)
+		}
+		if permission.Valid {
+			last := &roles[len(roles)-1]
+			last.Permissions = append(last.Permissions, permission.String)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+func (r *roleRepositoryImpl) GetRolesByUserId(ctx context.Context, tx *sql.Tx, userId int) ([]string, error) {
+	sqlQuery := "SELECT name FROM roles WHERE role_id IN (" + userRolesQuery + ") ORDER BY name"
+
+	return r.queryNames(ctx, tx, sqlQuery, userId)
+}
+
+func (r *roleRepositoryImpl) GetPermissionsByUserId(ctx context.Context, tx *sql.Tx, userId int) ([]string, error) {
+	sqlQuery := `SELECT DISTINCT p.name FROM permissions p
+		JOIN role_permissions rp ON rp.permission_id = p.permission_id
+		WHERE rp.role_id IN (` + userRolesQuery + `) ORDER BY p.name`
+
+	return r.queryNames(ctx, tx, sqlQuery, userId)
+}
+
+func (r *roleRepositoryImpl) queryNames(ctx context.Context, tx *sql.Tx, sqlQuery string, args ...interface{}) ([]string, error) {
+	var rows *sql.Rows
+	var err error
+	if tx != nil {
+		rows, err = tx.QueryContext(ctx, sqlQuery, args...)
+	} else {
+		rows, err = r.DB.QueryContext(ctx, sqlQuery, args...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
+
+func (r *roleRepositoryImpl) AssignRole(ctx context.Context, tx *sql.Tx, userId int, role string) error {
+	sqlQuery := "INSERT INTO user_roles(user_id, role_id) SELECT $1, role_id FROM roles WHERE name = $2 ON CONFLICT DO NOTHING"
+
+	_, err := tx.ExecContext(ctx, sqlQuery, userId, role)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *roleRepositoryImpl) RemoveRole(ctx context.Context, tx *sql.Tx, userId int, role string) error {
+	sqlQuery := "DELETE FROM user_roles WHERE user_id = $1 AND role_id = (SELECT role_id FROM roles WHERE name = $2)"
+
+	_, err := tx.ExecContext(ctx, sqlQuery, userId, role)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *roleRepositoryImpl) CheckRoleExists(ctx context.Context, role string) (bool, error) {
+	var roleExists bool
+	sqlQuery := "SELECT EXISTS(SELECT 1 FROM roles WHERE name = $1)"
+	err := r.DB.QueryRowContext(ctx, sqlQuery, role).Scan(&roleExists)
+	if err != nil {
+		return false, err
+	}
+
+	return roleExists, nil
+}
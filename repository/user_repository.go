@@ -3,16 +3,40 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"go-crud-database/models"
 )
 
+// ErrElevatedInsert is returned by Register when the new user would be an
+// admin and the caller did not allow it with AllowElevatedInsert.
+var ErrElevatedInsert = errors.New("refusing to insert a user with elevated privileges")
+
+type elevatedInsertKey struct{}
+
+// AllowElevatedInsert marks ctx so Register may create an admin user. Only
+// code that has already checked the caller is allowed to do so should use it.
+func AllowElevatedInsert(ctx context.Context) context.Context {
+	return context.WithValue(ctx, elevatedInsertKey{}, true)
+}
+
+func elevatedInsertAllowed(ctx context.Context) bool {
+	allowed, _ := ctx.Value(elevatedInsertKey{}).(bool)
+	return allowed
+}
+
 type UserRepository interface {
 	GetAllUser(ctx context.Context, limit, offset int) ([]models.User, error)
 	GetUserById(ctx context.Context, tx *sql.Tx, id string) (models.DetailUser, error)
 	GetUserByUsername(ctx context.Context, tx *sql.Tx, username string) (models.User, error)
+	GetUserByEmail(ctx context.Context, tx *sql.Tx, email string) (models.User, error)
+	GetUserByEmailForUpdate(ctx context.Context, tx *sql.Tx, email string) (models.User, error)
 	Register(ctx context.Context, tx *sql.Tx, user *models.RegisterRequest) error
 	Authentication(ctx context.Context, user *models.LoginRequest) (bool, error)
 	UpdateUser(ctx context.Context, tx *sql.Tx, user *models.UpdateUserRequest) error
+	SetAdmin(ctx context.Context, tx *sql.Tx, userId int, isAdmin bool) error
+	SetDisabled(ctx context.Context, tx *sql.Tx, userId int, disabled bool) error
+	UpdatePassword(ctx context.Context, tx *sql.Tx, userId int, passwordHash string) error
+	MarkEmailVerified(ctx context.Context, tx *sql.Tx, userId int, email string) error
 	DeleteUser(ctx context.Context, tx *sql.Tx, id string) error
 	CheckUsernameExists(ctx context.Context, username string) (bool, error)
 	CheckEmailExists(ctx context.Context, email string) (bool, error)
@@ -17,7 +17,7 @@ func NewUserRepository(db *sql.DB) UserRepository {
 
 func (r *userRepositoryImpl) GetAllUser(ctx context.Context, limit, offset int) ([]models.User, error) {
 
-	sqlQuery := "SELECT user_id, username, email, password, is_admin, created_at, updated_at FROM users ORDER BY created_at DESC LIMIT $1 OFFSET $2"
+	sqlQuery := "SELECT user_id, username, email, password, is_admin, email_verified_at, disabled_at, created_at, updated_at FROM users ORDER BY created_at DESC LIMIT $1 OFFSET $2"
 
 	rows, err := r.DB.QueryContext(ctx, sqlQuery, limit, offset)
 	if err != nil {
@@ -28,7 +28,7 @@ func (r *userRepositoryImpl) GetAllUser(ctx context.Context, limit, offset int)
 	var users []models.User
 	for rows.Next() {
 		var user models.User
-		err = rows.Scan(&user.UserId, &user.Username, &user.Email, &user.Password, &user.IsAdmin, &user.CreatedAt, &user.UpdatedAt)
+		err = rows.Scan(&user.UserId, &user.Username, &user.Email, &user.Password, &user.IsAdmin, &user.EmailVerifiedAt, &user.DisabledAt, &user.CreatedAt, &user.UpdatedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -43,7 +43,7 @@ func (r *userRepositoryImpl) GetAllUser(ctx context.Context, limit, offset int)
 }
 
 func (r *userRepositoryImpl) GetUserById(ctx context.Context, tx *sql.Tx, id string) (models.DetailUser, error) {
-	sqlQuery := "SELECT user_id, username, email, is_admin, created_at, updated_at from users where user_id = $1"
+	sqlQuery := "SELECT user_id, username, email, is_admin, email_verified_at, disabled_at, created_at, updated_at from users where user_id = $1"
 	
 	var user models.DetailUser
 	var row *sql.Row
@@ -54,7 +54,7 @@ func (r *userRepositoryImpl) GetUserById(ctx context.Context, tx *sql.Tx, id str
 		row = r.DB.QueryRowContext(ctx, sqlQuery, id)
 	}
 
-	err := row.Scan(&user.UserId, &user.Username, &user.Email, &user.IsAdmin, &user.CreatedAt, &user.UpdatedAt)
+	err := row.Scan(&user.UserId, &user.Username, &user.Email, &user.IsAdmin, &user.EmailVerifiedAt, &user.DisabledAt, &user.CreatedAt, &user.UpdatedAt)
 	
 	return user, err
 }
@@ -74,6 +74,10 @@ func (r *userRepositoryImpl) Authentication(ctx context.Context, user *models.Lo
 }
 
 func (r *userRepositoryImpl) Register(ctx context.Context, tx *sql.Tx, user *models.RegisterRequest) error {
+	if user.IsAdmin && !elevatedInsertAllowed(ctx) {
+		return ErrElevatedInsert
+	}
+
 	sqlQuery := "INSERT INTO users(username, email, password, is_admin) VALUES ($1, $2, $3, $4)"
 
 	_, err := tx.ExecContext(ctx, sqlQuery, user.Username, user.Email, user.Password, user.IsAdmin)
@@ -84,14 +88,72 @@ func (r *userRepositoryImpl) Register(ctx context.Context, tx *sql.Tx, user *mod
 	return nil
 }
 
+// UpdateUser changes the profile fields only, admin rights are changed with SetAdmin.
+// A new email address has to be verified again.
 func (r *userRepositoryImpl) UpdateUser(ctx context.Context, tx *sql.Tx, user *models.UpdateUserRequest) error {
-	sqlQuery := "UPDATE users SET username = $1, email = $2, is_admin = $3 where user_id = $4"
+	sqlQuery := "UPDATE users SET username = $1, email = $2, email_verified_at = CASE WHEN email = $2 THEN email_verified_at END where user_id = $3"
+
+	_, err := tx.ExecContext(ctx, sqlQuery, user.Username, user.Email, user.UserId)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *userRepositoryImpl) SetAdmin(ctx context.Context, tx *sql.Tx, userId int, isAdmin bool) error {
+	sqlQuery := "UPDATE users SET is_admin = $1 where user_id = $2"
+
+	_, err := tx.ExecContext(ctx, sqlQuery, isAdmin, userId)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SetDisabled disables or enables the account. Disabled users keep their data
+// but can't get new tokens.
+func (r *userRepositoryImpl) SetDisabled(ctx context.Context, tx *sql.Tx, userId int, disabled bool) error {
+	sqlQuery := "UPDATE users SET disabled_at = CASE WHEN $1::boolean THEN coalesce(disabled_at, current_timestamp) END where user_id = $2"
+
+	_, err := tx.ExecContext(ctx, sqlQuery, disabled, userId)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *userRepositoryImpl) UpdatePassword(ctx context.Context, tx *sql.Tx, userId int, passwordHash string) error {
+	sqlQuery := "UPDATE users SET password = $1 where user_id = $2"
+
+	_, err := tx.ExecContext(ctx, sqlQuery, passwordHash, userId)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// MarkEmailVerified only succeeds while the user still has the verified email,
+// it returns sql.ErrNoRows otherwise.
+func (r *userRepositoryImpl) MarkEmailVerified(ctx context.Context, tx *sql.Tx, userId int, email string) error {
+	sqlQuery := "UPDATE users SET email_verified_at = coalesce(email_verified_at, current_timestamp) where user_id = $1 AND email = $2"
 
-	_, err := tx.ExecContext(ctx, sqlQuery, user.Username, user.Email, user.IsAdmin, user.UserId)
+	result, err := tx.ExecContext(ctx, sqlQuery, userId, email)
 	if err != nil {
 		return err
 	}
 
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
 	return nil
 }
 
@@ -141,7 +203,7 @@ func (r *userRepositoryImpl) CheckUserExists(ctx context.Context, id string) (bo
 }
 
 func (r *userRepositoryImpl) GetUserByUsername(ctx context.Context, tx *sql.Tx, username string) (models.User, error) {
-	query := "SELECT user_id, username, email, password, is_admin, created_at, updated_at FROM users WHERE username = $1"
+	query := "SELECT user_id, username, email, password, is_admin, email_verified_at, disabled_at, created_at, updated_at FROM users WHERE username = $1"
 	
 	var user models.User
 	var row *sql.Row
@@ -151,7 +213,32 @@ func (r *userRepositoryImpl) GetUserByUsername(ctx context.Context, tx *sql.Tx,
 		row = r.DB.QueryRowContext(ctx, query, username)
 	}
 
-	err := row.Scan(&user.UserId, &user.Username, &user.Email, &user.Password, &user.IsAdmin, &user.CreatedAt, &user.UpdatedAt)
+	err := row.Scan(&user.UserId, &user.Username, &user.Email, &user.Password, &user.IsAdmin, &user.EmailVerifiedAt, &user.DisabledAt, &user.CreatedAt, &user.UpdatedAt)
+	return user, err
+}
+
+func (r *userRepositoryImpl) GetUserByEmail(ctx context.Context, tx *sql.Tx, email string) (models.User, error) {
+	query := "SELECT user_id, username, email, password, is_admin, email_verified_at, disabled_at, created_at, updated_at FROM users WHERE email = $1"
+
+	var user models.User
+	var row *sql.Row
+	if tx != nil {
+		row = tx.QueryRowContext(ctx, query, email)
+	} else {
+		row = r.DB.QueryRowContext(ctx, query, email)
+	}
+
+	err := row.Scan(&user.UserId, &user.Username, &user.Email, &user.Password, &user.IsAdmin, &user.EmailVerifiedAt, &user.DisabledAt, &user.CreatedAt, &user.UpdatedAt)
+	return user, err
+}
+
+// GetUserByEmailForUpdate locks the row until tx ends, so requests that
+// count something of the user before adding to it run one after another.
+func (r *userRepositoryImpl) GetUserByEmailForUpdate(ctx context.Context, tx *sql.Tx, email string) (models.User, error) {
+	query := "SELECT user_id, username, email, password, is_admin, email_verified_at, disabled_at, created_at, updated_at FROM users WHERE email = $1 FOR UPDATE"
+
+	var user models.User
+	err := tx.QueryRowContext(ctx, query, email).Scan(&user.UserId, &user.Username, &user.Email, &user.Password, &user.IsAdmin, &user.EmailVerifiedAt, &user.DisabledAt, &user.CreatedAt, &user.UpdatedAt)
 	return user, err
 }
 
@@ -0,0 +1,481 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"go-crud-database/models"
+	"go-crud-database/utils"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrDuplicateUser is returned by the in-memory repository where the users
+// table would violate its unique username or email constraint.
+var ErrDuplicateUser = errors.New("a user with this username or email exists already")
+
+// memoryUserRepository keeps users in a map and behaves like
+// userRepositoryImpl: missing users are sql.ErrNoRows, updates of missing
+// users are no-ops and lists are newest first.
+//
+// Changes made in a transaction of the *sql.DB from NewMemoryUserRepository
+// are only seen by that transaction until it commits, and are dropped by a
+// rollback. Unlike Postgres a concurrent transaction doesn't wait for a
+// conflicting insert; the unique constraints are checked again on commit,
+// which fails with ErrDuplicateUser instead. Writes with a nil tx apply at
+// once.
+type memoryUserRepository struct {
+	mu     sync.RWMutex
+	users  map[int]models.User
+	nextId int
+
+	// staged changes per open transaction, a nil user is a deleted one
+	txs      map[int64]map[int]*models.User
+	nextTxId int64
+}
+
+// NewMemoryUserRepository returns an empty UserRepository that lives in
+// memory, for tests and handlers that shouldn't need Postgres. Transactions
+// have to be started with the returned db, it runs no SQL otherwise.
+func NewMemoryUserRepository() (UserRepository, *sql.DB) {
+	repo := &memoryUserRepository{users: map[int]models.User{}, nextId: 1, txs: map[int64]map[int]*models.User{}, nextTxId: 1}
+	return repo, sql.OpenDB(memoryConnector{store: repo})
+}
+
+func (r *memoryUserRepository) begin() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	txId := r.nextTxId
+	r.nextTxId++
+	r.txs[txId] = map[int]*models.User{}
+	return txId
+}
+
+// commit applies the staged changes of the transaction, unless they would
+// break a unique constraint.
+func (r *memoryUserRepository) commit(txId int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	staged, ok := r.txs[txId]
+	if !ok {
+		return sql.ErrTxDone
+	}
+	delete(r.txs, txId)
+
+	result := make(map[int]models.User, len(r.users)+len(staged))
+	for userId, user := range r.users {
+		result[userId] = user
+	}
+	for userId, user := range staged {
+		if user == nil {
+			delete(result, userId)
+		} else {
+			result[userId] = *user
+		}
+	}
+
+	usernames := make(map[string]bool, len(result))
+	emails := make(map[string]bool, len(result))
+	for _, user := range result {
+		if usernames[user.Username] || emails[user.Email] {
+			return ErrDuplicateUser
+		}
+		usernames[user.Username], emails[user.Email] = true, true
+	}
+
+	r.users = result
+	return nil
+}
+
+func (r *memoryUserRepository) rollback(txId int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.txs, txId)
+}
+
+// currentTimestamp is rounded to microseconds like a Postgres timestamp.
+func currentTimestamp() *time.Time {
+	t := time.Now().UTC().Truncate(time.Microsecond)
+	return &t
+}
+
+// copyUser doesn't share the timestamps, changing a returned user doesn't
+// change the stored one.
+func copyUser(user models.User) models.User {
+	if user.EmailVerifiedAt != nil {
+		verifiedAt := *user.EmailVerifiedAt
+		user.EmailVerifiedAt = &verifiedAt
+	}
+	if user.DisabledAt != nil {
+		disabledAt := *user.DisabledAt
+		user.DisabledAt = &disabledAt
+	}
+	return user
+}
+
+// view returns the users as the transaction sees them: the committed ones
+// with its own changes on top. The caller holds mu.
+func (r *memoryUserRepository) view(txId int64) (map[int]models.User, error) {
+	staged, ok := r.txs[txId]
+	if txId == 0 || (ok && len(staged) == 0) {
+		return r.users, nil
+	}
+	if !ok {
+		return nil, sql.ErrTxDone
+	}
+
+	users := make(map[int]models.User, len(r.users)+len(staged))
+	for userId, user := range r.users {
+		users[userId] = user
+	}
+	for userId, user := range staged {
+		if user == nil {
+			delete(users, userId)
+		} else {
+			users[userId] = *user
+		}
+	}
+	return users, nil
+}
+
+// put writes the user in the transaction, or at once without one. The
+// caller holds mu.
+func (r *memoryUserRepository) put(txId int64, userId int, user *models.User) {
+	if txId != 0 {
+		r.txs[txId][userId] = user
+		return
+	}
+	if user == nil {
+		delete(r.users, userId)
+	} else {
+		r.users[userId] = *user
+	}
+}
+
+// findBy returns the user match accepts, sql.ErrNoRows if there is none.
+func (r *memoryUserRepository) findBy(ctx context.Context, tx *sql.Tx, match func(models.User) bool) (models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return models.User{}, err
+	}
+	txId, err := memoryTxId(ctx, tx)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	users, err := r.view(txId)
+	if err != nil {
+		return models.User{}, err
+	}
+	for _, user := range users {
+		if match(user) {
+			return copyUser(user), nil
+		}
+	}
+	return models.User{}, sql.ErrNoRows
+}
+
+// taken reports whether another user than userId has username or email.
+func taken(users map[int]models.User, userId int, username, email string) bool {
+	for _, user := range users {
+		if user.UserId != userId && (user.Username == username || user.Email == email) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseId fails for ids Postgres wouldn't accept for an integer column.
+func parseId(id string) (int, error) {
+	userId, err := strconv.Atoi(id)
+	if err != nil {
+		return 0, errors.New("invalid user id " + strconv.Quote(id))
+	}
+	return userId, nil
+}
+
+func (r *memoryUserRepository) GetAllUser(ctx context.Context, limit, offset int) ([]models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if limit < 0 || offset < 0 {
+		return nil, errors.New("limit and offset must not be negative")
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	all := make([]models.User, 0, len(r.users))
+	for _, user := range r.users {
+		all = append(all, user)
+	}
+	// newest first, the id decides between users created at the same time
+	sort.Slice(all, func(i, j int) bool {
+		if !all[i].CreatedAt.Equal(all[j].CreatedAt) {
+			return all[i].CreatedAt.After(all[j].CreatedAt)
+		}
+		return all[i].UserId > all[j].UserId
+	})
+
+	var users []models.User
+	for i := offset; i < len(all) && len(users) < limit; i++ {
+		users = append(users, copyUser(all[i]))
+	}
+	return users, nil
+}
+
+func (r *memoryUserRepository) GetUserById(ctx context.Context, tx *sql.Tx, id string) (models.DetailUser, error) {
+	userId, err := parseId(id)
+	if err != nil {
+		return models.DetailUser{}, err
+	}
+
+	user, err := r.findBy(ctx, tx, func(user models.User) bool { return user.UserId == userId })
+	if err != nil {
+		return models.DetailUser{}, err
+	}
+	return models.DetailUser{
+		UserId:          user.UserId,
+		Username:        user.Username,
+		Email:           user.Email,
+		IsAdmin:         user.IsAdmin,
+		EmailVerifiedAt: user.EmailVerifiedAt,
+		DisabledAt:      user.DisabledAt,
+		CreatedAt:       user.CreatedAt,
+		UpdatedAt:       user.UpdatedAt,
+	}, nil
+}
+
+func (r *memoryUserRepository) GetUserByUsername(ctx context.Context, tx *sql.Tx, username string) (models.User, error) {
+	return r.findBy(ctx, tx, func(user models.User) bool { return user.Username == username })
+}
+
+func (r *memoryUserRepository) GetUserByEmail(ctx context.Context, tx *sql.Tx, email string) (models.User, error) {
+	return r.findBy(ctx, tx, func(user models.User) bool { return user.Email == email })
+}
+
+// GetUserByEmailForUpdate takes no lock, the memory repository has no rows
+// to lock. Transactions of it don't wait for each other.
+func (r *memoryUserRepository) GetUserByEmailForUpdate(ctx context.Context, tx *sql.Tx, email string) (models.User, error) {
+	return r.GetUserByEmail(ctx, tx, email)
+}
+
+func (r *memoryUserRepository) Register(ctx context.Context, tx *sql.Tx, user *models.RegisterRequest) error {
+	if user.IsAdmin && !elevatedInsertAllowed(ctx) {
+		return ErrElevatedInsert
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	txId, err := memoryTxId(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	users, err := r.view(txId)
+	if err != nil {
+		return err
+	}
+	if taken(users, 0, user.Username, user.Email) {
+		return ErrDuplicateUser
+	}
+
+	// like a serial, a rolled back insert uses up its id
+	userId := r.nextId
+	r.nextId++
+
+	createdAt := currentTimestamp()
+	r.put(txId, userId, &models.User{
+		UserId:    userId,
+		Username:  user.Username,
+		Email:     user.Email,
+		Password:  user.Password,
+		IsAdmin:   user.IsAdmin,
+		CreatedAt: *createdAt,
+		UpdatedAt: *createdAt,
+	})
+
+	return nil
+}
+
+func (r *memoryUserRepository) Authentication(ctx context.Context, user *models.LoginRequest) (bool, error) {
+	stored, err := r.GetUserByUsername(ctx, nil, user.Username)
+	if err != nil {
+		return false, err
+	}
+
+	return utils.CheckPassword(stored.Password, user.Password), nil
+}
+
+// update changes the user userId as tx sees it with fn, a missing user is
+// not an error like an UPDATE that matches no row. fn gets the users tx
+// sees to check the unique constraints.
+func (r *memoryUserRepository) update(ctx context.Context, tx *sql.Tx, userId int, fn func(user *models.User, users map[int]models.User) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	txId, err := memoryTxId(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	users, err := r.view(txId)
+	if err != nil {
+		return err
+	}
+	user, ok := users[userId]
+	if !ok {
+		return nil
+	}
+	user = copyUser(user)
+	if err := fn(&user, users); err != nil {
+		return err
+	}
+	r.put(txId, userId, &user)
+
+	return nil
+}
+
+// UpdateUser changes the profile fields only, admin rights are changed with SetAdmin.
+// A new email address has to be verified again.
+func (r *memoryUserRepository) UpdateUser(ctx context.Context, tx *sql.Tx, user *models.UpdateUserRequest) error {
+	return r.update(ctx, tx, user.UserId, func(stored *models.User, users map[int]models.User) error {
+		if taken(users, stored.UserId, user.Username, user.Email) {
+			return ErrDuplicateUser
+		}
+		if stored.Email != user.Email {
+			stored.EmailVerifiedAt = nil
+		}
+		stored.Username = user.Username
+		stored.Email = user.Email
+		return nil
+	})
+}
+
+func (r *memoryUserRepository) SetAdmin(ctx context.Context, tx *sql.Tx, userId int, isAdmin bool) error {
+	return r.update(ctx, tx, userId, func(user *models.User, _ map[int]models.User) error {
+		user.IsAdmin = isAdmin
+		return nil
+	})
+}
+
+// SetDisabled disables or enables the account. Disabling again keeps the time
+// it was disabled first.
+func (r *memoryUserRepository) SetDisabled(ctx context.Context, tx *sql.Tx, userId int, disabled bool) error {
+	return r.update(ctx, tx, userId, func(user *models.User, _ map[int]models.User) error {
+		switch {
+		case !disabled:
+			user.DisabledAt = nil
+		case user.DisabledAt == nil:
+			user.DisabledAt = currentTimestamp()
+		}
+		return nil
+	})
+}
+
+func (r *memoryUserRepository) UpdatePassword(ctx context.Context, tx *sql.Tx, userId int, passwordHash string) error {
+	return r.update(ctx, tx, userId, func(user *models.User, _ map[int]models.User) error {
+		user.Password = passwordHash
+		return nil
+	})
+}
+
+// MarkEmailVerified only succeeds while the user still has the verified email,
+// it returns sql.ErrNoRows otherwise.
+func (r *memoryUserRepository) MarkEmailVerified(ctx context.Context, tx *sql.Tx, userId int, email string) error {
+	found := false
+	err := r.update(ctx, tx, userId, func(user *models.User, _ map[int]models.User) error {
+		if user.Email != email {
+			return nil
+		}
+		found = true
+		if user.EmailVerifiedAt == nil {
+			user.EmailVerifiedAt = currentTimestamp()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (r *memoryUserRepository) DeleteUser(ctx context.Context, tx *sql.Tx, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	userId, err := parseId(id)
+	if err != nil {
+		return err
+	}
+	txId, err := memoryTxId(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	users, err := r.view(txId)
+	if err != nil {
+		return err
+	}
+	if _, ok := users[userId]; ok {
+		r.put(txId, userId, nil)
+	}
+	return nil
+}
+
+func (r *memoryUserRepository) CheckUsernameExists(ctx context.Context, username string) (bool, error) {
+	_, err := r.GetUserByUsername(ctx, nil, username)
+	return lookupExists(err)
+}
+
+func (r *memoryUserRepository) CheckEmailExists(ctx context.Context, email string) (bool, error) {
+	_, err := r.GetUserByEmail(ctx, nil, email)
+	return lookupExists(err)
+}
+
+func (r *memoryUserRepository) CheckUserExists(ctx context.Context, id string) (bool, error) {
+	_, err := r.GetUserById(ctx, nil, id)
+	return lookupExists(err)
+}
+
+// lookupExists turns the error of a lookup into the answer of an EXISTS query.
+func lookupExists(err error) (bool, error) {
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *memoryUserRepository) CountUser(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return len(r.users), nil
+}
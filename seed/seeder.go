@@ -0,0 +1,124 @@
+package seed
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"go-crud-database/config"
+	"go-crud-database/models"
+	"go-crud-database/repository"
+	"go-crud-database/utils"
+)
+
+// ErrNotAllowed is returned when seeding is attempted outside of the dev and
+// test environments, or without an APP_ENV at all.
+var ErrNotAllowed = errors.New("seeding is only allowed when APP_ENV is dev or test")
+
+type Seeder struct {
+	db    *sql.DB
+	users repository.UserRepository
+	roles repository.RoleRepository
+	env   string
+}
+
+// NewSeeder returns a Seeder for the environment env, see config.Config.Env.
+func NewSeeder(db *sql.DB, users repository.UserRepository, roles repository.RoleRepository, env string) *Seeder {
+	return &Seeder{db: db, users: users, roles: roles, env: env}
+}
+
+// Seed creates the users in one transaction. Users whose username or email
+// is taken already are skipped, so seeding again is harmless. Passwords are
+// hashed with utils.EncryptPassword and the current Argon2 parameters, set
+// utils.FastArgon2Params first to seed many users quickly. The environment
+// must be set explicitly, an empty one is refused like prod.
+func (s *Seeder) Seed(ctx context.Context, users []User) (created, skipped int, err error) {
+	if s.env != config.EnvDev && s.env != config.EnvTest {
+		return 0, 0, ErrNotAllowed
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	for _, user := range users {
+		exists, err := s.exists(ctx, tx, user)
+		if err != nil {
+			return 0, 0, err
+		}
+		if exists {
+			skipped++
+			continue
+		}
+
+		if err := s.create(ctx, tx, user); err != nil {
+			return 0, 0, fmt.Errorf("error creating user %s: %w", user.Username, err)
+		}
+		created++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+
+	return created, skipped, nil
+}
+
+func (s *Seeder) exists(ctx context.Context, tx *sql.Tx, user User) (bool, error) {
+	_, err := s.users.GetUserByUsername(ctx, tx, user.Username)
+	if err == nil {
+		return true, nil
+	}
+	if err != sql.ErrNoRows {
+		return false, err
+	}
+
+	_, err = s.users.GetUserByEmail(ctx, tx, user.Email)
+	if err == nil {
+		return true, nil
+	}
+	if err != sql.ErrNoRows {
+		return false, err
+	}
+
+	return false, nil
+}
+
+func (s *Seeder) create(ctx context.Context, tx *sql.Tx, user User) error {
+	hashedPassword, err := utils.EncryptPassword(user.Password)
+	if err != nil {
+		return err
+	}
+
+	// admins in fixtures are intended
+	err = s.users.Register(repository.AllowElevatedInsert(ctx), tx, &models.RegisterRequest{
+		Username: user.Username,
+		Email:    user.Email,
+		Password: hashedPassword,
+		IsAdmin:  user.IsAdmin,
+	})
+	if err != nil {
+		return err
+	}
+
+	registered, err := s.users.GetUserByUsername(ctx, tx, user.Username)
+	if err != nil {
+		return err
+	}
+
+	if user.EmailVerified {
+		if err := s.users.MarkEmailVerified(ctx, tx, registered.UserId, user.Email); err != nil {
+			return err
+		}
+	}
+
+	for _, role := range user.Roles {
+		if err := s.roles.AssignRole(ctx, tx, registered.UserId, role); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
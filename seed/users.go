@@ -0,0 +1,125 @@
+// Package seed fills development and test databases with fake users. The
+// users are generated from a seed value, so every run with the same seed
+// creates the same usernames, emails and passwords.
+package seed
+
+import (
+	"errors"
+	"fmt"
+	"go-crud-database/auth"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+var ErrUnknownFixture = errors.New("unknown fixture set")
+
+// User is a user to create, with the password in plain text so it can be
+// used to log in.
+type User struct {
+	Username      string
+	Email         string
+	Password      string
+	IsAdmin       bool
+	EmailVerified bool
+	Roles         []string
+}
+
+var firstNames = []string{
+	"ada", "alan", "amira", "bao", "carla", "dewi", "diego", "elif", "emma", "farah",
+	"grace", "hana", "ivan", "jonas", "kofi", "lena", "linus", "maya", "nia", "omar",
+	"priya", "putri", "rafael", "sara", "sven", "taro", "uma", "vera", "wei", "yusuf",
+}
+
+var lastNames = []string{
+	"adeyemi", "andersson", "kowalski", "nakamura", "nguyen", "novak", "okafor", "olsen",
+	"pratama", "rossi", "santos", "schmidt", "silva", "tanaka", "yilmaz", "wijaya",
+}
+
+var domains = []string{"example.com", "example.org", "example.net"}
+
+var passwordWords = []string{
+	"amber", "breeze", "cactus", "delta", "ember", "fjord", "granite", "harbor",
+	"island", "juniper", "kettle", "lantern", "meadow", "nimbus", "orchid", "pebble",
+}
+
+// Generate returns n users derived from seed. Usernames and emails are unique
+// within one call, passwords satisfy the default password policy. About one
+// in ten users has the support role and one in five has not verified the
+// email address yet.
+func Generate(seed int64, n int) []User {
+	r := rand.New(rand.NewSource(seed))
+
+	users := make([]User, 0, n)
+	for i := 0; i < n; i++ {
+		first := firstNames[r.Intn(len(firstNames))]
+		last := lastNames[r.Intn(len(lastNames))]
+
+		// the number keeps the names unique and short enough for the
+		// 50 characters of the username column
+		username := fmt.Sprintf("%s.%s%d", first, last, i+1)
+
+		user := User{
+			Username:      username,
+			Email:         username + "@" + domains[r.Intn(len(domains))],
+			Password:      generatePassword(r),
+			EmailVerified: r.Intn(5) != 0,
+		}
+		if r.Intn(10) == 0 {
+			user.Roles = []string{auth.RoleSupport}
+		}
+
+		users = append(users, user)
+	}
+
+	return users
+}
+
+// generatePassword returns passwords like "Harbor-pebble-42".
+func generatePassword(r *rand.Rand) string {
+	first := passwordWords[r.Intn(len(passwordWords))]
+	second := passwordWords[r.Intn(len(passwordWords))]
+	return fmt.Sprintf("%s%s-%s-%02d", strings.ToUpper(first[:1]), first[1:], second, r.Intn(100))
+}
+
+// fixtures are fixed sets of users for local development and tests.
+var fixtures = map[string][]User{
+	// an admin and a regular user to log in with
+	"basic": {
+		{Username: "admin", Email: "admin@example.com", Password: "Granite-harbor-17", IsAdmin: true, EmailVerified: true},
+		{Username: "user1", Email: "user1@example.com", Password: "Amber-meadow-42", EmailVerified: true},
+	},
+	// one user for every built-in role, is_admin grants the admin role, and
+	// one who can't log in until the email is verified
+	"roles": {
+		{Username: "admin", Email: "admin@example.com", Password: "Granite-harbor-17", IsAdmin: true, EmailVerified: true},
+		{Username: "support", Email: "support@example.com", Password: "Nimbus-kettle-08", EmailVerified: true, Roles: []string{auth.RoleSupport}},
+		{Username: "member", Email: "member@example.com", Password: "Orchid-fjord-23", EmailVerified: true, Roles: []string{auth.RoleMember}},
+		{Username: "unverified", Email: "unverified@example.com", Password: "Cactus-lantern-61", Roles: []string{auth.RoleMember}},
+	},
+}
+
+// Fixture returns a copy of the named fixture set.
+func Fixture(name string) ([]User, error) {
+	users, ok := fixtures[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %q, the sets are: %s", ErrUnknownFixture, name, strings.Join(FixtureNames(), ", "))
+	}
+
+	copied := make([]User, len(users))
+	for i, user := range users {
+		user.Roles = append([]string(nil), user.Roles...)
+		copied[i] = user
+	}
+	return copied, nil
+}
+
+// FixtureNames returns the names of the fixture sets, sorted.
+func FixtureNames() []string {
+	names := make([]string, 0, len(fixtures))
+	for name := range fixtures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
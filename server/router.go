@@ -0,0 +1,93 @@
+package server
+
+import (
+	"go-crud-database/utils"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Middleware wraps a handler, like RateLimiter.Limit.
+type Middleware func(http.Handler) http.Handler
+
+// Func adapts the middleware written as func(http.HandlerFunc) http.HandlerFunc,
+// like TokenValidator.ValidateToken or RequirePermission.
+func Func(m func(http.HandlerFunc) http.HandlerFunc) Middleware {
+	return func(next http.Handler) http.Handler {
+		return m(next.ServeHTTP)
+	}
+}
+
+// methods are tried to fill the Allow header of a 405 response. HEAD is left
+// out, the handlers only answer the method they were written for.
+var methods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// Router registers routes on a http.ServeMux with Go 1.22 patterns like
+// "GET /api/v1/users/{id}". A group adds a path prefix and middleware to all
+// routes registered through it.
+type Router struct {
+	mux        *http.ServeMux
+	prefix     string
+	middleware []Middleware
+}
+
+func NewRouter() *Router {
+	return &Router{mux: http.NewServeMux()}
+}
+
+// Group returns a router that shares the routes of r. Routes registered on it
+// get prefix added to their path and run behind the middleware of r and then
+// the given middleware.
+func (r *Router) Group(prefix string, middleware ...Middleware) *Router {
+	return &Router{
+		mux:        r.mux,
+		prefix:     r.prefix + prefix,
+		middleware: append(slices.Clip(r.middleware), middleware...),
+	}
+}
+
+// Handle registers handler for method and path. The middleware runs after the
+// middleware of the group, the first one is the outermost.
+func (r *Router) Handle(method, path string, handler http.Handler, middleware ...Middleware) {
+	chain := append(slices.Clip(r.middleware), middleware...)
+	for i := len(chain) - 1; i >= 0; i-- {
+		handler = chain[i](handler)
+	}
+
+	r.mux.Handle(method+" "+r.prefix+path, handler)
+}
+
+func (r *Router) HandleFunc(method, path string, handler http.HandlerFunc, middleware ...Middleware) {
+	r.Handle(method, path, handler, middleware...)
+}
+
+// ServeHTTP answers unknown paths with 404 and known paths with another
+// method with 405 and an Allow header, both as JSON like every other error.
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if _, pattern := r.mux.Handler(req); pattern != "" {
+		r.mux.ServeHTTP(w, req)
+		return
+	}
+
+	if allowed := r.allowedMethods(req); len(allowed) > 0 {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		utils.WriteJson(w, http.StatusMethodNotAllowed, "error", nil, "Method Not Allowed")
+		return
+	}
+
+	utils.WriteJson(w, http.StatusNotFound, "error", nil, "Not Found")
+}
+
+// allowedMethods returns the methods that have a route for the path of req.
+func (r *Router) allowedMethods(req *http.Request) []string {
+	var allowed []string
+	for _, method := range methods {
+		probe := *req
+		probe.Method = method
+		if _, pattern := r.mux.Handler(&probe); pattern != "" {
+			allowed = append(allowed, method)
+		}
+	}
+
+	return allowed
+}
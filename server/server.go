@@ -0,0 +1,117 @@
+package server
+
+import (
+	"go-crud-database/auth"
+	"go-crud-database/handler"
+	"go-crud-database/middleware"
+	"net/http"
+)
+
+// Deps are the handlers and middleware the routes are built from.
+type Deps struct {
+	Users         *handler.UserHandler
+	Roles         *handler.RoleHandler
+	APIKeys       *handler.APIKeyHandler
+	PasswordReset *handler.PasswordResetHandler
+	MagicLinks    *handler.MagicLinkHandler
+	Lockout       *handler.LockoutHandler
+	Impersonation *handler.ImpersonationHandler
+	JWKS          *handler.JWKSHandler
+	// OIDC is optional, without it the single sign-on routes are left out
+	OIDC *handler.OIDCHandler
+
+	Tokens      *middleware.TokenValidator
+	RateLimiter *middleware.RateLimiter
+}
+
+// NewServer returns the handler with every route of the API.
+func NewServer(deps Deps) http.Handler {
+	router := NewRouter()
+
+	router.HandleFunc(http.MethodGet, "/.well-known/jwks.json", deps.JWKS.GetJWKS)
+
+	api := router.Group("/api/v1")
+
+	// every route of the API is rate limited per client IP
+	public := api.Group("", deps.RateLimiter.Limit)
+
+	// refreshing tokens and opening the link of the verification email take
+	// a token that could be guessed at, so they count against the limit too
+	public.HandleFunc(http.MethodPost, "/token/refresh", deps.Users.RefreshToken)
+	public.HandleFunc(http.MethodGet, "/verify-email", deps.Users.VerifyEmail)
+
+	public.HandleFunc(http.MethodPost, "/register", deps.Users.Register)
+	public.HandleFunc(http.MethodPost, "/login", deps.Users.Authentication)
+	public.HandleFunc(http.MethodPost, "/login/mfa", deps.Users.LoginMFA)
+	public.HandleFunc(http.MethodPost, "/login/magic", deps.MagicLinks.RequestMagicLink)
+	public.HandleFunc(http.MethodPost, "/login/magic/verify", deps.MagicLinks.LoginWithMagicLink)
+
+	// single sign-on through the company identity provider, when configured
+	if deps.OIDC != nil {
+		public.HandleFunc(http.MethodGet, "/login/oidc", deps.OIDC.Login)
+		public.HandleFunc(http.MethodGet, "/login/oidc/callback", deps.OIDC.Callback)
+	}
+
+	// account recovery
+	public.HandleFunc(http.MethodPost, "/password/forgot", deps.PasswordReset.ForgotPassword)
+	public.HandleFunc(http.MethodPost, "/password/reset", deps.PasswordReset.ResetPassword)
+
+	// self-service endpoints for the logged in user
+	authed := public.Group("", Func(deps.Tokens.ValidateToken))
+
+	authed.HandleFunc(http.MethodPost, "/logout", deps.Users.Logout)
+	authed.HandleFunc(http.MethodPost, "/verify-email/resend", deps.Users.ResendVerification)
+	authed.HandleFunc(http.MethodGet, "/me", deps.Users.GetMe)
+
+	// routes that can't be undone or that change how the account is
+	// accessed are closed to impersonated sessions
+	guarded := authed.Group("", Func(middleware.DenyImpersonation))
+
+	guarded.HandleFunc(http.MethodPatch, "/me", deps.Users.UpdateMe)
+	guarded.HandleFunc(http.MethodDelete, "/me", deps.Users.DeleteMe)
+	guarded.HandleFunc(http.MethodPost, "/me/password", deps.Users.ChangePassword)
+
+	// personal API keys
+	guarded.HandleFunc(http.MethodGet, "/me/api-keys", deps.APIKeys.ListAPIKeys)
+	guarded.HandleFunc(http.MethodPost, "/me/api-keys", deps.APIKeys.CreateAPIKey)
+	guarded.HandleFunc(http.MethodDelete, "/me/api-keys/{id}", deps.APIKeys.RevokeAPIKey)
+
+	// two-factor authentication
+	guarded.HandleFunc(http.MethodPost, "/me/mfa/enroll", deps.Users.EnrollMFA)
+	guarded.HandleFunc(http.MethodPost, "/me/mfa/confirm", deps.Users.ConfirmMFA)
+	guarded.HandleFunc(http.MethodPost, "/me/mfa/disable", deps.Users.DisableMFA)
+	guarded.HandleFunc(http.MethodPost, "/me/mfa/recovery-codes", deps.Users.RegenerateRecoveryCodes)
+
+	// link a single sign-on account to the logged in user
+	if deps.OIDC != nil {
+		guarded.HandleFunc(http.MethodPost, "/me/identities/oidc", deps.OIDC.LinkAccount)
+	}
+
+	// role management and admin promotion, audited
+	guarded.HandleFunc(http.MethodPost, "/users/roles", deps.Roles.AssignRole, requirePermission(auth.PermissionRolesManage))
+	guarded.HandleFunc(http.MethodDelete, "/users/roles", deps.Roles.RemoveRole, requirePermission(auth.PermissionRolesManage))
+	guarded.HandleFunc(http.MethodPut, "/users/admin", deps.Roles.SetAdmin, requirePermission(auth.PermissionUsersPromote))
+
+	// unlock accounts locked after too many failed logins, audited
+	guarded.HandleFunc(http.MethodPost, "/users/unlock", deps.Lockout.UnlockAccount, requirePermission(auth.PermissionUsersUpdate))
+
+	// act as another user to reproduce a problem, audited. Impersonated
+	// sessions can't impersonate again.
+	guarded.HandleFunc(http.MethodPost, "/users/{id}/impersonate", deps.Impersonation.Impersonate, requirePermission(auth.PermissionUsersImpersonate))
+
+	// the user endpoints scripts call accept API keys as well
+	scripted := public.Group("", Func(deps.Tokens.ValidateTokenOrAPIKey))
+
+	scripted.HandleFunc(http.MethodGet, "/users", deps.Users.GetAllUser, requirePermission(auth.PermissionUsersRead))
+	// plain users can read their own details, the handler checks it
+	scripted.HandleFunc(http.MethodGet, "/users/{id}", deps.Users.GetUserByID)
+	scripted.HandleFunc(http.MethodPut, "/users/{id}", deps.Users.UpdateDataUser, requirePermission(auth.PermissionUsersUpdate))
+	scripted.HandleFunc(http.MethodDelete, "/users/{id}", deps.Users.DeleteDataUser, requirePermission(auth.PermissionUsersDelete), Func(middleware.DenyImpersonation))
+	scripted.HandleFunc(http.MethodGet, "/roles", deps.Roles.GetAllRoles, requirePermission(auth.PermissionRolesManage))
+
+	return router
+}
+
+func requirePermission(permission string) Middleware {
+	return Func(middleware.RequirePermission(permission))
+}
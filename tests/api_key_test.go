@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"go-crud-database/auth"
+	"go-crud-database/middleware"
+	"go-crud-database/models"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeAPIKeyRepo struct {
+	keys map[string]models.APIKey
+}
+
+func (f *fakeAPIKeyRepo) Create(ctx context.Context, key *models.APIKey) error {
+	key.KeyId = len(f.keys) + 1
+	f.keys[key.Prefix] = *key
+	return nil
+}
+
+func (f *fakeAPIKeyRepo) GetByPrefix(ctx context.Context, prefix string) (models.APIKey, error) {
+	key, ok := f.keys[prefix]
+	if !ok {
+		return models.APIKey{}, sql.ErrNoRows
+	}
+	return key, nil
+}
+
+func (f *fakeAPIKeyRepo) ListByUserId(ctx context.Context, userId int) ([]models.APIKey, error) {
+	return nil, nil
+}
+
+func (f *fakeAPIKeyRepo) Revoke(ctx context.Context, userId, keyId int) error {
+	for prefix, key := range f.keys {
+		if key.KeyId == keyId && key.UserId == userId {
+			now := time.Now()
+			key.RevokedAt = &now
+			f.keys[prefix] = key
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (f *fakeAPIKeyRepo) TouchLastUsed(ctx context.Context, keyId int, usedAt time.Time) error {
+	return nil
+}
+
+// fakeRoleRepo gives every user the support role
+type fakeRoleRepo struct{}
+
+func (fakeRoleRepo) GetAllRoles(ctx context.Context) ([]models.Role, error) { return nil, nil }
+
+func (fakeRoleRepo) GetRolesByUserId(ctx context.Context, tx *sql.Tx, userId int) ([]string, error) {
+	return []string{auth.RoleSupport}, nil
+}
+
+func (fakeRoleRepo) GetPermissionsByUserId(ctx context.Context, tx *sql.Tx, userId int) ([]string, error) {
+	return []string{auth.PermissionUsersRead, auth.PermissionUsersUpdate}, nil
+}
+
+func (fakeRoleRepo) AssignRole(ctx context.Context, tx *sql.Tx, userId int, role string) error {
+	return nil
+}
+
+func (fakeRoleRepo) RemoveRole(ctx context.Context, tx *sql.Tx, userId int, role string) error {
+	return nil
+}
+
+func (fakeRoleRepo) CheckRoleExists(ctx context.Context, role string) (bool, error) { return true, nil }
+
+func createTestAPIKey(t *testing.T, repo *fakeAPIKeyRepo, scopes []string, expiresAt *time.Time) string {
+	t.Helper()
+	key, prefix, secretHash, err := auth.GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("GenerateAPIKey returned error: %v", err)
+	}
+	repo.Create(context.Background(), &models.APIKey{UserId: 7, Name: "batch", Prefix: prefix, SecretHash: secretHash, Scopes: scopes, ExpiresAt: expiresAt})
+	return key
+}
+
+func callWithAPIKey(validator *middleware.TokenValidator, key string) (*httptest.ResponseRecorder, *http.Request) {
+	var seen *http.Request
+	handler := validator.ValidateTokenOrAPIKey(func(w http.ResponseWriter, r *http.Request) {
+		seen = r
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+	req.Header.Set("X-API-Key", key)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+
+	return rec, seen
+}
+
+func TestValidateTokenOrAPIKey_ValidKey(t *testing.T) {
+	repo := &fakeAPIKeyRepo{keys: map[string]models.APIKey{}}
+	validator := middleware.NewTokenValidator(nil, nil, auth.NewAPIKeyAuthenticator(repo, fakeRoleRepo{}, &stubUserRepo{user: models.User{UserId: 7}}))
+	key := createTestAPIKey(t, repo, []string{auth.PermissionUsersRead}, nil)
+
+	if !strings.HasPrefix(key, "gcd_") {
+		t.Errorf("Unexpected key format: %s", key)
+	}
+
+	rec, req := callWithAPIKey(validator, key)
+	if req == nil {
+		t.Fatalf("Expected the request to pass, got status %d", rec.Code)
+	}
+
+	principal, ok := auth.FromContext(req.Context())
+	if !ok {
+		t.Fatal("Expected a principal in the context")
+	}
+
+	if principal.UserId != 7 || principal.Method != auth.AuthMethodAPIKey {
+		t.Errorf("Expected user 7 authenticated by api key, got user %d by %s", principal.UserId, principal.Method)
+	}
+
+	// the key is limited to its scopes
+	if !reflect.DeepEqual(principal.Scopes, []string{auth.PermissionUsersRead}) {
+		t.Errorf("Unexpected scopes: %v", principal.Scopes)
+	}
+
+	if !reflect.DeepEqual(principal.Roles, []string{auth.RoleSupport}) {
+		t.Errorf("Unexpected roles: %v", principal.Roles)
+	}
+}
+
+func TestValidateTokenOrAPIKey_RejectsInvalidKeys(t *testing.T) {
+	repo := &fakeAPIKeyRepo{keys: map[string]models.APIKey{}}
+	validator := middleware.NewTokenValidator(nil, nil, auth.NewAPIKeyAuthenticator(repo, fakeRoleRepo{}, &stubUserRepo{user: models.User{UserId: 7}}))
+
+	expired := time.Now().Add(-time.Minute)
+	expiredKey := createTestAPIKey(t, repo, nil, &expired)
+
+	revokedKey := createTestAPIKey(t, repo, nil, nil)
+	repo.Revoke(context.Background(), 7, len(repo.keys))
+
+	validKey := createTestAPIKey(t, repo, nil, nil)
+	wrongSecret := validKey[:len(validKey)-4] + "AAAA"
+
+	for name, key := range map[string]string{
+		"expired":      expiredKey,
+		"revoked":      revokedKey,
+		"wrong secret": wrongSecret,
+		"malformed":    "not-a-key",
+	} {
+		rec, req := callWithAPIKey(validator, key)
+		if req != nil || rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected status %d, got %d", name, http.StatusUnauthorized, rec.Code)
+		}
+	}
+}
+
+func TestValidateTokenOrAPIKey_FallsBackToBearer(t *testing.T) {
+	validator := middleware.NewTokenValidator(nil, nil, nil)
+	handler := validator.ValidateTokenOrAPIKey(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected the request to be rejected")
+	})
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/api/v1/users", nil))
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
+
+func TestScopePermissions(t *testing.T) {
+	permissions := []string{auth.PermissionUsersRead, auth.PermissionUsersUpdate}
+
+	if got := auth.ScopePermissions(permissions, nil); !reflect.DeepEqual(got, permissions) {
+		t.Errorf("A key without scopes should keep every permission, got %v", got)
+	}
+
+	// a scope the user doesn't have is not granted
+	got := auth.ScopePermissions(permissions, []string{auth.PermissionUsersUpdate, auth.PermissionUsersDelete})
+	if !reflect.DeepEqual(got, []string{auth.PermissionUsersUpdate}) {
+		t.Errorf("Unexpected permissions: %v", got)
+	}
+}
+
+func TestValidateTokenOrAPIKey_RejectsKeysOfDisabledUsers(t *testing.T) {
+	repo := &fakeAPIKeyRepo{keys: map[string]models.APIKey{}}
+	owner := &stubUserRepo{user: models.User{UserId: 7}}
+	validator := middleware.NewTokenValidator(nil, nil, auth.NewAPIKeyAuthenticator(repo, fakeRoleRepo{}, owner))
+	key := createTestAPIKey(t, repo, nil, nil)
+
+	// the key itself is still valid, only the account is disabled
+	disabledAt := time.Now()
+	owner.user.DisabledAt = &disabledAt
+
+	rec, req := callWithAPIKey(validator, key)
+	if req != nil || rec.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d for a disabled user, got %d", http.StatusForbidden, rec.Code)
+	}
+
+	owner.user.DisabledAt = nil
+	if _, req := callWithAPIKey(validator, key); req == nil {
+		t.Error("Expected the key to work again once the user is enabled")
+	}
+
+	// a key whose user is gone is just invalid
+	owner.user.UserId = 8
+	rec, req = callWithAPIKey(validator, key)
+	if req != nil || rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d for a deleted user, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"go-crud-database/config"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// setRequiredConfig sets the settings without a default.
+func setRequiredConfig(t *testing.T) {
+	t.Helper()
+	t.Setenv("APP_ENV", "dev")
+	t.Setenv("DB_HOST", "localhost")
+	t.Setenv("DB_USER", "postgres")
+	t.Setenv("DB_NAME", "users_test")
+}
+
+// unsetEnv removes name from the environment until the test ends.
+func unsetEnv(t *testing.T, name string) {
+	t.Helper()
+	t.Setenv(name, "")
+	os.Unsetenv(name)
+}
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Error writing %s: %v", name, err)
+	}
+	return path
+}
+
+func TestLoadConfig_Defaults(t *testing.T) {
+	setRequiredConfig(t)
+
+	cfg, args, err := config.Load([]string{"extra"})
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+
+	want := config.Default()
+	want.Env = config.EnvDev
+	want.DB.Host, want.DB.User, want.DB.Name = "localhost", "postgres", "users_test"
+	if !reflect.DeepEqual(*cfg, want) {
+		t.Errorf("Load() = %+v, want %+v", *cfg, want)
+	}
+	if !reflect.DeepEqual(args, []string{"extra"}) {
+		t.Errorf("Expected the arguments after the flags, got %v", args)
+	}
+}
+
+func TestLoadConfig_Precedence(t *testing.T) {
+	setRequiredConfig(t)
+	path := writeConfigFile(t, "config.yaml", `
+db:
+  port: 5433        # from the file only
+  max-open-conns: 20
+http:
+  read_timeout: 10s
+mail:
+  from: "file <file@example.com>"
+`)
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("DB_MAX_OPEN_CONNS", "30")
+	t.Setenv("MAIL_FROM", "env@example.com")
+
+	cfg, _, err := config.Load([]string{"-mail-from", "flag@example.com"})
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+
+	if cfg.DB.Port != 5433 {
+		t.Errorf("Expected DB port from the file, got %d", cfg.DB.Port)
+	}
+	if cfg.HTTP.ReadTimeout != 10*time.Second {
+		t.Errorf("Expected read timeout from the file, got %s", cfg.HTTP.ReadTimeout)
+	}
+	if cfg.DB.MaxOpenConns != 30 {
+		t.Errorf("Expected the environment to override the file, got %d", cfg.DB.MaxOpenConns)
+	}
+	if cfg.Mail.From != "flag@example.com" {
+		t.Errorf("Expected the flag to override the environment, got %q", cfg.Mail.From)
+	}
+}
+
+func TestLoadConfig_TOML(t *testing.T) {
+	setRequiredConfig(t)
+	unsetEnv(t, "APP_ENV")
+	path := writeConfigFile(t, "config.toml", `
+app_env = "test"
+
+[db]
+sslmode = 'disable' # literal string
+
+[rate_limit]
+requests = 20
+window = "30s"
+`)
+
+	cfg, _, err := config.Load([]string{"-config", path})
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+
+	if cfg.Env != config.EnvTest || cfg.DB.SSLMode != "disable" || cfg.RateLimit.Requests != 20 || cfg.RateLimit.Window != 30*time.Second {
+		t.Errorf("Unexpected config from TOML: env=%q sslmode=%q rate limit=%+v", cfg.Env, cfg.DB.SSLMode, cfg.RateLimit)
+	}
+}
+
+func TestLoadConfig_SecretFile(t *testing.T) {
+	setRequiredConfig(t)
+	t.Setenv("DB_PASSWORD_FILE", writeConfigFile(t, "db_password", "s3cret\n"))
+
+	cfg, _, err := config.Load(nil)
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	if cfg.DB.Password != "s3cret" {
+		t.Errorf("Expected the password from the file without the newline, got %q", cfg.DB.Password)
+	}
+
+	t.Setenv("DB_PASSWORD", "other")
+	if _, _, err := config.Load(nil); err == nil {
+		t.Error("Expected an error when DB_PASSWORD and DB_PASSWORD_FILE are both set")
+	}
+}
+
+func TestLoadConfig_ListsEveryProblem(t *testing.T) {
+	t.Setenv("DB_HOST", "localhost")
+	t.Setenv("DB_PORT", "abc")
+	t.Setenv("HTTP_READ_TIMEOUT", "10")
+	t.Setenv("MAIL_DRIVER", "pigeon")
+	t.Setenv("APP_ENV", "prod")
+
+	_, _, err := config.Load([]string{"-login-max-failures", "0"})
+
+	var validationErr *config.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Expected a ValidationError, got %v", err)
+	}
+
+	want := []string{
+		`DB_PORT: invalid integer "abc"`,
+		`HTTP_READ_TIMEOUT: invalid duration "10", use a value like 30s or 5m`,
+		"DB_USER is required",
+		"DB_NAME is required",
+		`MAIL_DRIVER must be log, file or smtp, got "pigeon"`,
+		"LOGIN_MAX_FAILURES must be at least 1",
+		"JWT_KEYS_DIR is required",
+		"MFA_ENCRYPTION_KEY is required",
+	}
+	if !reflect.DeepEqual(validationErr.Problems, want) {
+		t.Errorf("Problems = %q, want %q", validationErr.Problems, want)
+	}
+}
+
+func TestLoadConfig_ProdNeedsAMailDriver(t *testing.T) {
+	setRequiredConfig(t)
+	t.Setenv("APP_ENV", "prod")
+	t.Setenv("JWT_KEYS_DIR", t.TempDir())
+	t.Setenv("MFA_ENCRYPTION_KEY", base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	unsetEnv(t, "MAIL_DRIVER")
+
+	// the default log driver would write the links to the server log
+	_, _, err := config.Load(nil)
+
+	var validationErr *config.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Expected a ValidationError, got %v", err)
+	}
+	want := []string{"MAIL_DRIVER must be file or smtp in prod, the log driver writes the links of the emails to the server log"}
+	if !reflect.DeepEqual(validationErr.Problems, want) {
+		t.Errorf("Problems = %q, want %q", validationErr.Problems, want)
+	}
+
+	t.Setenv("MAIL_DRIVER", "smtp")
+	t.Setenv("SMTP_HOST", "smtp.example.com")
+	if _, _, err := config.Load(nil); err != nil {
+		t.Errorf("Expected the smtp driver to be accepted, got %v", err)
+	}
+}
+
+func TestLoadConfig_RequiresAppEnv(t *testing.T) {
+	setRequiredConfig(t)
+	unsetEnv(t, "APP_ENV")
+
+	_, _, err := config.Load(nil)
+
+	var validationErr *config.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Expected a ValidationError, got %v", err)
+	}
+	if !reflect.DeepEqual(validationErr.Problems, []string{"APP_ENV is required"}) {
+		t.Errorf("Problems = %q, want only the missing APP_ENV", validationErr.Problems)
+	}
+}
+
+func TestLoadConfig_UnknownFileSetting(t *testing.T) {
+	setRequiredConfig(t)
+	path := writeConfigFile(t, "config.yaml", "db:\n  hots: localhost\n")
+
+	_, _, err := config.Load([]string{"-config", path})
+
+	var validationErr *config.ValidationError
+	if !errors.As(err, &validationErr) || len(validationErr.Problems) != 1 {
+		t.Fatalf("Expected one problem for the unknown key, got %v", err)
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	path := writeConfigFile(t, ".env", `
+# comment
+export CONFIG_TEST_EXPORTED=exported
+CONFIG_TEST_DOUBLE="two words\nand a line # not a comment"
+CONFIG_TEST_SINGLE='literal \n'
+CONFIG_TEST_PLAIN=plain # comment
+CONFIG_TEST_SET=from-file
+`)
+	t.Setenv("CONFIG_TEST_SET", "from-env")
+	for _, name := range []string{"CONFIG_TEST_EXPORTED", "CONFIG_TEST_DOUBLE", "CONFIG_TEST_SINGLE", "CONFIG_TEST_PLAIN"} {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+
+	if err := config.LoadEnv(path); err != nil {
+		t.Fatalf("Error loading .env: %v", err)
+	}
+
+	for name, want := range map[string]string{
+		"CONFIG_TEST_EXPORTED": "exported",
+		"CONFIG_TEST_DOUBLE":   "two words\nand a line # not a comment",
+		"CONFIG_TEST_SINGLE":   `literal \n`,
+		"CONFIG_TEST_PLAIN":    "plain",
+		"CONFIG_TEST_SET":      "from-env",
+	} {
+		if got := os.Getenv(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestLoadEnv_MissingFile(t *testing.T) {
+	if err := config.LoadEnv(filepath.Join(t.TempDir(), ".env")); err != nil {
+		t.Errorf("Expected no error for a missing .env, got %v", err)
+	}
+}
+
+func TestLoadEnv_InvalidLine(t *testing.T) {
+	path := writeConfigFile(t, ".env", "CONFIG_TEST_BROKEN=\"unterminated\n")
+
+	if err := config.LoadEnv(path); err == nil {
+		t.Error("Expected an error for an unterminated quote")
+	}
+}
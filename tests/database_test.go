@@ -1,15 +1,32 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-crud-database/auth"
 	"go-crud-database/config"
+	"go-crud-database/handler"
+	"go-crud-database/migrations"
 	"go-crud-database/models"
 	"go-crud-database/repository"
+	"go-crud-database/seed"
+	"go-crud-database/userctl"
+	"go-crud-database/utils"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	_ "github.com/lib/pq"
 )
@@ -17,40 +34,80 @@ import (
 var testDB *sql.DB
 var userRepo repository.UserRepository
 
+// testDBError tells why testDB is nil. The tests that need the database skip
+// then, unless REQUIRE_TEST_DB is set, so the rest runs without Postgres.
+var testDBError error
+
 func TestMain(m *testing.M) {
-	// Set environment variables for test DB
-	os.Setenv("DB_HOST", "localhost")
-	os.Setenv("DB_PORT", "5432")
-	os.Setenv("DB_USER", "postgres")
-	os.Setenv("DB_PASSWORD", "")
-	os.Setenv("DB_NAME", "users_test")
-	os.Setenv("DB_SSLMODE", "disable")
+	// Settings of the test DB
+	dbConfig := config.Default().DB
+	dbConfig.Host = "localhost"
+	dbConfig.Port = 5432
+	dbConfig.User = "postgres"
+	dbConfig.Password = ""
+	dbConfig.Name = "users_test"
+	dbConfig.SSLMode = "disable"
 
 	// Connect to test DB
-	testDB = config.ConnectToDB()
-	if testDB == nil {
-		log.Fatal("Failed to connect to test database")
-	}
+	testDB, testDBError = connectTestDB(dbConfig)
+	if testDBError != nil {
+		log.Printf("Skipping the database tests: %v", testDBError)
+	} else {
+		// Create the schema
+		migrator, err := migrations.New(testDB)
+		if err != nil {
+			log.Fatalf("Failed to load migrations: %v", err)
+		}
+		if _, err := migrator.Up(context.Background()); err != nil {
+			log.Fatalf("Failed to migrate test database: %v", err)
+		}
 
-	// Assign repository
-	userRepo = repository.NewUserRepository(testDB)
+		// Assign repository
+		userRepo = repository.NewUserRepository(testDB)
+	}
 
 	// Run tests
 	code := m.Run()
 
 	// Close DB connection after all tests
-	testDB.Close()
+	if testDB != nil {
+		testDB.Close()
+	}
 
 	os.Exit(code)
 }
 
+// connectTestDB turns the panic of ConnectToDB into an error.
+func connectTestDB(dbConfig config.DBConfig) (db *sql.DB, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			db, err = nil, fmt.Errorf("%v", r)
+		}
+	}()
+	return config.ConnectToDB(dbConfig), nil
+}
+
+// requireDB skips the test without the test database.
+func requireDB(t *testing.T) {
+	t.Helper()
+	if testDB != nil {
+		return
+	}
+	if os.Getenv("REQUIRE_TEST_DB") != "" {
+		t.Fatalf("Test database is required: %v", testDBError)
+	}
+	t.Skipf("Test database is not available: %v", testDBError)
+}
+
 func TestConnectToDB(t *testing.T) {
+	requireDB(t)
 	if err := testDB.Ping(); err != nil {
 		t.Fatalf("Expected valid DB connection, got error: %v", err)
 	}
 }
 
 func TestGetAllUser(t *testing.T) {
+	requireDB(t)
 	// Buat context kosong, bisa diganti kalau handler punya context tambahan
 	ctx := context.Background()
 	limit := 1
@@ -67,6 +124,7 @@ func TestGetAllUser(t *testing.T) {
 }
 
 func TestRegisterAndGetUserByUsername(t *testing.T) {
+	requireDB(t)
 	ctx := context.Background()
 
 	tx, err := testDB.BeginTx(ctx, nil)
@@ -101,6 +159,7 @@ func TestRegisterAndGetUserByUsername(t *testing.T) {
 }
 
 func TestUpdateAndGetUserById(t *testing.T) {
+	requireDB(t)
 	ctx := context.Background()
 
 	tx, err := testDB.BeginTx(ctx, nil)
@@ -134,7 +193,6 @@ func TestUpdateAndGetUserById(t *testing.T) {
 		UserId:   user.UserId,
 		Username: "updateduser_integration",
 		Email: "updateuser_integration",
-		IsAdmin:  true,
 	}
 
 	// Update user
@@ -154,3 +212,682 @@ func TestUpdateAndGetUserById(t *testing.T) {
 		t.Errorf("Expected username %s, got %s", updateUser.Username, detailUser.Username)
 	}
 }
+
+func TestRegisterRejectsElevatedInsert(t *testing.T) {
+	requireDB(t)
+	ctx := context.Background()
+
+	tx, err := testDB.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	adminUser := &models.RegisterRequest{
+		Username: "testadmin_integration",
+		Email:    "testadmin_integration@example.com",
+		Password: "hashedpassword",
+		IsAdmin:  true,
+	}
+
+	err = userRepo.Register(ctx, tx, adminUser)
+	if err != repository.ErrElevatedInsert {
+		t.Fatalf("Expected ErrElevatedInsert, got %v", err)
+	}
+
+	err = userRepo.Register(repository.AllowElevatedInsert(ctx), tx, adminUser)
+	if err != nil {
+		t.Fatalf("Failed to register admin with AllowElevatedInsert: %v", err)
+	}
+}
+
+// testMigrations create and drop their own tables, recorded in their own
+// table so the schema of the tests is not touched.
+var testMigrations = fstest.MapFS{
+	"0001_create_widgets.up.sql":    {Data: []byte("CREATE TABLE migrate_test_widgets (id serial primary key);")},
+	"0001_create_widgets.down.sql":  {Data: []byte("DROP TABLE migrate_test_widgets;")},
+	"0002_add_widget_name.up.sql":   {Data: []byte("ALTER TABLE migrate_test_widgets ADD COLUMN name text;")},
+	"0002_add_widget_name.down.sql": {Data: []byte("ALTER TABLE migrate_test_widgets DROP COLUMN name;")},
+}
+
+func newTestMigrator(t *testing.T) *migrations.Migrator {
+	t.Helper()
+	loaded, err := migrations.Load(testMigrations)
+	if err != nil {
+		t.Fatalf("Failed to load migrations: %v", err)
+	}
+
+	cleanup := func() {
+		testDB.Exec("DROP TABLE IF EXISTS migrate_test_widgets, migrate_test_migrations")
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	return migrations.NewMigrator(testDB, loaded, "migrate_test_migrations")
+}
+
+func appliedVersions(t *testing.T, migrator *migrations.Migrator) []int {
+	t.Helper()
+	statuses, err := migrator.Status(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get migration status: %v", err)
+	}
+
+	var versions []int
+	for _, status := range statuses {
+		if status.AppliedAt != nil {
+			versions = append(versions, status.Version)
+		}
+	}
+	return versions
+}
+
+func TestMigrator_UpDownGoto(t *testing.T) {
+	requireDB(t)
+	ctx := context.Background()
+	migrator := newTestMigrator(t)
+
+	done, err := migrator.Up(ctx)
+	if err != nil || len(done) != 2 {
+		t.Fatalf("Expected 2 applied migrations, got %d: %v", len(done), err)
+	}
+	if _, err := testDB.Exec("INSERT INTO migrate_test_widgets (name) VALUES ('a')"); err != nil {
+		t.Fatalf("Expected the migrated table, got %v", err)
+	}
+
+	done, err = migrator.Up(ctx)
+	if err != nil || len(done) != 0 {
+		t.Fatalf("Expected nothing to apply, got %d: %v", len(done), err)
+	}
+
+	done, err = migrator.Down(ctx, 1)
+	if err != nil || len(done) != 1 || done[0].Version != 2 {
+		t.Fatalf("Expected version 2 rolled back, got %v: %v", done, err)
+	}
+	if versions := appliedVersions(t, migrator); len(versions) != 1 || versions[0] != 1 {
+		t.Errorf("Expected version 1 applied, got %v", versions)
+	}
+
+	if _, err := migrator.Goto(ctx, 2); err != nil {
+		t.Fatalf("Failed to go to version 2: %v", err)
+	}
+	if _, err := migrator.Goto(ctx, 0); err != nil {
+		t.Fatalf("Failed to go to version 0: %v", err)
+	}
+	if versions := appliedVersions(t, migrator); len(versions) != 0 {
+		t.Errorf("Expected nothing applied, got %v", versions)
+	}
+
+	if _, err := migrator.Goto(ctx, 3); !errors.Is(err, migrations.ErrUnknownVersion) {
+		t.Errorf("Expected ErrUnknownVersion, got %v", err)
+	}
+}
+
+func TestMigrator_ChecksumMismatch(t *testing.T) {
+	requireDB(t)
+	ctx := context.Background()
+	migrator := newTestMigrator(t)
+
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	if _, err := testDB.Exec("UPDATE migrate_test_migrations SET checksum = 'edited' WHERE version = 1"); err != nil {
+		t.Fatalf("Failed to change the checksum: %v", err)
+	}
+
+	if _, err := migrator.Up(ctx); !errors.Is(err, migrations.ErrChecksumMismatch) {
+		t.Errorf("Expected ErrChecksumMismatch, got %v", err)
+	}
+
+	statuses, err := migrator.Status(ctx)
+	if err != nil || !statuses[0].ChecksumChanged {
+		t.Errorf("Expected the status to report the change, got %+v: %v", statuses, err)
+	}
+}
+
+func TestMigrator_ConcurrentUp(t *testing.T) {
+	requireDB(t)
+	ctx := context.Background()
+	migrator := newTestMigrator(t)
+
+	var wg sync.WaitGroup
+	applied := make([]int, 4)
+	errs := make([]error, 4)
+	for i := range applied {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			done, err := migrator.Up(ctx)
+			applied[i], errs[i] = len(done), err
+		}(i)
+	}
+	wg.Wait()
+
+	total := 0
+	for i := range applied {
+		if errs[i] != nil {
+			t.Fatalf("Failed to migrate concurrently: %v", errs[i])
+		}
+		total += applied[i]
+	}
+	if total != 2 {
+		t.Errorf("Expected each migration applied once, got %d applications", total)
+	}
+}
+
+func TestSeeder_SeedsFixture(t *testing.T) {
+	requireDB(t)
+	ctx := context.Background()
+	if err := utils.SetArgon2Params(utils.FastArgon2Params); err != nil {
+		t.Fatalf("Failed to set fast hashing: %v", err)
+	}
+	t.Cleanup(func() { utils.SetArgon2Params(utils.DefaultArgon2Params) })
+
+	users, err := seed.Fixture("roles")
+	if err != nil {
+		t.Fatalf("Failed to get fixture: %v", err)
+	}
+
+	roleRepo := repository.NewRoleRepository(testDB)
+	seeder := seed.NewSeeder(testDB, userRepo, roleRepo, config.EnvTest)
+
+	created, skipped, err := seeder.Seed(ctx, users)
+	if err != nil || created+skipped != len(users) {
+		t.Fatalf("Expected %d users, created %d and skipped %d: %v", len(users), created, skipped, err)
+	}
+
+	// seeding again creates nothing
+	created, skipped, err = seeder.Seed(ctx, users)
+	if err != nil || created != 0 || skipped != len(users) {
+		t.Fatalf("Expected every user skipped, created %d and skipped %d: %v", created, skipped, err)
+	}
+
+	for _, fixture := range users {
+		user, err := userRepo.GetUserByUsername(ctx, nil, fixture.Username)
+		if err != nil {
+			t.Fatalf("Failed to get seeded user %s: %v", fixture.Username, err)
+		}
+		if user.IsAdmin != fixture.IsAdmin || (user.EmailVerifiedAt != nil) != fixture.EmailVerified {
+			t.Errorf("Seeded user %s doesn't match the fixture: %+v", fixture.Username, user)
+		}
+
+		ok, err := userRepo.Authentication(ctx, &models.LoginRequest{Username: fixture.Username, Password: fixture.Password})
+		if err != nil || !ok {
+			t.Errorf("Expected %s to log in with the fixture password: %v", fixture.Username, err)
+		}
+
+		roles, err := roleRepo.GetRolesByUserId(ctx, nil, user.UserId)
+		if err != nil {
+			t.Fatalf("Failed to get roles: %v", err)
+		}
+		for _, role := range fixture.Roles {
+			if !slices.Contains(roles, role) {
+				t.Errorf("Expected %s to have role %s, got %v", fixture.Username, role, roles)
+			}
+		}
+	}
+}
+
+func TestUserctl_Lifecycle(t *testing.T) {
+	requireDB(t)
+	ctx := context.Background()
+	if err := utils.SetArgon2Params(utils.FastArgon2Params); err != nil {
+		t.Fatalf("Failed to set fast hashing: %v", err)
+	}
+	t.Cleanup(func() { utils.SetArgon2Params(utils.DefaultArgon2Params) })
+
+	out := &bytes.Buffer{}
+	app := &userctl.App{
+		DB:          testDB,
+		Users:       userRepo,
+		APIKeys:     repository.NewAPIKeyRepository(testDB),
+		Audit:       repository.NewAuditRepository(testDB),
+		Revocations: auth.NewRevocationStore(repository.NewRevocationRepository(testDB)),
+		Operator:    "tester",
+		In:          strings.NewReader(""),
+		Out:         out,
+	}
+	run := func(args ...string) {
+		t.Helper()
+		out.Reset()
+		if err := app.Run(ctx, args); err != nil {
+			t.Fatalf("userctl %s: %v", strings.Join(args, " "), err)
+		}
+	}
+	get := func() models.DetailUser {
+		t.Helper()
+		run("get", "userctl_integration", "-o", "json")
+		var user models.DetailUser
+		if err := json.Unmarshal(out.Bytes(), &user); err != nil {
+			t.Fatalf("Failed to decode user %q: %v", out.String(), err)
+		}
+		return user
+	}
+
+	run("create", "-username", "userctl_integration", "-email", "userctl_integration@example.com", "-admin", "-verified")
+	t.Cleanup(func() { app.Run(ctx, []string{"delete", "-yes", "userctl_integration"}) })
+
+	// the generated password is printed once and works
+	_, password, found := strings.Cut(out.String(), "password: ")
+	if !found {
+		t.Fatalf("Expected a generated password, got %q", out.String())
+	}
+	ok, err := userRepo.Authentication(ctx, &models.LoginRequest{Username: "userctl_integration", Password: strings.TrimSpace(password)})
+	if err != nil || !ok {
+		t.Errorf("Expected the generated password to log in: %v", err)
+	}
+
+	user := get()
+	if !user.IsAdmin || user.EmailVerifiedAt == nil || user.DisabledAt != nil {
+		t.Fatalf("Unexpected created user %+v", user)
+	}
+
+	run("demote", "-yes", "userctl_integration")
+	run("disable", "-yes", "userctl_integration")
+	user = get()
+	if user.IsAdmin || user.DisabledAt == nil {
+		t.Fatalf("Expected a disabled regular user, got %+v", user)
+	}
+
+	run("export", "-format", "csv")
+	if !strings.Contains(out.String(), "userctl_integration@example.com") || strings.Contains(out.String(), "$argon2") {
+		t.Errorf("Expected the user without a password hash in the export, got %q", out.String())
+	}
+
+	run("enable", "userctl_integration")
+	if user = get(); user.DisabledAt != nil {
+		t.Errorf("Expected the user enabled again, got %+v", user)
+	}
+
+	run("delete", "-yes", "userctl_integration")
+	if err := app.Run(ctx, []string{"get", "userctl_integration"}); !errors.Is(err, userctl.ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound after delete, got %v", err)
+	}
+}
+
+func TestUserRepositoryConformance_Postgres(t *testing.T) {
+	requireDB(t)
+	testUserRepositoryConformance(t, userRepositoryHarness{repo: userRepo, db: testDB})
+}
+
+// createTestUser commits a regular user with the password and deletes it
+// again after the test.
+func createTestUser(t *testing.T, username, password string) models.User {
+	t.Helper()
+	ctx := context.Background()
+
+	if err := utils.SetArgon2Params(utils.FastArgon2Params); err != nil {
+		t.Fatalf("Failed to set fast hashing: %v", err)
+	}
+	t.Cleanup(func() { utils.SetArgon2Params(utils.DefaultArgon2Params) })
+
+	hash, err := utils.EncryptPassword(password)
+	if err != nil {
+		t.Fatalf("Error hashing password: %v", err)
+	}
+
+	tx, err := testDB.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := userRepo.Register(ctx, tx, &models.RegisterRequest{Username: username, Email: username + "@example.com", Password: hash}); err != nil {
+		t.Fatalf("Failed to register %s: %v", username, err)
+	}
+	user, err := userRepo.GetUserByUsername(ctx, tx, username)
+	if err != nil {
+		t.Fatalf("Failed to get %s: %v", username, err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+
+	t.Cleanup(func() {
+		tx, err := testDB.BeginTx(ctx, nil)
+		if err != nil {
+			return
+		}
+		defer tx.Rollback()
+		if userRepo.DeleteUser(ctx, tx, strconv.Itoa(user.UserId)) == nil {
+			tx.Commit()
+		}
+	})
+	return user
+}
+
+// postJSON sends body to a handler and decodes the data of the response.
+func postJSON(t *testing.T, handle http.HandlerFunc, path, remoteAddr, body string, data interface{}) int {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.RemoteAddr = remoteAddr
+	rec := httptest.NewRecorder()
+	handle(rec, req)
+
+	if data != nil && rec.Code < 300 {
+		response := struct {
+			Data interface{} `json:"data"`
+		}{Data: data}
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to decode %s response %q: %v", path, rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+func TestLoginMFA_NewLoginsDontResetFailures(t *testing.T) {
+	requireDB(t)
+	ctx := context.Background()
+	user := createTestUser(t, "mfa_lockout_integration", "Lantern-quarry-47")
+
+	keys, err := auth.NewEphemeralKeyManager()
+	if err != nil {
+		t.Fatalf("Failed to create keys: %v", err)
+	}
+	box, err := auth.NewSecretBox(make([]byte, 32))
+	if err != nil {
+		t.Fatalf("Failed to create secret box: %v", err)
+	}
+	mfa := auth.NewMFAService(repository.NewMFARepository(testDB), box, keys, "test")
+
+	// enable two-factor authentication for the user
+	tx, err := testDB.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	secret, _, err := mfa.Enroll(ctx, tx, user.UserId, user.Username)
+	if err != nil {
+		t.Fatalf("Failed to enroll: %v", err)
+	}
+	code, _ := auth.TOTPCode(secret, time.Now())
+	if _, err := mfa.Confirm(ctx, tx, user.UserId, code); err != nil {
+		t.Fatalf("Failed to confirm: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+
+	throttle := auth.NewLoginThrottle(newFakeLoginAttemptRepo(), 3, time.Minute, time.Hour)
+	verifier := auth.NewEmailVerifier(keys, nil, "", false)
+	userHandler := handler.NewUserHandler(userRepo, repository.NewRefreshTokenRepository(testDB), repository.NewRoleRepository(testDB), nil, keys, verifier, mfa, throttle, testDB)
+
+	login := `{"username": "mfa_lockout_integration", "password": "Lantern-quarry-47"}`
+	for i := 0; i < 3; i++ {
+		// every round from another client, only the account counter adds up
+		remoteAddr := "10.0.1." + strconv.Itoa(i) + ":51234"
+
+		var challenge models.MFAChallengeResponse
+		if status := postJSON(t, userHandler.Authentication, "/api/v1/login", remoteAddr, login, &challenge); status != http.StatusOK || !challenge.MFARequired {
+			t.Fatalf("Round %d: expected a two-factor challenge, got status %d", i, status)
+		}
+
+		body := `{"mfaToken": "` + challenge.MFAToken + `", "code": "not-a-code"}`
+		if status := postJSON(t, userHandler.LoginMFA, "/api/v1/login/mfa", remoteAddr, body, nil); status != http.StatusUnauthorized {
+			t.Fatalf("Round %d: expected status %d for a wrong code, got %d", i, http.StatusUnauthorized, status)
+		}
+	}
+
+	if status := postJSON(t, userHandler.Authentication, "/api/v1/login", "10.0.1.9:51234", login, nil); status != http.StatusLocked {
+		t.Errorf("Expected the account to be locked after %d wrong codes, got status %d", 3, status)
+	}
+}
+
+func TestOIDCHandler_LinksOnlyPlainUsersByEmail(t *testing.T) {
+	requireDB(t)
+	ctx := context.Background()
+
+	keys, err := auth.NewEphemeralKeyManager()
+	if err != nil {
+		t.Fatalf("Failed to create keys: %v", err)
+	}
+	box, err := auth.NewSecretBox(make([]byte, 32))
+	if err != nil {
+		t.Fatalf("Failed to create secret box: %v", err)
+	}
+	mfa := auth.NewMFAService(repository.NewMFARepository(testDB), box, keys, "test")
+	throttle := auth.NewLoginThrottle(newFakeLoginAttemptRepo(), 5, time.Minute, time.Hour)
+	verifier := auth.NewEmailVerifier(keys, nil, "", false)
+	userHandler := handler.NewUserHandler(userRepo, repository.NewRefreshTokenRepository(testDB), repository.NewRoleRepository(testDB), nil, keys, verifier, mfa, throttle, testDB)
+
+	mock := newMockOIDCProvider(t)
+	oidcHandler := handler.NewOIDCHandler(discoverMock(t, mock), repository.NewIdentityRepository(testDB), userHandler, false)
+
+	login := func(user models.User) *httptest.ResponseRecorder {
+		mock.claims["sub"] = "sub-" + user.Username
+		mock.claims["email"] = user.Email
+
+		start := httptest.NewRecorder()
+		oidcHandler.Login(start, httptest.NewRequest(http.MethodGet, "/api/v1/login/oidc", nil))
+		return completeOIDCFlow(t, mock, oidcHandler, start, start.Header().Get("Location"))
+	}
+
+	inTx := func(fn func(tx *sql.Tx) error) {
+		t.Helper()
+		tx, err := testDB.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatalf("Failed to begin transaction: %v", err)
+		}
+		defer tx.Rollback()
+		if err := fn(tx); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("Failed to commit: %v", err)
+		}
+	}
+
+	member := createTestUser(t, "oidc_member_integration", "Copper-meadow-58")
+	if rec := login(member); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "accessToken") {
+		t.Errorf("Expected a plain member to be linked and logged in, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	admin := createTestUser(t, "oidc_admin_integration", "Copper-meadow-58")
+	inTx(func(tx *sql.Tx) error { return userRepo.SetAdmin(ctx, tx, admin.UserId, true) })
+	if rec := login(admin); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected an admin not to be linked by email, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	protected := createTestUser(t, "oidc_mfa_integration", "Copper-meadow-58")
+	inTx(func(tx *sql.Tx) error {
+		secret, _, err := mfa.Enroll(ctx, tx, protected.UserId, protected.Username)
+		if err != nil {
+			return err
+		}
+		code, _ := auth.TOTPCode(secret, time.Now())
+		_, err = mfa.Confirm(ctx, tx, protected.UserId, code)
+		return err
+	})
+	if rec := login(protected); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected a user with two-factor authentication not to be linked by email, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	// linked from the session, the next login still asks for the second factor
+	start := httptest.NewRecorder()
+	oidcHandler.LinkAccount(start, asUser(httptest.NewRequest(http.MethodPost, "/api/v1/me/identities/oidc", nil), protected.UserId))
+	var link models.OIDCLinkResponse
+	response := struct {
+		Data *models.OIDCLinkResponse `json:"data"`
+	}{Data: &link}
+	if err := json.Unmarshal(start.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode link response %q: %v", start.Body.String(), err)
+	}
+	if rec := completeOIDCFlow(t, mock, oidcHandler, start, link.AuthorizationURL); rec.Code != http.StatusOK {
+		t.Fatalf("Expected the account to be linked, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec := login(protected)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "mfaToken") || strings.Contains(rec.Body.String(), "accessToken") {
+		t.Errorf("Expected a two-factor challenge after linking, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRefreshTokenRepository(t *testing.T) {
+	requireDB(t)
+	ctx := context.Background()
+	repo := repository.NewRefreshTokenRepository(testDB)
+
+	tx, err := testDB.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := userRepo.Register(ctx, tx, &models.RegisterRequest{Username: "refresh_integration", Email: "refresh_integration@example.com", Password: "hash"}); err != nil {
+		t.Fatalf("Failed to register user: %v", err)
+	}
+	user, err := userRepo.GetUserByUsername(ctx, tx, "refresh_integration")
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+
+	create := func(familyId, hash string) models.RefreshToken {
+		t.Helper()
+		token := models.RefreshToken{UserId: user.UserId, FamilyId: familyId, TokenHash: hash, ExpiresAt: time.Now().Add(time.Hour).UTC()}
+		if err := repo.Create(ctx, tx, &token); err != nil {
+			t.Fatalf("Failed to create refresh token: %v", err)
+		}
+		return token
+	}
+	get := func(hash string) models.RefreshToken {
+		t.Helper()
+		token, err := repo.GetByHashForUpdate(ctx, tx, hash)
+		if err != nil {
+			t.Fatalf("Failed to get refresh token %s: %v", hash, err)
+		}
+		return token
+	}
+
+	first := create("family-a", "hash-a1")
+	create("family-a", "hash-a2")
+	create("family-b", "hash-b1")
+
+	if token := get("hash-a1"); token.TokenId != first.TokenId || token.UsedAt.Valid || token.RevokedAt.Valid {
+		t.Errorf("Expected a fresh token, got %+v", token)
+	}
+	if _, err := repo.GetByHashForUpdate(ctx, tx, "unknown"); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows for an unknown hash, got %v", err)
+	}
+
+	if err := repo.MarkUsed(ctx, tx, first.TokenId); err != nil {
+		t.Fatalf("MarkUsed() error: %v", err)
+	}
+	if !get("hash-a1").UsedAt.Valid {
+		t.Error("Expected the token to be marked as used")
+	}
+
+	// revoking a family leaves the other sessions alone
+	if err := repo.RevokeFamily(ctx, tx, "family-a"); err != nil {
+		t.Fatalf("RevokeFamily() error: %v", err)
+	}
+	if !get("hash-a1").RevokedAt.Valid || !get("hash-a2").RevokedAt.Valid {
+		t.Error("Expected every token of the family to be revoked")
+	}
+	if get("hash-b1").RevokedAt.Valid {
+		t.Error("Expected the token of another family to stay valid")
+	}
+
+	if err := repo.RevokeAllForUser(ctx, tx, user.UserId); err != nil {
+		t.Fatalf("RevokeAllForUser() error: %v", err)
+	}
+	if !get("hash-b1").RevokedAt.Valid {
+		t.Error("Expected every token of the user to be revoked")
+	}
+}
+
+func TestGetUserByEmailForUpdate_WaitsForLock(t *testing.T) {
+	requireDB(t)
+	ctx := context.Background()
+	user := createTestUser(t, "lock_integration", "Harbor-lantern-29")
+
+	first, err := testDB.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+	defer first.Rollback()
+	if _, err := userRepo.GetUserByEmailForUpdate(ctx, first, user.Email); err != nil {
+		t.Fatalf("Failed to lock the user: %v", err)
+	}
+
+	// a second request for the same user waits until the first one is done
+	locked := make(chan error, 1)
+	go func() {
+		second, err := testDB.BeginTx(ctx, nil)
+		if err != nil {
+			locked <- err
+			return
+		}
+		defer second.Rollback()
+		_, err = userRepo.GetUserByEmailForUpdate(ctx, second, user.Email)
+		locked <- err
+	}()
+
+	select {
+	case err := <-locked:
+		t.Fatalf("Expected the second lock to wait, it returned %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if err := first.Commit(); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+
+	select {
+	case err := <-locked:
+		if err != nil {
+			t.Errorf("Second lock failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Second lock still waits after the first transaction ended")
+	}
+}
+
+func TestBackfillEmailVerified_OnlyUsersOlderThanTheColumn(t *testing.T) {
+	requireDB(t)
+	ctx := context.Background()
+
+	all, err := migrations.All()
+	if err != nil {
+		t.Fatalf("Failed to load the embedded migrations: %v", err)
+	}
+	var backfill string
+	for _, migration := range all {
+		if migration.Version == 14 {
+			backfill = migration.Up
+		}
+	}
+
+	// run the backfill again in a transaction that is rolled back
+	tx, err := testDB.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	insert := "INSERT INTO users (username, email, password, created_at) VALUES ($1, $2, 'hash', $3)"
+	if _, err := tx.ExecContext(ctx, insert, "backfill_old", "backfill_old@example.com", time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("Failed to insert old user: %v", err)
+	}
+	if _, err := tx.ExecContext(ctx, insert, "backfill_new", "backfill_new@example.com", time.Now()); err != nil {
+		t.Fatalf("Failed to insert new user: %v", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, backfill); err != nil {
+		t.Fatalf("Failed to run the backfill: %v", err)
+	}
+
+	verified := func(username string) bool {
+		var verifiedAt sql.NullTime
+		if err := tx.QueryRowContext(ctx, "SELECT email_verified_at FROM users WHERE username = $1", username).Scan(&verifiedAt); err != nil {
+			t.Fatalf("Failed to read %s: %v", username, err)
+		}
+		return verifiedAt.Valid
+	}
+	if !verified("backfill_old") {
+		t.Error("Expected a user from before the migrations to be verified")
+	}
+	if verified("backfill_new") {
+		t.Error("Expected a user with a pending verification to stay unverified")
+	}
+}
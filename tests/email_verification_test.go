@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"go-crud-database/auth"
+	"go-crud-database/mailer"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+type captureMailer struct {
+	sent chan mailer.Message
+}
+
+func (m *captureMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.sent <- msg
+	return nil
+}
+
+func TestEmailVerifier_SendAndParse(t *testing.T) {
+	keys, err := auth.NewEphemeralKeyManager()
+	if err != nil {
+		t.Fatalf("NewEphemeralKeyManager() error: %v", err)
+	}
+
+	capture := &captureMailer{sent: make(chan mailer.Message, 1)}
+	verifier := auth.NewEmailVerifier(keys, capture, "http://localhost:8080/api/v1/verify-email", true)
+
+	if err := verifier.SendVerification(42, "member8", "member8@example.com"); err != nil {
+		t.Fatalf("SendVerification() error: %v", err)
+	}
+
+	var msg mailer.Message
+	select {
+	case msg = <-capture.sent:
+	case <-time.After(time.Second):
+		t.Fatal("verification email was not sent")
+	}
+
+	if msg.To != "member8@example.com" {
+		t.Errorf("Expected email to member8@example.com, got %s", msg.To)
+	}
+
+	// take the token from the link in the body
+	start := strings.Index(msg.Body, "http://")
+	link, err := url.Parse(strings.Fields(msg.Body[start:])[0])
+	if err != nil {
+		t.Fatalf("Failed to parse link: %v", err)
+	}
+
+	userId, email, err := verifier.ParseVerificationToken(link.Query().Get("token"))
+	if err != nil {
+		t.Fatalf("ParseVerificationToken() error: %v", err)
+	}
+
+	if userId != 42 || email != "member8@example.com" {
+		t.Errorf("ParseVerificationToken() = (%d, %s), want (42, member8@example.com)", userId, email)
+	}
+}
+
+func TestEmailVerifier_RejectsAccessToken(t *testing.T) {
+	keys, _ := auth.NewEphemeralKeyManager()
+	verifier := auth.NewEmailVerifier(keys, mailer.NewLogMailer("no-reply@example.com"), "", false)
+
+	accessToken, _ := keys.Sign(jwt.MapClaims{
+		"userId": 42,
+		"sub":    "42",
+		"email":  "member8@example.com",
+		"exp":    time.Now().Add(time.Minute).Unix(),
+	})
+
+	if _, _, err := verifier.ParseVerificationToken(accessToken); err == nil {
+		t.Errorf("a token without the verification purpose must be rejected")
+	}
+}
+
+func TestEmailVerifier_RemindOncePerInterval(t *testing.T) {
+	keys, err := auth.NewEphemeralKeyManager()
+	if err != nil {
+		t.Fatalf("NewEphemeralKeyManager() error: %v", err)
+	}
+
+	capture := &captureMailer{sent: make(chan mailer.Message, 4)}
+	verifier := auth.NewEmailVerifier(keys, capture, "http://localhost:8080/api/v1/verify-email", true)
+
+	if sent, err := verifier.Remind(42, "member8", "member8@example.com"); err != nil || !sent {
+		t.Fatalf("Remind() = (%t, %v), want the first link sent", sent, err)
+	}
+
+	// logging in again with the right password reuses the link just sent
+	if sent, err := verifier.Remind(42, "member8", "member8@example.com"); err != nil || sent {
+		t.Errorf("Remind() = (%t, %v), want no second link", sent, err)
+	}
+
+	// a new address needs its own link
+	if sent, err := verifier.Remind(42, "member8", "new@example.com"); err != nil || !sent {
+		t.Errorf("Remind() = (%t, %v), want a link for the new address", sent, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-capture.sent:
+		case <-time.After(time.Second):
+			t.Fatalf("Expected 2 emails, got %d", i)
+		}
+	}
+	select {
+	case msg := <-capture.sent:
+		t.Errorf("Unexpected email to %s", msg.To)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"go-crud-database/auth"
+	"go-crud-database/handler"
+	"go-crud-database/middleware"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func signImpersonationToken(t *testing.T, keys *auth.KeyManager, userId, actorId int) string {
+	t.Helper()
+
+	now := time.Now()
+	token, err := keys.Sign(jwt.MapClaims{
+		"userId":      userId,
+		"isAdmin":     false,
+		"roles":       []string{auth.RoleMember},
+		"permissions": []string{},
+		"jti":         "impersonation-1",
+		"iat":         now.Unix(),
+		"exp":         now.Add(time.Minute).Unix(),
+		"act":         map[string]interface{}{"userId": actorId},
+	})
+	if err != nil {
+		t.Fatalf("Sign() error: %v", err)
+	}
+	return token
+}
+
+func TestValidateToken_ExposesImpersonator(t *testing.T) {
+	keys, err := auth.NewEphemeralKeyManager()
+	if err != nil {
+		t.Fatalf("NewEphemeralKeyManager() error: %v", err)
+	}
+	validator := middleware.NewTokenValidator(keys, auth.NewRevocationStore(newFakeRevocationRepo()), nil)
+
+	var principal *auth.Principal
+	next := validator.ValidateToken(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ = auth.FromContext(r.Context())
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/me", nil)
+	req.Header.Set("Authorization", "Bearer "+signImpersonationToken(t, keys, 7, 1))
+	rec := httptest.NewRecorder()
+
+	next(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	if principal == nil || principal.UserId != 7 || principal.ImpersonatorId != 1 {
+		t.Fatalf("Expected user 7 impersonated by 1, got %+v", principal)
+	}
+	if principal.TokenId != "impersonation-1" || principal.Method != auth.AuthMethodToken {
+		t.Errorf("Expected token impersonation-1, got %s by %s", principal.TokenId, principal.Method)
+	}
+}
+
+func TestValidateToken_RevokingImpersonatorEndsSession(t *testing.T) {
+	keys, err := auth.NewEphemeralKeyManager()
+	if err != nil {
+		t.Fatalf("NewEphemeralKeyManager() error: %v", err)
+	}
+	revocations := auth.NewRevocationStore(newFakeRevocationRepo())
+	validator := middleware.NewTokenValidator(keys, revocations, nil)
+
+	token := signImpersonationToken(t, keys, 7, 1)
+
+	// the token was issued before the revocation
+	if err := revocations.RevokeUser(context.Background(), 1); err != nil {
+		t.Fatalf("RevokeUser() error: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/me", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+
+	validator.ValidateToken(func(w http.ResponseWriter, r *http.Request) {})(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
+
+func TestDenyImpersonation(t *testing.T) {
+	called := false
+	guarded := middleware.DenyImpersonation(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/v1/users/2", nil)
+	rec := httptest.NewRecorder()
+
+	guarded(rec, withPrincipal(req, &auth.Principal{UserId: 7, ImpersonatorId: 1}))
+
+	if rec.Code != http.StatusForbidden || called {
+		t.Errorf("Expected impersonated request to be blocked, got status %d", rec.Code)
+	}
+
+	// the user's own session passes
+	rec = httptest.NewRecorder()
+
+	guarded(rec, asUser(req, 7))
+
+	if !called {
+		t.Error("Expected request without impersonation to pass")
+	}
+}
+
+func TestImpersonate_RejectsSelfAndMissingReason(t *testing.T) {
+	// the requests are rejected before the handler touches the database
+	impersonationHandler := handler.NewImpersonationHandler(nil, nil)
+
+	tests := []struct {
+		name string
+		id   string
+		body string
+		code int
+	}{
+		{"invalid id", "abc", `{"reason": "ticket 42"}`, http.StatusBadRequest},
+		{"self", "1", `{"reason": "ticket 42"}`, http.StatusBadRequest},
+		{"missing reason", "2", `{}`, http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/users/"+tt.id+"/impersonate", strings.NewReader(tt.body))
+			req.SetPathValue("id", tt.id)
+			rec := httptest.NewRecorder()
+
+			impersonationHandler.Impersonate(rec, asUser(req, 1, auth.PermissionUsersImpersonate))
+
+			if rec.Code != tt.code {
+				t.Errorf("Expected status %d, got %d", tt.code, rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"go-crud-database/auth"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, name+".pem"), data, 0600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+}
+
+func testClaims() jwt.MapClaims {
+	return jwt.MapClaims{"userId": 1, "exp": time.Now().Add(time.Minute).Unix()}
+}
+
+func TestKeyManager_SignAndParse(t *testing.T) {
+	dir := t.TempDir()
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	writePEM(t, dir, "rsa-1", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	edDER, _ := x509.MarshalPKCS8PrivateKey(edKey)
+	writePEM(t, dir, "ed-1", "PRIVATE KEY", edDER)
+
+	for _, kid := range []string{"rsa-1", "ed-1"} {
+		t.Run(kid, func(t *testing.T) {
+			keys, err := auth.LoadKeyManager(dir, kid)
+			if err != nil {
+				t.Fatalf("LoadKeyManager() error: %v", err)
+			}
+
+			tokenString, err := keys.Sign(testClaims())
+			if err != nil {
+				t.Fatalf("Sign() error: %v", err)
+			}
+
+			claims := jwt.MapClaims{}
+			token, err := keys.Parse(tokenString, &claims)
+			if err != nil || !token.Valid {
+				t.Fatalf("Parse() error: %v", err)
+			}
+
+			if token.Header["kid"] != kid {
+				t.Errorf("Expected kid %s, got %v", kid, token.Header["kid"])
+			}
+		})
+	}
+}
+
+func TestKeyManager_Rotation(t *testing.T) {
+	oldPublic, oldPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	newPublic, newPrivate, _ := ed25519.GenerateKey(rand.Reader)
+
+	oldKeys, _ := auth.NewKeyManager("old", &auth.Key{ID: "old", Method: auth.SigningMethodEd25519, PrivateKey: oldPrivate, PublicKey: oldPublic})
+	oldToken, _ := oldKeys.Sign(testClaims())
+
+	// after the rotation only the public part of the old key is kept
+	keys, err := auth.NewKeyManager("new",
+		&auth.Key{ID: "old", Method: auth.SigningMethodEd25519, PublicKey: oldPublic},
+		&auth.Key{ID: "new", Method: auth.SigningMethodEd25519, PrivateKey: newPrivate, PublicKey: newPublic},
+	)
+	if err != nil {
+		t.Fatalf("NewKeyManager() error: %v", err)
+	}
+
+	if _, err := keys.Parse(oldToken, &jwt.MapClaims{}); err != nil {
+		t.Errorf("token signed with the old key should still verify: %v", err)
+	}
+
+	if len(keys.JWKS().Keys) != 2 {
+		t.Errorf("Expected 2 keys in JWKS, got %d", len(keys.JWKS().Keys))
+	}
+
+	if _, err := auth.NewKeyManager("old", &auth.Key{ID: "old", Method: auth.SigningMethodEd25519, PublicKey: oldPublic}); err == nil {
+		t.Errorf("a public key must not be accepted as signing key")
+	}
+}
+
+func TestKeyManager_RejectsAlgorithmConfusion(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	keys, _ := auth.NewKeyManager("rsa", &auth.Key{ID: "rsa", Method: jwt.SigningMethodRS256, PrivateKey: rsaKey, PublicKey: &rsaKey.PublicKey})
+
+	// an attacker signs an HS256 token using the public key as HMAC secret
+	publicDER, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+	forged.Header["kid"] = "rsa"
+	forgedString, _ := forged.SignedString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}))
+
+	if _, err := keys.Parse(forgedString, &jwt.MapClaims{}); err == nil {
+		t.Errorf("HS256 token must be rejected for an RS256 key")
+	}
+
+	unknown := jwt.NewWithClaims(jwt.SigningMethodRS256, testClaims())
+	unknown.Header["kid"] = "missing"
+	unknownString, _ := unknown.SignedString(rsaKey)
+	if _, err := keys.Parse(unknownString, &jwt.MapClaims{}); err == nil {
+		t.Errorf("token with unknown kid must be rejected")
+	}
+}
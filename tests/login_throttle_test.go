@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"go-crud-database/auth"
+	"go-crud-database/handler"
+	"go-crud-database/models"
+	"go-crud-database/repository"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeLoginAttemptRepo struct {
+	attempts map[string]models.LoginAttempt
+}
+
+func newFakeLoginAttemptRepo() *fakeLoginAttemptRepo {
+	return &fakeLoginAttemptRepo{attempts: map[string]models.LoginAttempt{}}
+}
+
+func (f *fakeLoginAttemptRepo) GetLoginAttempt(ctx context.Context, key string) (models.LoginAttempt, error) {
+	attempt, ok := f.attempts[key]
+	if !ok {
+		return models.LoginAttempt{}, sql.ErrNoRows
+	}
+	return attempt, nil
+}
+
+func (f *fakeLoginAttemptRepo) RecordFailure(ctx context.Context, key string, failedAt time.Time, window time.Duration) (int, error) {
+	attempt := f.attempts[key]
+	if attempt.LastFailedAt.Before(failedAt.Add(-window)) {
+		attempt.Failures = 0
+	}
+	attempt.AttemptKey = key
+	attempt.Failures++
+	attempt.LastFailedAt = failedAt
+	f.attempts[key] = attempt
+	return attempt.Failures, nil
+}
+
+func (f *fakeLoginAttemptRepo) LockUntil(ctx context.Context, key string, lockedUntil time.Time) error {
+	attempt := f.attempts[key]
+	attempt.LockedUntil = sql.NullTime{Time: lockedUntil, Valid: true}
+	f.attempts[key] = attempt
+	return nil
+}
+
+func (f *fakeLoginAttemptRepo) Reset(ctx context.Context, key string) error {
+	delete(f.attempts, key)
+	return nil
+}
+
+func TestLoginThrottle_LockoutDelay(t *testing.T) {
+	throttle := auth.NewLoginThrottle(newFakeLoginAttemptRepo(), 3, time.Second, 10*time.Second)
+
+	tests := []struct {
+		failures int
+		delay    time.Duration
+	}{
+		{1, 0},
+		{2, 0},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{5, 4 * time.Second},
+		{6, 8 * time.Second},
+		{7, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if delay := throttle.LockoutDelay(tt.failures); delay != tt.delay {
+			t.Errorf("LockoutDelay(%d) = %v, want %v", tt.failures, delay, tt.delay)
+		}
+	}
+}
+
+func TestLoginThrottle_LocksAccountAndClient(t *testing.T) {
+	ctx := context.Background()
+	throttle := auth.NewLoginThrottle(newFakeLoginAttemptRepo(), 3, time.Minute, time.Hour)
+
+	for i := 0; i < 2; i++ {
+		if err := throttle.RecordFailure(ctx, "alice", "10.0.0.1"); err != nil {
+			t.Fatalf("RecordFailure returned error: %v", err)
+		}
+	}
+
+	if reason, _, _ := throttle.Check(ctx, "alice", "10.0.0.1"); reason != auth.NotLocked {
+		t.Fatalf("Expected no lock below the limit, got %v", reason)
+	}
+
+	throttle.RecordFailure(ctx, "alice", "10.0.0.1")
+
+	reason, retryAfter, err := throttle.Check(ctx, "alice", "10.0.0.2")
+	if err != nil {
+		t.Fatalf("Check returned error: %v", err)
+	}
+	if reason != auth.LockedAccount {
+		t.Errorf("Expected the account to be locked, got %v", reason)
+	}
+	if retryAfter <= 0 || retryAfter > time.Minute {
+		t.Errorf("Unexpected retry after: %v", retryAfter)
+	}
+
+	// the same client is locked for other usernames too
+	if reason, _, _ := throttle.Check(ctx, "bob", "10.0.0.1"); reason != auth.LockedClient {
+		t.Errorf("Expected the client to be locked, got %v", reason)
+	}
+
+	if err := throttle.Unlock(ctx, "alice"); err != nil {
+		t.Fatalf("Unlock returned error: %v", err)
+	}
+	if reason, _, _ := throttle.Check(ctx, "alice", "10.0.0.2"); reason != auth.NotLocked {
+		t.Errorf("Expected the account to be unlocked, got %v", reason)
+	}
+}
+
+func TestLoginThrottle_SuccessKeepsClientCounter(t *testing.T) {
+	ctx := context.Background()
+	throttle := auth.NewLoginThrottle(newFakeLoginAttemptRepo(), 2, time.Minute, time.Hour)
+
+	throttle.RecordFailure(ctx, "alice", "10.0.0.1")
+	throttle.RecordSuccess(ctx, "alice")
+	throttle.RecordFailure(ctx, "bob", "10.0.0.1")
+
+	if reason, _, _ := throttle.Check(ctx, "carol", "10.0.0.1"); reason != auth.LockedClient {
+		t.Errorf("Expected the client to be locked, got %v", reason)
+	}
+	if reason, _, _ := throttle.Check(ctx, "alice", "10.0.0.2"); reason != auth.NotLocked {
+		t.Errorf("Expected alice not to be locked, got %v", reason)
+	}
+}
+
+func TestAuthentication_LockedAccount(t *testing.T) {
+	ctx := context.Background()
+	throttle := auth.NewLoginThrottle(newFakeLoginAttemptRepo(), 1, time.Minute, time.Hour)
+	throttle.RecordFailure(ctx, "alice", "10.0.0.1")
+
+	// the lock is checked before the handler touches the database
+	userHandler := handler.NewUserHandler(nil, nil, nil, nil, nil, nil, nil, throttle, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/login", strings.NewReader(`{"username": "alice", "password": "password"}`))
+	req.RemoteAddr = "10.0.0.2:51234"
+	rec := httptest.NewRecorder()
+
+	userHandler.Authentication(rec, req)
+
+	if rec.Code != http.StatusLocked {
+		t.Errorf("Expected status %d, got %d", http.StatusLocked, rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("Expected a Retry-After header")
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/login", strings.NewReader(`{"username": "bob", "password": "password"}`))
+	req.RemoteAddr = "10.0.0.1:51234"
+	rec = httptest.NewRecorder()
+
+	userHandler.Authentication(rec, req)
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d, got %d", http.StatusTooManyRequests, rec.Code)
+	}
+}
+
+// abortingAuditRepo ends the transaction while recording, so the commit of
+// the handler fails.
+type abortingAuditRepo struct{}
+
+func (abortingAuditRepo) Record(ctx context.Context, tx *sql.Tx, entry *models.AuditLog) error {
+	return tx.Rollback()
+}
+
+func TestUnlockAccount_StaysLockedWithoutAuditEntry(t *testing.T) {
+	ctx := context.Background()
+	users, db := repository.NewMemoryUserRepository()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := users.Register(ctx, tx, &models.RegisterRequest{Username: "alice", Email: "alice@example.com", Password: "hash"}); err != nil {
+		t.Fatal(err)
+	}
+	user, err := users.GetUserByUsername(ctx, tx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	throttle := auth.NewLoginThrottle(newFakeLoginAttemptRepo(), 1, time.Minute, time.Hour)
+	throttle.RecordFailure(ctx, "alice", "10.0.0.1")
+
+	lockoutHandler := handler.NewLockoutHandler(throttle, users, abortingAuditRepo{}, db)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/users/unlock", strings.NewReader(`{"userId": `+strconv.Itoa(user.UserId)+`}`))
+	rec := httptest.NewRecorder()
+	lockoutHandler.UnlockAccount(rec, asUser(req, 1, auth.PermissionUsersUpdate))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status %d, got %d", http.StatusInternalServerError, rec.Code)
+	}
+	if reason, _, _ := throttle.Check(ctx, "alice", "10.0.0.2"); reason != auth.LockedAccount {
+		t.Errorf("Expected the account to stay locked, got %v", reason)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"go-crud-database/auth"
+	"go-crud-database/handler"
+	"go-crud-database/mailer"
+	"go-crud-database/models"
+	"go-crud-database/repository"
+	"go-crud-database/utils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMagicLinkToken_RoundTrip(t *testing.T) {
+	keys, err := auth.NewEphemeralKeyManager()
+	if err != nil {
+		t.Fatalf("NewEphemeralKeyManager() error: %v", err)
+	}
+
+	first, err := auth.SignMagicLinkToken(keys, 7, time.Minute)
+	if err != nil {
+		t.Fatalf("SignMagicLinkToken() error: %v", err)
+	}
+	second, err := auth.SignMagicLinkToken(keys, 7, time.Minute)
+	if err != nil {
+		t.Fatalf("SignMagicLinkToken() error: %v", err)
+	}
+	if first == second {
+		t.Error("Expected every sign-in link to be unique")
+	}
+
+	userId, err := auth.ParseMagicLinkToken(keys, first)
+	if err != nil {
+		t.Fatalf("ParseMagicLinkToken() error: %v", err)
+	}
+	if userId != 7 {
+		t.Errorf("Expected user id 7, got %d", userId)
+	}
+}
+
+func TestMagicLinkToken_RejectsExpiredAndOtherPurposes(t *testing.T) {
+	keys, err := auth.NewEphemeralKeyManager()
+	if err != nil {
+		t.Fatalf("NewEphemeralKeyManager() error: %v", err)
+	}
+
+	expired, err := auth.SignMagicLinkToken(keys, 7, -time.Minute)
+	if err != nil {
+		t.Fatalf("SignMagicLinkToken() error: %v", err)
+	}
+	if _, err := auth.ParseMagicLinkToken(keys, expired); err != auth.ErrInvalidMagicLink {
+		t.Errorf("Expected ErrInvalidMagicLink for an expired link, got %v", err)
+	}
+
+	// an mfa pending token is signed by the same keys but must not log in
+	mfa := auth.NewMFAService(nil, nil, keys, "test")
+	pending, err := mfa.IssuePendingToken(7)
+	if err != nil {
+		t.Fatalf("IssuePendingToken() error: %v", err)
+	}
+	if _, err := auth.ParseMagicLinkToken(keys, pending); err != auth.ErrInvalidMagicLink {
+		t.Errorf("Expected ErrInvalidMagicLink for another purpose, got %v", err)
+	}
+
+	// and a sign-in link is no access token
+	link, _ := auth.SignMagicLinkToken(keys, 7, time.Minute)
+	if _, err := mfa.ParsePendingToken(link); err == nil {
+		t.Error("Expected a sign-in link to be rejected as mfa pending token")
+	}
+}
+
+func TestRequestMagicLink_RejectsInvalidEmail(t *testing.T) {
+	// the request is rejected before the handler touches the database
+	magicLinkHandler := handler.NewMagicLinkHandler(nil, nil, nil, "http://localhost:8080/magic-login")
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/login/magic", strings.NewReader(`{"email": "not-an-email"}`))
+	rec := httptest.NewRecorder()
+
+	magicLinkHandler.RequestMagicLink(rec, req)
+
+	if rec.Code != http.StatusConflict {
+		t.Errorf("Expected status %d, got %d", http.StatusConflict, rec.Code)
+	}
+}
+
+func TestLoginWithMagicLink_RejectsForgedToken(t *testing.T) {
+	keys, err := auth.NewEphemeralKeyManager()
+	if err != nil {
+		t.Fatalf("NewEphemeralKeyManager() error: %v", err)
+	}
+	userHandler := handler.NewUserHandler(nil, nil, nil, nil, keys, nil, nil, nil, nil)
+	magicLinkHandler := handler.NewMagicLinkHandler(nil, userHandler, nil, "http://localhost:8080/magic-login")
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/login/magic/verify", strings.NewReader(`{"token": "forged"}`))
+	rec := httptest.NewRecorder()
+
+	magicLinkHandler.LoginWithMagicLink(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+// fakeMagicLinkRepo keeps sign-in links by hash. It ignores tx.
+type fakeMagicLinkRepo struct {
+	created chan models.MagicLinkToken
+	links   map[string]int
+}
+
+func newFakeMagicLinkRepo() *fakeMagicLinkRepo {
+	return &fakeMagicLinkRepo{created: make(chan models.MagicLinkToken, 2), links: map[string]int{}}
+}
+
+func (f *fakeMagicLinkRepo) Create(ctx context.Context, tx *sql.Tx, token *models.MagicLinkToken) error {
+	f.links[token.TokenHash] = token.UserId
+	f.created <- *token
+	return nil
+}
+
+func (f *fakeMagicLinkRepo) CountRecent(ctx context.Context, tx *sql.Tx, userId int, window time.Duration) (int, error) {
+	return 0, nil
+}
+
+func (f *fakeMagicLinkRepo) Consume(ctx context.Context, tx *sql.Tx, tokenHash string, now time.Time) (int, error) {
+	userId, ok := f.links[tokenHash]
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+	return userId, nil
+}
+
+// newMagicLinkTestUser registers a user in an in-memory repository.
+func newMagicLinkTestUser(t *testing.T) (repository.UserRepository, *sql.DB, models.User) {
+	t.Helper()
+	ctx := context.Background()
+
+	users, db := repository.NewMemoryUserRepository()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := users.Register(ctx, tx, &models.RegisterRequest{Username: "linked", Email: "linked@example.com", Password: "hash"}); err != nil {
+		t.Fatal(err)
+	}
+	user, err := users.GetUserByUsername(ctx, tx, "linked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	return users, db, user
+}
+
+func TestRequestMagicLink_AnswersBeforeLookingUpTheEmail(t *testing.T) {
+	users, db, _ := newMagicLinkTestUser(t)
+	keys, err := auth.NewEphemeralKeyManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slow := &slowUserRepo{UserRepository: users, release: make(chan struct{})}
+	links := newFakeMagicLinkRepo()
+	capture := &captureMailer{sent: make(chan mailer.Message, 2)}
+	userHandler := handler.NewUserHandler(slow, nil, nil, nil, keys, nil, nil, nil, db)
+	magicLinkHandler := handler.NewMagicLinkHandler(links, userHandler, capture, "http://localhost:3000/magic-login")
+
+	request := func(email string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/login/magic", strings.NewReader(`{"email": "`+email+`"}`))
+		rec := httptest.NewRecorder()
+		magicLinkHandler.RequestMagicLink(rec, req)
+		return rec
+	}
+
+	// both answers are written while the lookups still wait, so they can't
+	// take different times
+	known := request("linked@example.com")
+	unknown := request("nobody@example.com")
+
+	if known.Code != http.StatusOK || known.Body.String() != unknown.Body.String() {
+		t.Fatalf("Expected the same answer for known and unknown emails, got %d %q and %d %q", known.Code, known.Body.String(), unknown.Code, unknown.Body.String())
+	}
+
+	close(slow.release)
+
+	select {
+	case msg := <-capture.sent:
+		if msg.To != "linked@example.com" || !strings.Contains(msg.Body, "http://localhost:3000/magic-login?token=") {
+			t.Errorf("Unexpected sign-in email %+v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("sign-in email was not sent")
+	}
+
+	select {
+	case <-links.created:
+	case <-time.After(time.Second):
+		t.Fatal("sign-in link was not stored")
+	}
+
+	select {
+	case msg := <-capture.sent:
+		t.Errorf("Unexpected email to %s", msg.To)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestLoginWithMagicLink_RefusesLockedAccount(t *testing.T) {
+	users, db, user := newMagicLinkTestUser(t)
+	keys, err := auth.NewEphemeralKeyManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// one failed password from another client locks the account
+	throttle := auth.NewLoginThrottle(newFakeLoginAttemptRepo(), 1, time.Minute, time.Hour)
+	if err := throttle.RecordFailure(context.Background(), user.Username, "10.0.0.9"); err != nil {
+		t.Fatal(err)
+	}
+
+	links := newFakeMagicLinkRepo()
+	token, err := auth.SignMagicLinkToken(keys, user.UserId, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	links.links[utils.HashToken(token)] = user.UserId
+
+	userHandler := handler.NewUserHandler(users, newFakeRefreshTokenRepo(), fakeRoleRepo{}, nil, keys, nil, nil, throttle, db)
+	magicLinkHandler := handler.NewMagicLinkHandler(links, userHandler, nil, "http://localhost:3000/magic-login")
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/login/magic/verify", strings.NewReader(`{"token": "`+token+`"}`))
+	req.RemoteAddr = "10.0.0.1:51234"
+	rec := httptest.NewRecorder()
+
+	magicLinkHandler.LoginWithMagicLink(rec, req)
+
+	if rec.Code != http.StatusLocked {
+		t.Errorf("Expected status %d, got %d: %s", http.StatusLocked, rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"go-crud-database/mailer"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileMailer_Send(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.log")
+	m := mailer.NewFileMailer(path, "no-reply@example.com")
+
+	err := m.Send(context.Background(), mailer.Message{
+		To:      "user@example.com",
+		Subject: "Reset your password\r\nBcc: attacker@example.com",
+		Body:    "http://localhost:8080/reset-password?token=abc",
+	})
+	if err != nil {
+		t.Fatalf("Send() error: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read mail file: %v", err)
+	}
+	content := string(data)
+
+	if !strings.Contains(content, "To: user@example.com\r\n") {
+		t.Errorf("Expected To header in %q", content)
+	}
+
+	if !strings.Contains(content, "token=abc") {
+		t.Errorf("Expected body in %q", content)
+	}
+
+	// the line break in the subject must not create a new header
+	if strings.Contains(content, "\r\nBcc:") {
+		t.Errorf("Header injection in %q", content)
+	}
+}
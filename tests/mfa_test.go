@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"go-crud-database/auth"
+	"go-crud-database/handler"
+	"go-crud-database/models"
+	"go-crud-database/repository"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeMFARepo keeps the second factor of users in memory. It ignores tx.
+type fakeMFARepo struct {
+	mfa           map[int]*models.UserMFA
+	recoveryCodes map[int]map[string]bool
+}
+
+func newFakeMFARepo() *fakeMFARepo {
+	return &fakeMFARepo{mfa: map[int]*models.UserMFA{}, recoveryCodes: map[int]map[string]bool{}}
+}
+
+func (f *fakeMFARepo) GetByUserIdForUpdate(ctx context.Context, tx *sql.Tx, userId int) (models.UserMFA, error) {
+	mfa, ok := f.mfa[userId]
+	if !ok {
+		return models.UserMFA{}, sql.ErrNoRows
+	}
+	return *mfa, nil
+}
+
+func (f *fakeMFARepo) SavePending(ctx context.Context, tx *sql.Tx, userId int, secretEncrypted string) error {
+	f.mfa[userId] = &models.UserMFA{UserId: userId, SecretEncrypted: secretEncrypted, CreatedAt: time.Now()}
+	return nil
+}
+
+func (f *fakeMFARepo) Enable(ctx context.Context, tx *sql.Tx, userId int, usedStep int64) error {
+	f.mfa[userId].EnabledAt = sql.NullTime{Time: time.Now(), Valid: true}
+	f.mfa[userId].LastUsedStep = usedStep
+	return nil
+}
+
+func (f *fakeMFARepo) UpdateLastUsedStep(ctx context.Context, tx *sql.Tx, userId int, usedStep int64) error {
+	f.mfa[userId].LastUsedStep = usedStep
+	return nil
+}
+
+func (f *fakeMFARepo) Delete(ctx context.Context, tx *sql.Tx, userId int) error {
+	delete(f.mfa, userId)
+	delete(f.recoveryCodes, userId)
+	return nil
+}
+
+func (f *fakeMFARepo) ReplaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userId int, codeHashes []string) error {
+	f.recoveryCodes[userId] = map[string]bool{}
+	for _, hash := range codeHashes {
+		f.recoveryCodes[userId][hash] = true
+	}
+	return nil
+}
+
+func (f *fakeMFARepo) UseRecoveryCode(ctx context.Context, tx *sql.Tx, userId int, codeHash string) (bool, error) {
+	if !f.recoveryCodes[userId][codeHash] {
+		return false, nil
+	}
+	delete(f.recoveryCodes[userId], codeHash)
+	return true, nil
+}
+
+func TestRegenerateRecoveryCodes(t *testing.T) {
+	ctx := context.Background()
+	keys, err := auth.NewEphemeralKeyManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	box, err := auth.NewSecretBox(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfa := auth.NewMFAService(newFakeMFARepo(), box, keys, "test")
+
+	// enabled with the code of the previous step, so the current one is unused
+	secret, _, err := mfa.Enroll(ctx, nil, 7, "member7")
+	if err != nil {
+		t.Fatalf("Enroll() error: %v", err)
+	}
+	code, _ := auth.TOTPCode(secret, time.Now().Add(-30*time.Second))
+	oldCodes, err := mfa.Confirm(ctx, nil, 7, code)
+	if err != nil {
+		t.Fatalf("Confirm() error: %v", err)
+	}
+
+	// the memory database only provides the transaction
+	_, db := repository.NewMemoryUserRepository()
+	userHandler := handler.NewUserHandler(nil, nil, nil, nil, keys, nil, mfa, nil, db)
+
+	regenerate := func(code string) (int, models.RecoveryCodesResponse) {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/me/mfa/recovery-codes", strings.NewReader(`{"code": "`+code+`"}`))
+		var response models.RecoveryCodesResponse
+		rec := httptest.NewRecorder()
+		userHandler.RegenerateRecoveryCodes(rec, asUser(req, 7))
+		if rec.Code == http.StatusOK {
+			body := struct {
+				Data *models.RecoveryCodesResponse `json:"data"`
+			}{Data: &response}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Failed to decode response %q: %v", rec.Body.String(), err)
+			}
+		}
+		return rec.Code, response
+	}
+
+	// a recovery code that got out can't replace the others
+	if status, _ := regenerate(oldCodes[0]); status != http.StatusUnauthorized {
+		t.Errorf("Expected status %d for a recovery code, got %d", http.StatusUnauthorized, status)
+	}
+
+	current, _ := auth.TOTPCode(secret, time.Now())
+	status, response := regenerate(current)
+	if status != http.StatusOK || len(response.RecoveryCodes) != len(oldCodes) {
+		t.Fatalf("Expected %d new recovery codes, got status %d and %v", len(oldCodes), status, response.RecoveryCodes)
+	}
+
+	// the TOTP code is used up
+	if status, _ := regenerate(current); status != http.StatusUnauthorized {
+		t.Errorf("Expected status %d for a replayed code, got %d", http.StatusUnauthorized, status)
+	}
+
+	if err := mfa.Verify(ctx, nil, 7, oldCodes[1]); err != auth.ErrInvalidMFACode {
+		t.Errorf("Expected the old recovery codes to stop working, got %v", err)
+	}
+	if err := mfa.Verify(ctx, nil, 7, response.RecoveryCodes[0]); err != nil {
+		t.Errorf("Expected a new recovery code to work, got %v", err)
+	}
+
+	// without two-factor authentication there is nothing to replace
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/me/mfa/recovery-codes", strings.NewReader(`{"code": "123456"}`))
+	rec := httptest.NewRecorder()
+	userHandler.RegenerateRecoveryCodes(rec, asUser(req, 8))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d without two-factor authentication, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"go-crud-database/migrations"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestEmbeddedMigrations(t *testing.T) {
+	all, err := migrations.All()
+	if err != nil {
+		t.Fatalf("Failed to load the embedded migrations: %v", err)
+	}
+	if len(all) == 0 {
+		t.Fatal("Expected embedded migrations")
+	}
+
+	for i, migration := range all {
+		// numbered without gaps, so a forgotten file is noticed
+		if migration.Version != i+1 {
+			t.Errorf("Expected version %d, got %d_%s", i+1, migration.Version, migration.Name)
+		}
+		if len(migration.Checksum) != 64 {
+			t.Errorf("Expected a SHA-256 checksum for %d_%s, got %q", migration.Version, migration.Name, migration.Checksum)
+		}
+	}
+}
+
+func TestEmbeddedMigrations_UsersAreNotAdminsByDefault(t *testing.T) {
+	all, err := migrations.All()
+	if err != nil {
+		t.Fatalf("Failed to load the embedded migrations: %v", err)
+	}
+
+	var schema strings.Builder
+	for _, migration := range all {
+		schema.WriteString(strings.ToLower(migration.Up))
+	}
+	if strings.Contains(schema.String(), "is_admin boolean default true") {
+		t.Error("Expected is_admin to default to false")
+	}
+	if !strings.Contains(schema.String(), "alter column is_admin set default false") {
+		t.Error("Expected a migration fixing the is_admin default of existing databases")
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	loaded, err := migrations.Load(fstest.MapFS{
+		"0002_second.up.sql":   {Data: []byte("SELECT 2;")},
+		"0002_second.down.sql": {Data: []byte("-- nothing to undo")},
+		"0001_first.up.sql":    {Data: []byte("SELECT 1;")},
+		"0001_first.down.sql":  {Data: []byte("SELECT 1;")},
+		"README.md":            {Data: []byte("not a migration")},
+	})
+	if err != nil {
+		t.Fatalf("Failed to load migrations: %v", err)
+	}
+
+	if len(loaded) != 2 || loaded[0].Name != "first" || loaded[1].Name != "second" {
+		t.Fatalf("Expected first and second in order, got %+v", loaded)
+	}
+	if loaded[0].Checksum == loaded[1].Checksum {
+		t.Error("Expected different checksums for different migrations")
+	}
+}
+
+func TestLoadMigrations_Invalid(t *testing.T) {
+	for name, fsys := range map[string]fstest.MapFS{
+		"missing down": {
+			"0001_first.up.sql": {Data: []byte("SELECT 1;")},
+		},
+		"missing up": {
+			"0001_first.down.sql": {Data: []byte("SELECT 1;")},
+		},
+		"duplicate version": {
+			"0001_first.up.sql":   {Data: []byte("SELECT 1;")},
+			"0001_first.down.sql": {Data: []byte("SELECT 1;")},
+			"0001_other.up.sql":   {Data: []byte("SELECT 1;")},
+			"0001_other.down.sql": {Data: []byte("SELECT 1;")},
+		},
+		"bad name": {
+			"init-dummy.sql": {Data: []byte("SELECT 1;")},
+		},
+	} {
+		if _, err := migrations.Load(fsys); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
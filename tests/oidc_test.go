@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"go-crud-database/auth"
+	"go-crud-database/handler"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	mockClientID     = "go-crud-database"
+	mockClientSecret = "s3cret"
+	mockRedirectURL  = "http://localhost:8080/api/v1/login/oidc/callback"
+)
+
+type mockAuthorization struct {
+	challenge string
+	nonce     string
+}
+
+// mockOIDCProvider is a minimal in-process OpenID Connect provider. Tests
+// change the claims it puts into ID tokens to simulate broken providers.
+type mockOIDCProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	claims jwt.MapClaims
+
+	mu    sync.Mutex
+	codes map[string]mockAuthorization
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &mockOIDCProvider{key: key, codes: map[string]mockAuthorization{}, claims: jwt.MapClaims{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "mock-key",
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", m.token)
+
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+
+	return m
+}
+
+// authorize plays the login page: it checks the request and returns a code.
+func (m *mockOIDCProvider) authorize(t *testing.T, authURL string) string {
+	t.Helper()
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+
+	if query.Get("client_id") != mockClientID || query.Get("redirect_uri") != mockRedirectURL || query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("Unexpected authorization request: %s", authURL)
+	}
+
+	code := "code-" + query.Get("state")[:8]
+	m.mu.Lock()
+	m.codes[code] = mockAuthorization{challenge: query.Get("code_challenge"), nonce: query.Get("nonce")}
+	m.mu.Unlock()
+
+	return code
+}
+
+func (m *mockOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	clientID, secret, ok := r.BasicAuth()
+	if !ok || clientID != mockClientID || secret != mockClientSecret {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+
+	m.mu.Lock()
+	authorization, ok := m.codes[r.FormValue("code")]
+	delete(m.codes, r.FormValue("code"))
+	m.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != authorization.challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss":            m.server.URL,
+		"sub":            "staff-42",
+		"aud":            mockClientID,
+		"email":          "jane@example.com",
+		"email_verified": true,
+		"nonce":          authorization.nonce,
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Minute).Unix(),
+	}
+	for name, value := range m.claims {
+		claims[name] = value
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "mock-key"
+	idToken, err := token.SignedString(m.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"access_token": "opaque", "token_type": "Bearer", "id_token": idToken})
+}
+
+func discoverMock(t *testing.T, m *mockOIDCProvider) *auth.OIDCProvider {
+	t.Helper()
+	provider, err := auth.DiscoverOIDCProvider(context.Background(), m.server.URL, mockClientID, mockClientSecret, mockRedirectURL, nil)
+	if err != nil {
+		t.Fatalf("DiscoverOIDCProvider returned error: %v", err)
+	}
+	return provider
+}
+
+func TestOIDCProvider_AuthorizationCodeFlow(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	provider := discoverMock(t, mock)
+
+	state, challenge, err := auth.NewOIDCLoginState()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code := mock.authorize(t, provider.AuthCodeURL(state.State, state.Nonce, challenge))
+
+	claims, err := provider.Exchange(context.Background(), code, state.CodeVerifier, state.Nonce)
+	if err != nil {
+		t.Fatalf("Exchange returned error: %v", err)
+	}
+
+	if claims.Issuer != mock.server.URL || claims.Subject != "staff-42" || claims.Email != "jane@example.com" || !claims.EmailVerified {
+		t.Errorf("Unexpected claims: %+v", claims)
+	}
+}
+
+func TestOIDCProvider_RejectsWrongVerifier(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	provider := discoverMock(t, mock)
+
+	state, challenge, _ := auth.NewOIDCLoginState()
+	code := mock.authorize(t, provider.AuthCodeURL(state.State, state.Nonce, challenge))
+
+	other, _, _ := auth.NewOIDCLoginState()
+	if _, err := provider.Exchange(context.Background(), code, other.CodeVerifier, state.Nonce); err == nil {
+		t.Error("Expected the exchange to fail with another code verifier")
+	}
+}
+
+func TestOIDCProvider_RejectsInvalidIDTokens(t *testing.T) {
+	tests := map[string]jwt.MapClaims{
+		"wrong audience": {"aud": "someone-else"},
+		"wrong issuer":   {"iss": "https://evil.example.com"},
+		"expired":        {"exp": time.Now().Add(-time.Minute).Unix()},
+		"wrong nonce":    {"nonce": "replayed"},
+		"foreign azp":    {"aud": []string{mockClientID, "other"}, "azp": "other"},
+	}
+
+	for name, claims := range tests {
+		t.Run(name, func(t *testing.T) {
+			mock := newMockOIDCProvider(t)
+			mock.claims = claims
+			provider := discoverMock(t, mock)
+
+			state, challenge, _ := auth.NewOIDCLoginState()
+			code := mock.authorize(t, provider.AuthCodeURL(state.State, state.Nonce, challenge))
+
+			if _, err := provider.Exchange(context.Background(), code, state.CodeVerifier, state.Nonce); err == nil {
+				t.Error("Expected the ID token to be rejected")
+			}
+		})
+	}
+}
+
+func TestDiscoverOIDCProvider_IssuerMismatch(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+
+	// the discovery document names the server URL without the trailing slash
+	if _, err := auth.DiscoverOIDCProvider(context.Background(), mock.server.URL+"/", mockClientID, "", mockRedirectURL, nil); err == nil {
+		t.Error("Expected discovery to fail when the issuer doesn't match")
+	}
+}
+
+func TestOIDCHandler_LoginAndStateCheck(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	keys, err := auth.NewEphemeralKeyManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := handler.NewUserHandler(nil, nil, nil, nil, keys, nil, nil, nil, nil)
+	oidcHandler := handler.NewOIDCHandler(discoverMock(t, mock), nil, users, false)
+
+	rec := httptest.NewRecorder()
+	oidcHandler.Login(rec, httptest.NewRequest(http.MethodGet, "/api/v1/login/oidc", nil))
+
+	if rec.Code != http.StatusFound || !strings.HasPrefix(rec.Header().Get("Location"), mock.server.URL+"/authorize?") {
+		t.Fatalf("Expected a redirect to the provider, got %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly {
+		t.Fatalf("Expected one HttpOnly state cookie, got %v", cookies)
+	}
+
+	// a callback with another state, e.g. from a forged link, is refused
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/login/oidc/callback?code=abc&state=forged", nil)
+	req.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+	oidcHandler.Callback(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+// completeOIDCFlow plays the browser: it logs in at the provider with the
+// URL and state cookie start answered with and calls the callback.
+func completeOIDCFlow(t *testing.T, mock *mockOIDCProvider, oidcHandler *handler.OIDCHandler, start *httptest.ResponseRecorder, authURL string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	code := mock.authorize(t, authURL)
+	u, _ := url.Parse(authURL)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/login/oidc/callback?code="+code+"&state="+u.Query().Get("state"), nil)
+	for _, cookie := range start.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	oidcHandler.Callback(rec, req)
+	return rec
+}
+
+func TestOIDCHandler_LinkAccountState(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	keys, err := auth.NewEphemeralKeyManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := handler.NewUserHandler(nil, nil, nil, nil, keys, nil, nil, nil, nil)
+	oidcHandler := handler.NewOIDCHandler(discoverMock(t, mock), nil, users, false)
+
+	rec := httptest.NewRecorder()
+	oidcHandler.LinkAccount(rec, asUser(httptest.NewRequest(http.MethodPost, "/api/v1/me/identities/oidc", nil), 42))
+
+	var response struct {
+		Data struct {
+			AuthorizationURL string `json:"authorizationUrl"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d with a URL, got %d %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if !strings.HasPrefix(response.Data.AuthorizationURL, mock.server.URL+"/authorize?") {
+		t.Errorf("Expected the provider URL, got %q", response.Data.AuthorizationURL)
+	}
+
+	// the callback links the account to the user who started it
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("Expected one state cookie, got %v", cookies)
+	}
+	state, err := auth.ParseOIDCLoginState(keys, cookies[0].Value)
+	if err != nil || state.LinkUserId != 42 {
+		t.Errorf("Expected the state to link user 42, got %+v (%v)", state, err)
+	}
+
+	// without a session there is nobody to link to
+	rec = httptest.NewRecorder()
+	oidcHandler.LinkAccount(rec, httptest.NewRequest(http.MethodPost, "/api/v1/me/identities/oidc", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d without a session, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"go-crud-database/models"
+	"go-crud-database/utils"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func setPasswordPolicy(t *testing.T, policy utils.PasswordPolicy) {
+	t.Helper()
+	if err := utils.SetPasswordPolicy(policy); err != nil {
+		t.Fatalf("Error setting password policy: %v", err)
+	}
+	t.Cleanup(func() { utils.SetPasswordPolicy(utils.DefaultPasswordPolicy) })
+}
+
+func TestValidatePassword_ReturnsEveryViolation(t *testing.T) {
+	setPasswordPolicy(t, utils.PasswordPolicy{
+		MinLength:        10,
+		MaxLength:        72,
+		RequireUpper:     true,
+		RequireDigit:     true,
+		RequireSymbol:    true,
+		DisallowUserInfo: true,
+	})
+
+	got := utils.ValidatePassword("alice", "alice", "alice@example.com")
+	want := []string{
+		"Password must be at least 10 characters",
+		"Password must contain an uppercase letter",
+		"Password must contain a digit",
+		"Password must contain a symbol",
+		"Password must not contain the username",
+		"Password must not contain the email",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ValidatePassword() = %v, want %v", got, want)
+	}
+
+	if violations := utils.ValidatePassword("Correct-Horse-42", "alice", "alice@example.com"); len(violations) != 0 {
+		t.Errorf("Expected no violations, got %v", violations)
+	}
+}
+
+func TestValidatePassword_MaxLengthInBytes(t *testing.T) {
+	// 25 three-byte characters are 75 bytes
+	password := strings.Repeat("€", 25)
+
+	violations := utils.ValidatePassword(password, "", "")
+	if len(violations) != 1 || violations[0] != "Password must be at most 72 bytes" {
+		t.Errorf("Unexpected violations: %v", violations)
+	}
+}
+
+func TestValidatePassword_Breached(t *testing.T) {
+	dir := t.TempDir()
+	// SHA-1 of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+	if err := os.WriteFile(filepath.Join(dir, "5BAA6.txt"), []byte("003D68EB55068C33ACE09247EE4C639306B:3\r\n1E4C9B93F3F0682250B6CF8331B7EE68FD8:9545824\r\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	policy := utils.DefaultPasswordPolicy
+	policy.BreachedDir = dir
+	setPasswordPolicy(t, policy)
+
+	violations := utils.ValidatePassword("password", "", "")
+	if len(violations) != 1 || !strings.Contains(violations[0], "data breach") {
+		t.Errorf("Expected the breached password to be rejected, got %v", violations)
+	}
+
+	if violations := utils.ValidatePassword("not-in-the-list", "", ""); len(violations) != 0 {
+		t.Errorf("Expected no violations, got %v", violations)
+	}
+}
+
+func TestValidateRegisterRequest_UsesPolicy(t *testing.T) {
+	policy := utils.DefaultPasswordPolicy
+	policy.MinLength = 12
+	policy.RequireDigit = true
+	setPasswordPolicy(t, policy)
+
+	msg, ok := utils.ValidateRegisterRequest(models.RegisterRequest{Username: "user123", Email: "user@example.com", Password: "password"})
+	if ok || msg != "Password must be at least 12 characters; Password must contain a digit" {
+		t.Errorf("Unexpected result: (%q, %v)", msg, ok)
+	}
+
+	// logins are not checked against the policy
+	if msg, ok := utils.ValidateLoginRequest(models.LoginRequest{Username: "user123", Password: "password"}); !ok {
+		t.Errorf("Expected the login request to be valid, got %q", msg)
+	}
+}
+
+func TestLoadPasswordPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(path, []byte(`{"minLength": 12, "requireSymbol": true}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	policy, err := utils.LoadPasswordPolicy(path)
+	if err != nil {
+		t.Fatalf("LoadPasswordPolicy returned error: %v", err)
+	}
+
+	want := utils.DefaultPasswordPolicy
+	want.MinLength = 12
+	want.RequireSymbol = true
+	if policy != want {
+		t.Errorf("LoadPasswordPolicy() = %+v, want %+v", policy, want)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"go-crud-database/handler"
+	"go-crud-database/mailer"
+	"go-crud-database/models"
+	"go-crud-database/repository"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakePasswordResetRepo struct {
+	created chan models.PasswordResetToken
+}
+
+func (f *fakePasswordResetRepo) Create(ctx context.Context, tx *sql.Tx, token *models.PasswordResetToken) error {
+	f.created <- *token
+	return nil
+}
+
+func (f *fakePasswordResetRepo) GetByHashForUpdate(ctx context.Context, tx *sql.Tx, tokenHash string) (models.PasswordResetToken, error) {
+	return models.PasswordResetToken{}, sql.ErrNoRows
+}
+
+func (f *fakePasswordResetRepo) MarkUsed(ctx context.Context, tx *sql.Tx, tokenId int) error {
+	return nil
+}
+
+func (f *fakePasswordResetRepo) InvalidateForUser(ctx context.Context, tx *sql.Tx, userId int) error {
+	return nil
+}
+
+// slowUserRepo holds email lookups until release is closed.
+type slowUserRepo struct {
+	repository.UserRepository
+	release chan struct{}
+}
+
+func (r *slowUserRepo) GetUserByEmail(ctx context.Context, tx *sql.Tx, email string) (models.User, error) {
+	<-r.release
+	return r.UserRepository.GetUserByEmail(ctx, tx, email)
+}
+
+func (r *slowUserRepo) GetUserByEmailForUpdate(ctx context.Context, tx *sql.Tx, email string) (models.User, error) {
+	<-r.release
+	return r.UserRepository.GetUserByEmailForUpdate(ctx, tx, email)
+}
+
+func TestForgotPassword_AnswersBeforeLookingUpTheEmail(t *testing.T) {
+	ctx := context.Background()
+	users, db := repository.NewMemoryUserRepository()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := users.Register(ctx, tx, &models.RegisterRequest{Username: "forgetful", Email: "forgetful@example.com", Password: "hash"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	slow := &slowUserRepo{UserRepository: users, release: make(chan struct{})}
+	resets := &fakePasswordResetRepo{created: make(chan models.PasswordResetToken, 2)}
+	capture := &captureMailer{sent: make(chan mailer.Message, 2)}
+	resetHandler := handler.NewPasswordResetHandler(slow, resets, nil, nil, capture, "http://localhost:3000/reset-password", db)
+
+	forgot := func(email string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/password/forgot", strings.NewReader(`{"email": "`+email+`"}`))
+		rec := httptest.NewRecorder()
+		resetHandler.ForgotPassword(rec, req)
+		return rec
+	}
+
+	// both answers are written while the lookups still wait, so they can't
+	// take different times
+	known := forgot("forgetful@example.com")
+	unknown := forgot("nobody@example.com")
+
+	if known.Code != http.StatusOK || known.Body.String() != unknown.Body.String() {
+		t.Fatalf("Expected the same answer for known and unknown emails, got %d %q and %d %q", known.Code, known.Body.String(), unknown.Code, unknown.Body.String())
+	}
+
+	close(slow.release)
+
+	select {
+	case msg := <-capture.sent:
+		if msg.To != "forgetful@example.com" || !strings.Contains(msg.Body, "http://localhost:3000/reset-password?token=") {
+			t.Errorf("Unexpected reset email %+v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("reset email was not sent")
+	}
+
+	select {
+	case token := <-resets.created:
+		if token.ExpiresAt.Before(time.Now()) {
+			t.Errorf("Expected a reset token that is still valid, got %+v", token)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("reset token was not stored")
+	}
+
+	select {
+	case msg := <-capture.sent:
+		t.Errorf("Unexpected email to %s", msg.To)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -2,8 +2,11 @@ package main
 
 import (
 	"go-crud-database/utils"
+	"strings"
 	"testing"
 	"time"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestHashPassword_NormalPassword(t *testing.T) {
@@ -55,3 +58,70 @@ func TestCheckPassword_WrongPassword(t *testing.T) {
 		t.Errorf("CheckPassword should return false for wrong password")
 	}
 }
+
+func TestHashPassword_Argon2idPHCFormat(t *testing.T) {
+	hashedPassword, err := utils.EncryptPassword("password")
+	if err != nil {
+		t.Fatalf("Error hashing password: %v", err)
+	}
+
+	if !strings.HasPrefix(hashedPassword, "$argon2id$v=19$m=19456,t=2,p=1$") {
+		t.Errorf("Unexpected hash format: %s", hashedPassword)
+	}
+
+	if !utils.CheckPassword(hashedPassword, "password") {
+		t.Errorf("CheckPassword should return true for the right password")
+	}
+
+	if utils.NeedsRehash(hashedPassword) {
+		t.Errorf("A hash with the current parameters should not need a rehash")
+	}
+}
+
+func TestCheckPassword_LegacyBcrypt(t *testing.T) {
+	legacyHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Error hashing password: %v", err)
+	}
+
+	if !utils.CheckPassword(string(legacyHash), "password") {
+		t.Errorf("CheckPassword should accept bcrypt hashes")
+	}
+
+	if utils.CheckPassword(string(legacyHash), "wrongPassword") {
+		t.Errorf("CheckPassword should return false for wrong password")
+	}
+
+	if !utils.NeedsRehash(string(legacyHash)) {
+		t.Errorf("bcrypt hashes should need a rehash")
+	}
+}
+
+func TestNeedsRehash_ChangedParams(t *testing.T) {
+	hashedPassword, _ := utils.EncryptPassword("password")
+
+	params := utils.DefaultArgon2Params
+	params.Iterations = 3
+	if err := utils.SetArgon2Params(params); err != nil {
+		t.Fatalf("Error setting params: %v", err)
+	}
+	defer utils.SetArgon2Params(utils.DefaultArgon2Params)
+
+	if !utils.NeedsRehash(hashedPassword) {
+		t.Errorf("A hash with old parameters should need a rehash")
+	}
+
+	// old hashes keep working
+	if !utils.CheckPassword(hashedPassword, "password") {
+		t.Errorf("CheckPassword should accept hashes with old parameters")
+	}
+}
+
+func TestSetArgon2Params_Invalid(t *testing.T) {
+	params := utils.DefaultArgon2Params
+	params.Iterations = 0
+
+	if err := utils.SetArgon2Params(params); err != utils.ErrInvalidArgon2Params {
+		t.Errorf("Expected ErrInvalidArgon2Params, got %v", err)
+	}
+}
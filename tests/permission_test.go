@@ -0,0 +1,40 @@
+package main
+
+import (
+	"go-crud-database/middleware"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequirePermission(t *testing.T) {
+	testCases := []struct {
+		name        string
+		permissions []string
+		wantCode    int
+	}{
+		{name: "Has permission", permissions: []string{"users:read", "users:delete"}, wantCode: http.StatusOK},
+		{name: "Missing permission", permissions: []string{"users:read"}, wantCode: http.StatusForbidden},
+		{name: "No permissions", permissions: nil, wantCode: http.StatusForbidden},
+	}
+
+	handler := middleware.RequirePermission("users:delete")(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodDelete, "/api/v1/users/1", nil)
+			if test.permissions != nil {
+				req = asUser(req, 1, test.permissions...)
+			}
+
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			if rec.Code != test.wantCode {
+				t.Errorf("Expected status %d, got %d", test.wantCode, rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"go-crud-database/auth"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// withPrincipal returns a copy of req authenticated as p, the way the token
+// middleware leaves it for the handlers.
+func withPrincipal(req *http.Request, p *auth.Principal) *http.Request {
+	return req.WithContext(auth.WithPrincipal(req.Context(), p))
+}
+
+// asUser authenticates req with an access token of userId that grants scopes.
+func asUser(req *http.Request, userId int, scopes ...string) *http.Request {
+	return withPrincipal(req, &auth.Principal{
+		UserId:         userId,
+		Roles:          []string{auth.RoleMember},
+		Scopes:         scopes,
+		TokenId:        "test-token",
+		TokenExpiresAt: time.Now().Add(time.Minute),
+		Method:         auth.AuthMethodToken,
+	})
+}
+
+func TestFromContext(t *testing.T) {
+	if _, ok := auth.FromContext(context.Background()); ok {
+		t.Error("Expected no principal in an empty context")
+	}
+
+	// string keys used before the principal existed are not picked up
+	ctx := context.WithValue(context.Background(), "userId", 1)
+	if _, ok := auth.FromContext(ctx); ok {
+		t.Error("Expected a string context key to be ignored")
+	}
+
+	req := asUser(httptest.NewRequest(http.MethodGet, "/api/v1/me", nil), 7, auth.PermissionUsersRead)
+
+	principal, ok := auth.FromContext(req.Context())
+	if !ok {
+		t.Fatal("Expected a principal")
+	}
+	if principal.UserId != 7 || principal.Method != auth.AuthMethodToken {
+		t.Errorf("Expected user 7 authenticated by token, got user %d by %s", principal.UserId, principal.Method)
+	}
+	if !principal.HasScope(auth.PermissionUsersRead) || principal.HasScope(auth.PermissionUsersDelete) {
+		t.Errorf("Expected only scope %s, got %v", auth.PermissionUsersRead, principal.Scopes)
+	}
+	if principal.IsImpersonated() {
+		t.Error("Expected the user's own session")
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"go-crud-database/middleware"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_CountsPerIPNotPerConnection(t *testing.T) {
+	limited := middleware.NewRateLimiter(2, 1, time.Minute).Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	send := func(remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		limited.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// every request comes from a new connection
+	for port := 50001; port <= 50003; port++ {
+		if status := send("10.0.0.1:" + strconv.Itoa(port)); status != http.StatusOK {
+			t.Fatalf("Expected request %d to pass, got %d", port-50000, status)
+		}
+	}
+	if status := send("10.0.0.1:50004"); status != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d on a new connection, got %d", http.StatusTooManyRequests, status)
+	}
+
+	// other clients have their own limit
+	if status := send("10.0.0.2:50001"); status != http.StatusOK {
+		t.Errorf("Expected another IP to pass, got %d", status)
+	}
+}
+
+func TestRateLimiter_SlowRequestDoesNotBlockOthers(t *testing.T) {
+	release := make(chan struct{})
+	limited := middleware.NewRateLimiter(10, 0, time.Minute).Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+	}))
+	defer close(release)
+
+	go limited.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+
+	// let the slow request reach the handler
+	time.Sleep(50 * time.Millisecond)
+
+	done := make(chan int, 1)
+	go func() {
+		rec := httptest.NewRecorder()
+		limited.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fast", nil))
+		done <- rec.Code
+	}()
+
+	select {
+	case status := <-done:
+		if status != http.StatusOK {
+			t.Errorf("Expected status %d, got %d", http.StatusOK, status)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("request waited for the slow request to finish")
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"go-crud-database/auth"
+	"go-crud-database/handler"
+	"go-crud-database/models"
+	"go-crud-database/repository"
+	"go-crud-database/utils"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// fakeRefreshTokenRepo keeps refresh tokens by hash. It ignores tx, the
+// handler tests only commit.
+type fakeRefreshTokenRepo struct {
+	tokens map[string]*models.RefreshToken
+	nextId int
+}
+
+func newFakeRefreshTokenRepo() *fakeRefreshTokenRepo {
+	return &fakeRefreshTokenRepo{tokens: map[string]*models.RefreshToken{}, nextId: 1}
+}
+
+func (f *fakeRefreshTokenRepo) Create(ctx context.Context, tx *sql.Tx, token *models.RefreshToken) error {
+	token.TokenId = f.nextId
+	token.CreatedAt = time.Now()
+	f.nextId++
+
+	stored := *token
+	f.tokens[token.TokenHash] = &stored
+	return nil
+}
+
+func (f *fakeRefreshTokenRepo) GetByHashForUpdate(ctx context.Context, tx *sql.Tx, tokenHash string) (models.RefreshToken, error) {
+	token, ok := f.tokens[tokenHash]
+	if !ok {
+		return models.RefreshToken{}, sql.ErrNoRows
+	}
+	return *token, nil
+}
+
+func (f *fakeRefreshTokenRepo) MarkUsed(ctx context.Context, tx *sql.Tx, tokenId int) error {
+	for _, token := range f.tokens {
+		if token.TokenId == tokenId {
+			token.UsedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		}
+	}
+	return nil
+}
+
+func (f *fakeRefreshTokenRepo) RevokeFamily(ctx context.Context, tx *sql.Tx, familyId string) error {
+	for _, token := range f.tokens {
+		if token.FamilyId == familyId && !token.RevokedAt.Valid {
+			token.RevokedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		}
+	}
+	return nil
+}
+
+func (f *fakeRefreshTokenRepo) RevokeAllForUser(ctx context.Context, tx *sql.Tx, userId int) error {
+	for _, token := range f.tokens {
+		if token.UserId == userId && !token.RevokedAt.Valid {
+			token.RevokedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		}
+	}
+	return nil
+}
+
+// add stores a refresh token of userId and returns the plain token.
+func (f *fakeRefreshTokenRepo) add(t *testing.T, userId int, familyId string, expiresAt time.Time) string {
+	t.Helper()
+
+	plain, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Create(context.Background(), nil, &models.RefreshToken{UserId: userId, FamilyId: familyId, TokenHash: utils.HashToken(plain), ExpiresAt: expiresAt})
+	return plain
+}
+
+func (f *fakeRefreshTokenRepo) get(plain string) *models.RefreshToken {
+	return f.tokens[utils.HashToken(plain)]
+}
+
+// newRefreshTestHandler returns a handler with one user in an in-memory
+// repository.
+func newRefreshTestHandler(t *testing.T) (*handler.UserHandler, *fakeRefreshTokenRepo, int) {
+	t.Helper()
+	ctx := context.Background()
+
+	users, db := repository.NewMemoryUserRepository()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := users.Register(ctx, tx, &models.RegisterRequest{Username: "refresher", Email: "refresher@example.com", Password: "hash"}); err != nil {
+		t.Fatal(err)
+	}
+	user, err := users.GetUserByUsername(ctx, tx, "refresher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := auth.NewEphemeralKeyManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refreshTokens := newFakeRefreshTokenRepo()
+	return handler.NewUserHandler(users, refreshTokens, fakeRoleRepo{}, nil, keys, nil, nil, nil, db), refreshTokens, user.UserId
+}
+
+func refresh(t *testing.T, userHandler *handler.UserHandler, refreshToken string) (int, models.TokenResponse) {
+	t.Helper()
+
+	var tokens models.TokenResponse
+	status := postJSON(t, userHandler.RefreshToken, "/api/v1/token/refresh", "10.0.0.1:51234", `{"refreshToken": "`+refreshToken+`"}`, &tokens)
+	return status, tokens
+}
+
+func TestRefreshToken_RotatesOnUse(t *testing.T) {
+	userHandler, refreshTokens, userId := newRefreshTestHandler(t)
+	first := refreshTokens.add(t, userId, "family-1", time.Now().Add(time.Hour))
+
+	status, tokens := refresh(t, userHandler, first)
+	if status != http.StatusOK || tokens.AccessToken == "" {
+		t.Fatalf("Expected new tokens, got status %d", status)
+	}
+	if tokens.RefreshToken == "" || tokens.RefreshToken == first {
+		t.Fatalf("Expected a new refresh token, got %q", tokens.RefreshToken)
+	}
+
+	if !refreshTokens.get(first).UsedAt.Valid {
+		t.Error("Expected the used refresh token to be marked as used")
+	}
+	second := refreshTokens.get(tokens.RefreshToken)
+	if second == nil || second.FamilyId != "family-1" || second.UserId != userId {
+		t.Fatalf("Expected the new refresh token in the same family, got %+v", second)
+	}
+
+	// the new token rotates again
+	if status, _ := refresh(t, userHandler, tokens.RefreshToken); status != http.StatusOK {
+		t.Errorf("Expected the rotated token to work, got status %d", status)
+	}
+}
+
+func TestRefreshToken_ReplayRevokesFamily(t *testing.T) {
+	userHandler, refreshTokens, userId := newRefreshTestHandler(t)
+	first := refreshTokens.add(t, userId, "family-1", time.Now().Add(time.Hour))
+	other := refreshTokens.add(t, userId, "family-2", time.Now().Add(time.Hour))
+
+	_, tokens := refresh(t, userHandler, first)
+
+	// the used token shows up again, someone copied it
+	if status, _ := refresh(t, userHandler, first); status != http.StatusUnauthorized {
+		t.Fatalf("Expected status %d for a replayed token, got %d", http.StatusUnauthorized, status)
+	}
+
+	if !refreshTokens.get(tokens.RefreshToken).RevokedAt.Valid {
+		t.Error("Expected the replay to revoke the rest of the family")
+	}
+	if status, _ := refresh(t, userHandler, tokens.RefreshToken); status != http.StatusUnauthorized {
+		t.Errorf("Expected status %d for a token of the revoked family, got %d", http.StatusUnauthorized, status)
+	}
+
+	// other sessions of the user stay
+	if status, _ := refresh(t, userHandler, other); status != http.StatusOK {
+		t.Errorf("Expected another family to keep working, got status %d", status)
+	}
+}
+
+func TestRefreshToken_RejectsExpiredRevokedAndUnknown(t *testing.T) {
+	userHandler, refreshTokens, userId := newRefreshTestHandler(t)
+
+	expired := refreshTokens.add(t, userId, "family-1", time.Now().Add(-time.Minute))
+	if status, _ := refresh(t, userHandler, expired); status != http.StatusUnauthorized {
+		t.Errorf("Expected status %d for an expired token, got %d", http.StatusUnauthorized, status)
+	}
+	if refreshTokens.get(expired).UsedAt.Valid {
+		t.Error("Expected an expired token not to be rotated")
+	}
+
+	revoked := refreshTokens.add(t, userId, "family-2", time.Now().Add(time.Hour))
+	refreshTokens.RevokeFamily(context.Background(), nil, "family-2")
+	if status, _ := refresh(t, userHandler, revoked); status != http.StatusUnauthorized {
+		t.Errorf("Expected status %d for a revoked token, got %d", http.StatusUnauthorized, status)
+	}
+
+	if status, _ := refresh(t, userHandler, "not-a-refresh-token"); status != http.StatusUnauthorized {
+		t.Errorf("Expected status %d for an unknown token, got %d", http.StatusUnauthorized, status)
+	}
+
+	if status, _ := refresh(t, userHandler, " "); status != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an empty token, got %d", http.StatusBadRequest, status)
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"go-crud-database/handler"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegister_RejectsAdminField(t *testing.T) {
+	// the request is rejected before the handler touches the database
+	userHandler := handler.NewUserHandler(nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+	body := `{"username": "mallory", "email": "mallory@example.com", "password": "password", "isAdmin": true}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/register", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+
+	userHandler.Register(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, rec.Code)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"go-crud-database/auth"
+	"go-crud-database/middleware"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+type fakeRevocationRepo struct {
+	tokens  map[string]bool
+	users   map[int]time.Time
+	lookups int
+}
+
+func newFakeRevocationRepo() *fakeRevocationRepo {
+	return &fakeRevocationRepo{tokens: map[string]bool{}, users: map[int]time.Time{}}
+}
+
+func (f *fakeRevocationRepo) RevokeToken(ctx context.Context, tokenId string, userId int, expiresAt time.Time) error {
+	f.tokens[tokenId] = true
+	return nil
+}
+
+func (f *fakeRevocationRepo) IsTokenRevoked(ctx context.Context, tokenId string) (bool, error) {
+	f.lookups++
+	return f.tokens[tokenId], nil
+}
+
+func (f *fakeRevocationRepo) RevokeUserTokens(ctx context.Context, userId int, revokedAt time.Time) error {
+	f.users[userId] = revokedAt
+	return nil
+}
+
+func (f *fakeRevocationRepo) GetUserRevokedAt(ctx context.Context, userId int) (time.Time, error) {
+	revokedAt, ok := f.users[userId]
+	if !ok {
+		return time.Time{}, sql.ErrNoRows
+	}
+	return revokedAt, nil
+}
+
+func TestRevocationStore_RevokeToken(t *testing.T) {
+	ctx := context.Background()
+	store := auth.NewRevocationStore(newFakeRevocationRepo())
+	now := time.Now()
+	exp := now.Add(5 * time.Minute)
+
+	revoked, err := store.IsRevoked(ctx, "token-1", 1, now, exp)
+	if err != nil || revoked {
+		t.Fatalf("IsRevoked() = (%v, %v), want (false, nil)", revoked, err)
+	}
+
+	if err := store.RevokeToken(ctx, "token-1", 1, exp); err != nil {
+		t.Fatalf("RevokeToken() error: %v", err)
+	}
+
+	// the negative answer above is cached, revoking must still be visible at once
+	revoked, err = store.IsRevoked(ctx, "token-1", 1, now, exp)
+	if err != nil || !revoked {
+		t.Errorf("IsRevoked() = (%v, %v), want (true, nil)", revoked, err)
+	}
+
+	revoked, _ = store.IsRevoked(ctx, "token-2", 1, now, exp)
+	if revoked {
+		t.Errorf("IsRevoked() for another token = true, want false")
+	}
+}
+
+func TestRevocationStore_RevokeUser(t *testing.T) {
+	ctx := context.Background()
+	store := auth.NewRevocationStore(newFakeRevocationRepo())
+	issuedAt := time.Now().Add(-time.Minute)
+	exp := issuedAt.Add(5 * time.Minute)
+
+	if err := store.RevokeUser(ctx, 7); err != nil {
+		t.Fatalf("RevokeUser() error: %v", err)
+	}
+
+	revoked, _ := store.IsRevoked(ctx, "old-token", 7, issuedAt, exp)
+	if !revoked {
+		t.Errorf("token issued before RevokeUser should be revoked")
+	}
+
+	revoked, _ = store.IsRevoked(ctx, "new-token", 7, time.Now().Add(2*time.Second), exp)
+	if revoked {
+		t.Errorf("token issued after RevokeUser should not be revoked")
+	}
+
+	revoked, _ = store.IsRevoked(ctx, "other-user", 8, issuedAt, exp)
+	if revoked {
+		t.Errorf("token of another user should not be revoked")
+	}
+}
+
+func TestValidateToken_LoginRightAfterRevocation(t *testing.T) {
+	keys, err := auth.NewEphemeralKeyManager()
+	if err != nil {
+		t.Fatalf("NewEphemeralKeyManager() error: %v", err)
+	}
+	repo := newFakeRevocationRepo()
+	revocations := auth.NewRevocationStore(repo)
+	validator := middleware.NewTokenValidator(keys, revocations, nil)
+
+	if err := revocations.RevokeUser(context.Background(), 7); err != nil {
+		t.Fatalf("RevokeUser() error: %v", err)
+	}
+	revokedAt := repo.users[7]
+	time.Sleep(10 * time.Microsecond)
+
+	validate := func(iat interface{}) int {
+		token, err := keys.Sign(jwt.MapClaims{
+			"userId":      7,
+			"isAdmin":     false,
+			"roles":       []string{auth.RoleMember},
+			"permissions": []string{},
+			"jti":         "after-revocation",
+			"iat":         iat,
+			"exp":         time.Now().Add(time.Minute).Unix(),
+		})
+		if err != nil {
+			t.Fatalf("Sign() error: %v", err)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		validator.ValidateToken(func(w http.ResponseWriter, r *http.Request) {})(rec, req)
+		return rec.Code
+	}
+
+	// a new login in the same second as the revocation, e.g. right after
+	// changing the password, keeps its token
+	if code := validate(float64(time.Now().UnixMicro()) / 1e6); code != http.StatusOK {
+		t.Errorf("Expected status %d for a token issued after the revocation, got %d", http.StatusOK, code)
+	}
+
+	// a whole second can't tell, the token counts as issued before
+	if code := validate(revokedAt.Unix()); code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d for a token of the revocation second, got %d", http.StatusUnauthorized, code)
+	}
+}
+
+func TestRevocationStore_CachesLookups(t *testing.T) {
+	ctx := context.Background()
+	repo := newFakeRevocationRepo()
+	store := auth.NewRevocationStore(repo)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		store.IsRevoked(ctx, "token-1", 1, now, now.Add(time.Minute))
+	}
+
+	if repo.lookups != 1 {
+		t.Errorf("expected 1 repository lookup, got %d", repo.lookups)
+	}
+}
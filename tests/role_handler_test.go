@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"go-crud-database/auth"
+	"go-crud-database/handler"
+	"go-crud-database/models"
+	"go-crud-database/repository"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// captureAuditRepo keeps every recorded entry. It ignores tx.
+type captureAuditRepo struct {
+	entries []models.AuditLog
+}
+
+func (c *captureAuditRepo) Record(ctx context.Context, tx *sql.Tx, entry *models.AuditLog) error {
+	c.entries = append(c.entries, *entry)
+	return nil
+}
+
+func TestChangeRole_IsAudited(t *testing.T) {
+	ctx := context.Background()
+	users, db := repository.NewMemoryUserRepository()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := users.Register(ctx, tx, &models.RegisterRequest{Username: "promoted", Email: "promoted@example.com", Password: "hash"}); err != nil {
+		t.Fatal(err)
+	}
+	user, err := users.GetUserByUsername(ctx, tx, "promoted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	audit := &captureAuditRepo{}
+	roleHandler := handler.NewRoleHandler(fakeRoleRepo{}, users, audit, auth.NewRevocationStore(newFakeRevocationRepo()), db)
+
+	body := `{"userId": ` + strconv.Itoa(user.UserId) + `, "role": "support"}`
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/users/roles", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	roleHandler.AssignRole(rec, asUser(req, 1, auth.PermissionRolesManage))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/api/v1/users/roles", strings.NewReader(body))
+	rec = httptest.NewRecorder()
+	roleHandler.RemoveRole(rec, asUser(req, 1, auth.PermissionRolesManage))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	if len(audit.entries) != 2 {
+		t.Fatalf("Expected 2 audit entries, got %+v", audit.entries)
+	}
+	for i, action := range []string{"role.assign", "role.remove"} {
+		entry := audit.entries[i]
+		if entry.Action != action || entry.ActorUserId != 1 || entry.TargetUserId != user.UserId || entry.Details != "role=support" {
+			t.Errorf("Unexpected audit entry %+v, want %s of user %d by user 1", entry, action, user.UserId)
+		}
+	}
+}
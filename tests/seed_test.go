@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"go-crud-database/config"
+	"go-crud-database/models"
+	"go-crud-database/seed"
+	"go-crud-database/utils"
+	"reflect"
+	"testing"
+)
+
+func registerRequest(user seed.User) models.RegisterRequest {
+	return models.RegisterRequest{Username: user.Username, Email: user.Email, Password: user.Password}
+}
+
+func TestGenerate_Deterministic(t *testing.T) {
+	first := seed.Generate(42, 25)
+	second := seed.Generate(42, 25)
+
+	if len(first) != 25 {
+		t.Fatalf("Expected 25 users, got %d", len(first))
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Error("Expected the same users for the same seed")
+	}
+	if reflect.DeepEqual(first, seed.Generate(43, 25)) {
+		t.Error("Expected other users for another seed")
+	}
+}
+
+func TestGenerate_UniqueUsersWithValidPasswords(t *testing.T) {
+	strict := utils.DefaultPasswordPolicy
+	strict.MinLength = 10
+	strict.RequireUpper = true
+	strict.RequireLower = true
+	strict.RequireDigit = true
+	strict.RequireSymbol = true
+	setPasswordPolicy(t, strict)
+
+	usernames := map[string]bool{}
+	emails := map[string]bool{}
+	for _, user := range seed.Generate(7, 500) {
+		if usernames[user.Username] || emails[user.Email] {
+			t.Fatalf("Duplicate user %s <%s>", user.Username, user.Email)
+		}
+		usernames[user.Username], emails[user.Email] = true, true
+
+		if len(user.Username) > 50 || len(user.Email) > 100 {
+			t.Errorf("User %s doesn't fit the users table", user.Username)
+		}
+		if msg, ok := utils.ValidateRegisterRequest(registerRequest(user)); !ok {
+			t.Errorf("Generated user %s is invalid: %s", user.Username, msg)
+		}
+		if user.IsAdmin {
+			t.Errorf("Expected no generated admins, got %s", user.Username)
+		}
+	}
+}
+
+func TestFixtures(t *testing.T) {
+	names := seed.FixtureNames()
+	if len(names) == 0 {
+		t.Fatal("Expected fixture sets")
+	}
+
+	for _, name := range names {
+		users, err := seed.Fixture(name)
+		if err != nil {
+			t.Fatalf("Failed to get fixture %s: %v", name, err)
+		}
+		for _, user := range users {
+			if msg, ok := utils.ValidateRegisterRequest(registerRequest(user)); !ok {
+				t.Errorf("Fixture %s: user %s is invalid: %s", name, user.Username, msg)
+			}
+		}
+	}
+
+	// changing a returned set doesn't change the fixture
+	users, _ := seed.Fixture("roles")
+	users[1].Roles[0] = "changed"
+	again, _ := seed.Fixture("roles")
+	if again[1].Roles[0] == "changed" {
+		t.Error("Expected Fixture to return a copy")
+	}
+
+	if _, err := seed.Fixture("missing"); !errors.Is(err, seed.ErrUnknownFixture) {
+		t.Errorf("Expected ErrUnknownFixture, got %v", err)
+	}
+}
+
+func TestSeeder_RefusesProduction(t *testing.T) {
+	for _, env := range []string{config.EnvProd, "staging"} {
+		// the environment is checked before the database is touched
+		seeder := seed.NewSeeder(nil, nil, nil, env)
+
+		if _, _, err := seeder.Seed(context.Background(), seed.Generate(1, 1)); !errors.Is(err, seed.ErrNotAllowed) {
+			t.Errorf("APP_ENV %q: expected ErrNotAllowed, got %v", env, err)
+		}
+	}
+}
+
+func TestSeeder_RefusesUnsetAppEnv(t *testing.T) {
+	unsetEnv(t, "APP_ENV")
+
+	// without APP_ENV the configuration has no environment, it must not fall
+	// back to dev
+	seeder := seed.NewSeeder(nil, nil, nil, config.Default().Env)
+
+	if _, _, err := seeder.Seed(context.Background(), seed.Generate(1, 1)); !errors.Is(err, seed.ErrNotAllowed) {
+		t.Errorf("Expected ErrNotAllowed without APP_ENV, got %v", err)
+	}
+}
+
+func TestFastArgon2Params(t *testing.T) {
+	if err := utils.SetArgon2Params(utils.FastArgon2Params); err != nil {
+		t.Fatalf("Expected valid parameters, got %v", err)
+	}
+	t.Cleanup(func() { utils.SetArgon2Params(utils.DefaultArgon2Params) })
+
+	hash, err := utils.EncryptPassword("Granite-harbor-17")
+	if err != nil {
+		t.Fatalf("Error hashing password: %v", err)
+	}
+	if !utils.CheckPassword(hash, "Granite-harbor-17") {
+		t.Error("Expected the fast hash to verify")
+	}
+
+	utils.SetArgon2Params(utils.DefaultArgon2Params)
+	if !utils.NeedsRehash(hash) {
+		t.Error("Expected the fast hash to be upgraded on the next login")
+	}
+}
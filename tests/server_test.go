@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"go-crud-database/auth"
+	"go-crud-database/handler"
+	"go-crud-database/middleware"
+	"go-crud-database/server"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// newTestServer wires the routes to handlers without a database. Only
+// requests that are answered before a handler touches the database work.
+func newTestServer(t *testing.T) (http.Handler, *auth.KeyManager) {
+	t.Helper()
+
+	keys, err := auth.NewEphemeralKeyManager()
+	if err != nil {
+		t.Fatalf("NewEphemeralKeyManager() error: %v", err)
+	}
+
+	userHandler := handler.NewUserHandler(nil, nil, nil, nil, keys, nil, nil, nil, nil)
+
+	return server.NewServer(server.Deps{
+		Users:         userHandler,
+		Roles:         handler.NewRoleHandler(nil, nil, nil, nil, nil),
+		APIKeys:       handler.NewAPIKeyHandler(nil),
+		PasswordReset: handler.NewPasswordResetHandler(nil, nil, nil, nil, nil, "", nil),
+		MagicLinks:    handler.NewMagicLinkHandler(nil, userHandler, nil, ""),
+		Lockout:       handler.NewLockoutHandler(nil, nil, nil, nil),
+		Impersonation: handler.NewImpersonationHandler(userHandler, nil),
+		JWKS:          handler.NewJWKSHandler(keys),
+		Tokens:        middleware.NewTokenValidator(keys, auth.NewRevocationStore(newFakeRevocationRepo()), nil),
+		RateLimiter:   middleware.NewRateLimiter(10, 5, time.Minute),
+	}), keys
+}
+
+func signTestAccessToken(t *testing.T, keys *auth.KeyManager, userId int, permissions ...string) string {
+	t.Helper()
+
+	now := time.Now()
+	token, err := keys.Sign(jwt.MapClaims{
+		"userId":      userId,
+		"isAdmin":     false,
+		"roles":       []string{auth.RoleMember},
+		"permissions": permissions,
+		"jti":         "access-1",
+		"iat":         now.Unix(),
+		"exp":         now.Add(time.Minute).Unix(),
+	})
+	if err != nil {
+		t.Fatalf("Sign() error: %v", err)
+	}
+	return token
+}
+
+func TestServer_MethodNotAllowed(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	testCases := []struct {
+		method string
+		path   string
+		allow  string
+	}{
+		{http.MethodGet, "/api/v1/login", "POST"},
+		{http.MethodPut, "/api/v1/me", "GET, PATCH, DELETE"},
+		{http.MethodPost, "/api/v1/users/5", "GET, PUT, DELETE"},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.method+" "+test.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, httptest.NewRequest(test.method, test.path, nil))
+
+			if rec.Code != http.StatusMethodNotAllowed {
+				t.Fatalf("Expected status %d, got %d", http.StatusMethodNotAllowed, rec.Code)
+			}
+			if allow := rec.Header().Get("Allow"); allow != test.allow {
+				t.Errorf("Expected Allow %q, got %q", test.allow, allow)
+			}
+		})
+	}
+}
+
+func TestServer_NotFound(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/unknown", nil))
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, rec.Code)
+	}
+	if contentType := rec.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "application/json") {
+		t.Errorf("Expected a JSON answer, got %q", contentType)
+	}
+}
+
+func TestServer_RequiresToken(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	for _, path := range []string{"/api/v1/me", "/api/v1/users", "/api/v1/users/5"} {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("GET %s: expected status %d, got %d", path, http.StatusUnauthorized, rec.Code)
+		}
+	}
+}
+
+func TestServer_PathParameterAndPermission(t *testing.T) {
+	srv, keys := newTestServer(t)
+	token := signTestAccessToken(t, keys, 1)
+
+	// a member can't read the details of someone else
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users/2", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, rec.Code)
+	}
+
+	// nor delete anyone
+	req = httptest.NewRequest(http.MethodDelete, "/api/v1/users/2", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, rec.Code)
+	}
+}
+
+func TestServer_PublicRoutesAreRateLimited(t *testing.T) {
+	testCases := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodPost, "/api/v1/login"},
+		{http.MethodPost, "/api/v1/register"},
+		{http.MethodPost, "/api/v1/token/refresh"},
+		{http.MethodGet, "/api/v1/verify-email"},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.path, func(t *testing.T) {
+			srv, _ := newTestServer(t)
+
+			// 10 requests plus a burst of 5 are let through, the request is
+			// rejected before the database is used
+			var rec *httptest.ResponseRecorder
+			for i := 0; i < 16; i++ {
+				rec = httptest.NewRecorder()
+				srv.ServeHTTP(rec, httptest.NewRequest(test.method, test.path, strings.NewReader("not json")))
+			}
+
+			if rec.Code != http.StatusTooManyRequests {
+				t.Errorf("Expected status %d, got %d", http.StatusTooManyRequests, rec.Code)
+			}
+		})
+	}
+}
+
+// startRun serves handler on a free local port with server.Run.
+func startRun(t *testing.T, handler http.Handler, shutdownTimeout time.Duration) (url string, cancel context.CancelFunc, done chan error) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	cfg := server.DefaultConfig
+	cfg.Addr = addr
+	srv := server.NewHTTPServer(cfg, handler)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done = make(chan error, 1)
+	go func() {
+		done <- server.Run(ctx, srv, shutdownTimeout)
+	}()
+
+	// wait until the server accepts connections
+	for i := 0; i < 100; i++ {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return "http://" + addr, cancel, done
+}
+
+func TestRun_DrainsRequestsInFlight(t *testing.T) {
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	url, cancel, done := startRun(t, handler, 5*time.Second)
+
+	result := make(chan int, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			result <- 0
+			return
+		}
+		resp.Body.Close()
+		result <- resp.StatusCode
+	}()
+
+	<-started
+	cancel()
+
+	if code := <-result; code != http.StatusOK {
+		t.Errorf("Expected the request in flight to finish with %d, got %d", http.StatusOK, code)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Run() error: %v", err)
+	}
+}
+
+func TestRun_ShutdownTimeout(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+
+	url, cancel, done := startRun(t, handler, 50*time.Millisecond)
+
+	go func() {
+		if resp, err := http.Get(url); err == nil {
+			resp.Body.Close()
+		}
+	}()
+
+	<-started
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() didn't give up after the shutdown timeout")
+	}
+}
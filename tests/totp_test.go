@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"go-crud-database/auth"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B, SHA-1, truncated to six digits
+var rfc6238Secret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCode_RFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		code, err := auth.TOTPCode(rfc6238Secret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("TOTPCode returned error: %v", err)
+		}
+		if code != tt.code {
+			t.Errorf("TOTPCode at %d = %s, want %s", tt.unix, code, tt.code)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+
+	step, ok := auth.ValidateTOTP(rfc6238Secret, "050471", now)
+	if !ok {
+		t.Fatal("Expected the current code to be valid")
+	}
+	if step != 1111111111/30 {
+		t.Errorf("Expected step %d, got %d", 1111111111/30, step)
+	}
+
+	// one step of clock drift is tolerated
+	if _, ok := auth.ValidateTOTP(rfc6238Secret, "050471", now.Add(30*time.Second)); !ok {
+		t.Error("Expected the code of the previous step to be valid")
+	}
+
+	if _, ok := auth.ValidateTOTP(rfc6238Secret, "050471", now.Add(5*time.Minute)); ok {
+		t.Error("Expected an old code to be rejected")
+	}
+
+	if _, ok := auth.ValidateTOTP(rfc6238Secret, "000000", now); ok {
+		t.Error("Expected a wrong code to be rejected")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret returned error: %v", err)
+	}
+
+	uri := auth.TOTPURI("go-crud-database", "alice", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/") {
+		t.Errorf("Unexpected URI: %s", uri)
+	}
+	if !strings.Contains(uri, "secret="+secret) || !strings.Contains(uri, "issuer=go-crud-database") {
+		t.Errorf("URI is missing secret or issuer: %s", uri)
+	}
+}
+
+func TestSecretBox(t *testing.T) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+
+	box, err := auth.NewSecretBox(key)
+	if err != nil {
+		t.Fatalf("NewSecretBox returned error: %v", err)
+	}
+
+	ciphertext, err := box.Encrypt("JBSWY3DPEHPK3PXP")
+	if err != nil {
+		t.Fatalf("Encrypt returned error: %v", err)
+	}
+	if strings.Contains(ciphertext, "JBSWY3DPEHPK3PXP") {
+		t.Fatal("Ciphertext contains the plaintext")
+	}
+
+	plaintext, err := box.Decrypt(ciphertext)
+	if err != nil {
+		t.Fatalf("Decrypt returned error: %v", err)
+	}
+	if plaintext != "JBSWY3DPEHPK3PXP" {
+		t.Errorf("Expected round trip, got %s", plaintext)
+	}
+
+	// flip a bit of the sealed bytes, flipping one in the base64 text may only
+	// touch padding bits that decode to the same bytes
+	tampered, _ := base64.StdEncoding.DecodeString(ciphertext)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := box.Decrypt(base64.StdEncoding.EncodeToString(tampered)); err == nil {
+		t.Error("Expected tampered ciphertext to be rejected")
+	}
+
+	if _, err := auth.NewSecretBox(key[:16]); err == nil {
+		t.Error("Expected a short key to be rejected")
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"go-crud-database/auth"
+	"go-crud-database/handler"
+	"go-crud-database/mailer"
+	"go-crud-database/models"
+	"go-crud-database/repository"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetUserByID_OnlyOwnDetailsWithoutPermission(t *testing.T) {
+	userHandler := handler.NewUserHandler(nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users/2", nil)
+	req.SetPathValue("id", "2")
+	rec := httptest.NewRecorder()
+
+	userHandler.GetUserByID(rec, asUser(req, 1))
+
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, rec.Code)
+	}
+}
+
+func TestUpdateMe_RejectsAdminField(t *testing.T) {
+	userHandler := handler.NewUserHandler(nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+	req := httptest.NewRequest(http.MethodPatch, "/api/v1/me", strings.NewReader(`{"username": "me", "isAdmin": true}`))
+	rec := httptest.NewRecorder()
+
+	userHandler.UpdateMe(rec, asUser(req, 1))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestUpdateDataUser_DoesNotNeedPassword(t *testing.T) {
+	ctx := context.Background()
+	users, db := repository.NewMemoryUserRepository()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := users.Register(ctx, tx, &models.RegisterRequest{Username: "renamed_later", Email: "renamed@example.com", Password: "hash"}); err != nil {
+		t.Fatal(err)
+	}
+	user, err := users.GetUserByUsername(ctx, tx, "renamed_later")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	userHandler := handler.NewUserHandler(users, nil, nil, nil, nil, nil, nil, nil, db)
+
+	// the update never changes the password, so none is asked for
+	body := `{"username": "renamed_now", "email": "renamed@example.com"}`
+	req := httptest.NewRequest(http.MethodPut, "/api/v1/users/"+strconv.Itoa(user.UserId), strings.NewReader(body))
+	req.SetPathValue("id", strconv.Itoa(user.UserId))
+	rec := httptest.NewRecorder()
+
+	userHandler.UpdateDataUser(rec, asUser(req, 1, auth.PermissionUsersUpdate))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if _, err := users.GetUserByUsername(ctx, nil, "renamed_now"); err != nil {
+		t.Errorf("Expected the user to be renamed, got %v", err)
+	}
+}
+
+func TestUpdateDataUser_NewEmail(t *testing.T) {
+	ctx := context.Background()
+	users, db := repository.NewMemoryUserRepository()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	for _, name := range []string{"moving", "staying"} {
+		if err := users.Register(ctx, tx, &models.RegisterRequest{Username: name, Email: name + "@example.com", Password: "hash"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	user, err := users.GetUserByUsername(ctx, tx, "moving")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := auth.NewEphemeralKeyManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	capture := &captureMailer{sent: make(chan mailer.Message, 2)}
+	verifier := auth.NewEmailVerifier(keys, capture, "http://localhost:8080/api/v1/verify-email", false)
+	userHandler := handler.NewUserHandler(users, nil, nil, nil, keys, verifier, nil, nil, db)
+
+	update := func(email string) *httptest.ResponseRecorder {
+		body := `{"username": "moving", "email": "` + email + `"}`
+		req := httptest.NewRequest(http.MethodPut, "/api/v1/users/"+strconv.Itoa(user.UserId), strings.NewReader(body))
+		req.SetPathValue("id", strconv.Itoa(user.UserId))
+		rec := httptest.NewRecorder()
+		userHandler.UpdateDataUser(rec, asUser(req, 1, auth.PermissionUsersUpdate))
+		return rec
+	}
+
+	// the email of another user is refused before the unique constraint fails
+	if rec := update("staying@example.com"); rec.Code != http.StatusConflict {
+		t.Errorf("Expected status %d for a taken email, got %d: %s", http.StatusConflict, rec.Code, rec.Body.String())
+	}
+
+	if rec := update("moved@example.com"); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	// the new email has to be verified again
+	select {
+	case msg := <-capture.sent:
+		if msg.To != "moved@example.com" {
+			t.Errorf("Expected the verification email to go to moved@example.com, got %s", msg.To)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("verification email was not sent")
+	}
+}
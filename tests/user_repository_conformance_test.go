@@ -0,0 +1,515 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"go-crud-database/models"
+	"go-crud-database/repository"
+	"go-crud-database/utils"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// userRepositoryHarness runs the conformance suite against one
+// implementation, db begins the transactions it understands.
+type userRepositoryHarness struct {
+	repo repository.UserRepository
+	db   *sql.DB
+}
+
+// write runs fn in a transaction that is committed when fn succeeds and
+// rolled back otherwise.
+func (h userRepositoryHarness) write(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const conformancePassword = "Quiet-orchard-35"
+
+// createUser registers a regular user that is deleted again after the test,
+// the Postgres suite shares its database with the other tests.
+func (h userRepositoryHarness) createUser(t *testing.T, name string) models.User {
+	t.Helper()
+	ctx := context.Background()
+
+	hash, err := utils.EncryptPassword(conformancePassword)
+	if err != nil {
+		t.Fatalf("Error hashing password: %v", err)
+	}
+
+	req := &models.RegisterRequest{Username: "conformance_" + name, Email: "conformance_" + name + "@example.com", Password: hash}
+	if err := h.write(ctx, func(tx *sql.Tx) error { return h.repo.Register(ctx, tx, req) }); err != nil {
+		t.Fatalf("Failed to register %s: %v", req.Username, err)
+	}
+
+	user, err := h.repo.GetUserByUsername(ctx, nil, req.Username)
+	if err != nil {
+		t.Fatalf("Failed to get %s: %v", req.Username, err)
+	}
+	t.Cleanup(func() {
+		h.write(ctx, func(tx *sql.Tx) error { return h.repo.DeleteUser(ctx, tx, strconv.Itoa(user.UserId)) })
+	})
+	return user
+}
+
+// testUserRepositoryConformance checks the behavior every UserRepository has
+// to share, transactions included. It runs against Postgres in
+// database_test.go and in memory below.
+func testUserRepositoryConformance(t *testing.T, h userRepositoryHarness) {
+	if err := utils.SetArgon2Params(utils.FastArgon2Params); err != nil {
+		t.Fatalf("Failed to set fast hashing: %v", err)
+	}
+	t.Cleanup(func() { utils.SetArgon2Params(utils.DefaultArgon2Params) })
+
+	ctx := context.Background()
+
+	t.Run("RegisterAndGet", func(t *testing.T) {
+		user := h.createUser(t, "get")
+		if user.Username != "conformance_get" || user.Email != "conformance_get@example.com" {
+			t.Errorf("Unexpected user %+v", user)
+		}
+		if user.IsAdmin || user.EmailVerifiedAt != nil || user.DisabledAt != nil || user.CreatedAt.IsZero() {
+			t.Errorf("Expected a new regular user, got %+v", user)
+		}
+
+		byEmail, err := h.repo.GetUserByEmail(ctx, nil, user.Email)
+		if err != nil || byEmail.UserId != user.UserId {
+			t.Errorf("GetUserByEmail() = (%+v, %v), want user %d", byEmail, err, user.UserId)
+		}
+
+		err = h.write(ctx, func(tx *sql.Tx) error {
+			locked, err := h.repo.GetUserByEmailForUpdate(ctx, tx, user.Email)
+			if err != nil || locked.UserId != user.UserId {
+				t.Errorf("GetUserByEmailForUpdate() = (%+v, %v), want user %d", locked, err, user.UserId)
+			}
+			if _, err := h.repo.GetUserByEmailForUpdate(ctx, tx, "conformance_missing@example.com"); err != sql.ErrNoRows {
+				t.Errorf("GetUserByEmailForUpdate() error = %v, want sql.ErrNoRows", err)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Errorf("Failed to lock the user: %v", err)
+		}
+
+		detail, err := h.repo.GetUserById(ctx, nil, strconv.Itoa(user.UserId))
+		if err != nil || detail.Username != user.Username || !detail.CreatedAt.Equal(user.CreatedAt) {
+			t.Errorf("GetUserById() = (%+v, %v), want %s", detail, err, user.Username)
+		}
+
+		for name, check := range map[string]func() (bool, error){
+			"username": func() (bool, error) { return h.repo.CheckUsernameExists(ctx, user.Username) },
+			"email":    func() (bool, error) { return h.repo.CheckEmailExists(ctx, user.Email) },
+			"id":       func() (bool, error) { return h.repo.CheckUserExists(ctx, strconv.Itoa(user.UserId)) },
+		} {
+			if exists, err := check(); err != nil || !exists {
+				t.Errorf("Check %s exists = (%v, %v), want (true, nil)", name, exists, err)
+			}
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		if _, err := h.repo.GetUserById(ctx, nil, "2147483647"); err != sql.ErrNoRows {
+			t.Errorf("GetUserById() error = %v, want sql.ErrNoRows", err)
+		}
+		if _, err := h.repo.GetUserByUsername(ctx, nil, "conformance_missing"); err != sql.ErrNoRows {
+			t.Errorf("GetUserByUsername() error = %v, want sql.ErrNoRows", err)
+		}
+		if _, err := h.repo.GetUserByEmail(ctx, nil, "conformance_missing@example.com"); err != sql.ErrNoRows {
+			t.Errorf("GetUserByEmail() error = %v, want sql.ErrNoRows", err)
+		}
+		if _, err := h.repo.Authentication(ctx, &models.LoginRequest{Username: "conformance_missing", Password: conformancePassword}); err != sql.ErrNoRows {
+			t.Errorf("Authentication() error = %v, want sql.ErrNoRows", err)
+		}
+		if exists, err := h.repo.CheckUserExists(ctx, "2147483647"); err != nil || exists {
+			t.Errorf("CheckUserExists() = (%v, %v), want (false, nil)", exists, err)
+		}
+		if exists, err := h.repo.CheckUsernameExists(ctx, "conformance_missing"); err != nil || exists {
+			t.Errorf("CheckUsernameExists() = (%v, %v), want (false, nil)", exists, err)
+		}
+
+		// like an UPDATE or DELETE that matches no row
+		err := h.write(ctx, func(tx *sql.Tx) error {
+			if err := h.repo.SetAdmin(ctx, tx, 2147483647, true); err != nil {
+				return err
+			}
+			return h.repo.DeleteUser(ctx, tx, "2147483647")
+		})
+		if err != nil {
+			t.Errorf("Expected changes of a missing user to do nothing, got %v", err)
+		}
+	})
+
+	t.Run("Unique", func(t *testing.T) {
+		user := h.createUser(t, "unique")
+		other := h.createUser(t, "unique_other")
+
+		for _, req := range []models.RegisterRequest{
+			{Username: user.Username, Email: "conformance_unique_new@example.com", Password: "hash"},
+			{Username: "conformance_unique_new", Email: user.Email, Password: "hash"},
+		} {
+			if err := h.write(ctx, func(tx *sql.Tx) error { return h.repo.Register(ctx, tx, &req) }); err == nil {
+				t.Errorf("Expected registering %s <%s> to fail", req.Username, req.Email)
+			}
+		}
+
+		err := h.write(ctx, func(tx *sql.Tx) error {
+			return h.repo.UpdateUser(ctx, tx, &models.UpdateUserRequest{UserId: other.UserId, Username: other.Username, Email: user.Email})
+		})
+		if err == nil {
+			t.Error("Expected taking the email of another user to fail")
+		}
+
+		if count, _ := h.repo.CountUser(ctx); count < 2 {
+			t.Errorf("CountUser() = %d, want at least 2", count)
+		}
+		if exists, _ := h.repo.CheckUsernameExists(ctx, "conformance_unique_new"); exists {
+			t.Error("Expected the rejected user not to exist")
+		}
+	})
+
+	t.Run("ElevatedInsert", func(t *testing.T) {
+		admin := &models.RegisterRequest{Username: "conformance_admin", Email: "conformance_admin@example.com", Password: "hash", IsAdmin: true}
+
+		err := h.write(ctx, func(tx *sql.Tx) error { return h.repo.Register(ctx, tx, admin) })
+		if err != repository.ErrElevatedInsert {
+			t.Fatalf("Expected ErrElevatedInsert, got %v", err)
+		}
+
+		err = h.write(ctx, func(tx *sql.Tx) error { return h.repo.Register(repository.AllowElevatedInsert(ctx), tx, admin) })
+		if err != nil {
+			t.Fatalf("Failed to register admin with AllowElevatedInsert: %v", err)
+		}
+		user, err := h.repo.GetUserByUsername(ctx, nil, admin.Username)
+		if err != nil || !user.IsAdmin {
+			t.Fatalf("Expected an admin, got (%+v, %v)", user, err)
+		}
+		h.write(ctx, func(tx *sql.Tx) error { return h.repo.DeleteUser(ctx, tx, strconv.Itoa(user.UserId)) })
+	})
+
+	t.Run("GetAllUserNewestFirst", func(t *testing.T) {
+		before, err := h.repo.CountUser(ctx)
+		if err != nil {
+			t.Fatalf("Failed to count users: %v", err)
+		}
+
+		var created []int
+		for i := 0; i < 3; i++ {
+			created = append(created, h.createUser(t, "list_"+strconv.Itoa(i)).UserId)
+		}
+
+		if count, err := h.repo.CountUser(ctx); err != nil || count != before+3 {
+			t.Fatalf("CountUser() = (%d, %v), want %d", count, err, before+3)
+		}
+
+		all, err := h.repo.GetAllUser(ctx, before+3, 0)
+		if err != nil || len(all) != before+3 {
+			t.Fatalf("GetAllUser() returned %d users, want %d: %v", len(all), before+3, err)
+		}
+
+		var listed []int
+		for i, user := range all {
+			if i > 0 && user.CreatedAt.After(all[i-1].CreatedAt) {
+				t.Errorf("Expected newest first, %s comes after an older user", user.Username)
+			}
+			if slices.Contains(created, user.UserId) {
+				listed = append(listed, user.UserId)
+			}
+		}
+		if !slices.Equal(listed, []int{created[2], created[1], created[0]}) {
+			t.Errorf("Expected the new users newest first, got %v of %v", listed, created)
+		}
+
+		// pages are slices of the same order, users created in one transaction
+		// before the test share created_at and may come in any order
+		for offset := range all {
+			if !slices.Contains(created, all[offset].UserId) {
+				continue
+			}
+			page, err := h.repo.GetAllUser(ctx, 1, offset)
+			if err != nil || len(page) != 1 || page[0].UserId != all[offset].UserId {
+				t.Errorf("GetAllUser(1, %d) = (%v, %v), want user %d", offset, page, err, all[offset].UserId)
+			}
+		}
+		if page, err := h.repo.GetAllUser(ctx, 10, before+3); err != nil || len(page) != 0 {
+			t.Errorf("Expected no users past the end, got (%v, %v)", page, err)
+		}
+	})
+
+	t.Run("Updates", func(t *testing.T) {
+		user := h.createUser(t, "update")
+		id := strconv.Itoa(user.UserId)
+
+		err := h.write(ctx, func(tx *sql.Tx) error {
+			if err := h.repo.MarkEmailVerified(ctx, tx, user.UserId, "conformance_other@example.com"); err != sql.ErrNoRows {
+				return errors.New("MarkEmailVerified with another email: expected sql.ErrNoRows, got " + errString(err))
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = h.write(ctx, func(tx *sql.Tx) error {
+			if err := h.repo.MarkEmailVerified(ctx, tx, user.UserId, user.Email); err != nil {
+				return err
+			}
+			if err := h.repo.SetAdmin(ctx, tx, user.UserId, true); err != nil {
+				return err
+			}
+			return h.repo.SetDisabled(ctx, tx, user.UserId, true)
+		})
+		if err != nil {
+			t.Fatalf("Failed to update user: %v", err)
+		}
+
+		first, _ := h.repo.GetUserById(ctx, nil, id)
+		if first.EmailVerifiedAt == nil || !first.IsAdmin || first.DisabledAt == nil {
+			t.Fatalf("Expected a verified, disabled admin, got %+v", first)
+		}
+
+		// verifying or disabling again keeps the first time
+		err = h.write(ctx, func(tx *sql.Tx) error {
+			if err := h.repo.MarkEmailVerified(ctx, tx, user.UserId, user.Email); err != nil {
+				return err
+			}
+			return h.repo.SetDisabled(ctx, tx, user.UserId, true)
+		})
+		if err != nil {
+			t.Fatalf("Failed to update user: %v", err)
+		}
+		again, _ := h.repo.GetUserById(ctx, nil, id)
+		if !again.EmailVerifiedAt.Equal(*first.EmailVerifiedAt) || !again.DisabledAt.Equal(*first.DisabledAt) {
+			t.Errorf("Expected the first timestamps to be kept, got %+v", again)
+		}
+
+		// the same email stays verified, a new one has to be verified again
+		err = h.write(ctx, func(tx *sql.Tx) error {
+			return h.repo.UpdateUser(ctx, tx, &models.UpdateUserRequest{UserId: user.UserId, Username: "conformance_renamed", Email: user.Email})
+		})
+		if err != nil {
+			t.Fatalf("Failed to update user: %v", err)
+		}
+		renamed, _ := h.repo.GetUserById(ctx, nil, id)
+		if renamed.Username != "conformance_renamed" || renamed.EmailVerifiedAt == nil {
+			t.Errorf("Expected a renamed, still verified user, got %+v", renamed)
+		}
+
+		err = h.write(ctx, func(tx *sql.Tx) error {
+			if err := h.repo.UpdateUser(ctx, tx, &models.UpdateUserRequest{UserId: user.UserId, Username: "conformance_renamed", Email: "conformance_renamed@example.com"}); err != nil {
+				return err
+			}
+			return h.repo.SetDisabled(ctx, tx, user.UserId, false)
+		})
+		if err != nil {
+			t.Fatalf("Failed to update user: %v", err)
+		}
+		changed, _ := h.repo.GetUserById(ctx, nil, id)
+		if changed.EmailVerifiedAt != nil || changed.DisabledAt != nil || !changed.IsAdmin {
+			t.Errorf("Expected an unverified, enabled admin, got %+v", changed)
+		}
+	})
+
+	t.Run("Password", func(t *testing.T) {
+		user := h.createUser(t, "password")
+		login := &models.LoginRequest{Username: user.Username, Password: conformancePassword}
+
+		if ok, err := h.repo.Authentication(ctx, login); err != nil || !ok {
+			t.Fatalf("Authentication() = (%v, %v), want (true, nil)", ok, err)
+		}
+
+		hash, _ := utils.EncryptPassword("Silver-canyon-52")
+		if err := h.write(ctx, func(tx *sql.Tx) error { return h.repo.UpdatePassword(ctx, tx, user.UserId, hash) }); err != nil {
+			t.Fatalf("Failed to update password: %v", err)
+		}
+		if ok, err := h.repo.Authentication(ctx, login); err != nil || ok {
+			t.Errorf("Authentication() with the old password = (%v, %v), want (false, nil)", ok, err)
+		}
+		login.Password = "Silver-canyon-52"
+		if ok, err := h.repo.Authentication(ctx, login); err != nil || !ok {
+			t.Errorf("Authentication() with the new password = (%v, %v), want (true, nil)", ok, err)
+		}
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		user := h.createUser(t, "rollback")
+
+		tx, err := h.db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatalf("Failed to begin transaction: %v", err)
+		}
+		defer tx.Rollback()
+
+		req := &models.RegisterRequest{Username: "conformance_rollback_new", Email: "conformance_rollback_new@example.com", Password: "hash"}
+		if err := h.repo.Register(ctx, tx, req); err != nil {
+			t.Fatalf("Failed to register user: %v", err)
+		}
+		update := &models.UpdateUserRequest{UserId: user.UserId, Username: "conformance_rollback_renamed", Email: user.Email}
+		if err := h.repo.UpdateUser(ctx, tx, update); err != nil {
+			t.Fatalf("Failed to update user: %v", err)
+		}
+		if err := h.repo.DeleteUser(ctx, tx, strconv.Itoa(user.UserId)); err != nil {
+			t.Fatalf("Failed to delete user: %v", err)
+		}
+
+		// the transaction sees its changes, nobody else does
+		if _, err := h.repo.GetUserByUsername(ctx, tx, req.Username); err != nil {
+			t.Errorf("Expected the transaction to see the new user, got %v", err)
+		}
+		if _, err := h.repo.GetUserById(ctx, tx, strconv.Itoa(user.UserId)); err != sql.ErrNoRows {
+			t.Errorf("Expected the transaction not to see the deleted user, got %v", err)
+		}
+		if exists, _ := h.repo.CheckUsernameExists(ctx, req.Username); exists {
+			t.Error("Expected the uncommitted user to be invisible outside the transaction")
+		}
+		if _, err := h.repo.GetUserByUsername(ctx, nil, user.Username); err != nil {
+			t.Errorf("Expected the user unchanged outside the transaction, got %v", err)
+		}
+
+		if err := tx.Rollback(); err != nil {
+			t.Fatalf("Failed to roll back: %v", err)
+		}
+
+		if exists, _ := h.repo.CheckUsernameExists(ctx, req.Username); exists {
+			t.Error("Expected the rolled back user not to exist")
+		}
+		stored, err := h.repo.GetUserById(ctx, nil, strconv.Itoa(user.UserId))
+		if err != nil || stored.Username != user.Username {
+			t.Errorf("Expected the update and delete to be rolled back, got (%+v, %v)", stored, err)
+		}
+		if _, err := h.repo.GetUserByUsername(ctx, tx, user.Username); err == nil {
+			t.Error("Expected the finished transaction to be unusable")
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		user := h.createUser(t, "delete")
+		id := strconv.Itoa(user.UserId)
+
+		if err := h.write(ctx, func(tx *sql.Tx) error { return h.repo.DeleteUser(ctx, tx, id) }); err != nil {
+			t.Fatalf("Failed to delete user: %v", err)
+		}
+		if _, err := h.repo.GetUserById(ctx, nil, id); err != sql.ErrNoRows {
+			t.Errorf("GetUserById() after delete: error = %v, want sql.ErrNoRows", err)
+		}
+		if exists, err := h.repo.CheckEmailExists(ctx, user.Email); err != nil || exists {
+			t.Errorf("CheckEmailExists() after delete = (%v, %v), want (false, nil)", exists, err)
+		}
+
+		// the username is free again
+		h.createUser(t, "delete")
+	})
+}
+
+func errString(err error) string {
+	if err == nil {
+		return "nil"
+	}
+	return err.Error()
+}
+
+func newMemoryHarness() userRepositoryHarness {
+	repo, db := repository.NewMemoryUserRepository()
+	return userRepositoryHarness{repo: repo, db: db}
+}
+
+func TestUserRepositoryConformance_Memory(t *testing.T) {
+	testUserRepositoryConformance(t, newMemoryHarness())
+}
+
+func TestMemoryUserRepository_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	repo, _ := repository.NewMemoryUserRepository()
+
+	// everyone races for the same username, the constraint lets one win
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- repo.Register(ctx, nil, &models.RegisterRequest{
+				Username: "racer",
+				Email:    "racer" + strconv.Itoa(i) + "@example.com",
+				Password: "hash",
+			})
+			repo.GetAllUser(ctx, 10, 0)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, repository.ErrDuplicateUser):
+			t.Errorf("Expected ErrDuplicateUser, got %v", err)
+		}
+	}
+	if count, _ := repo.CountUser(ctx); succeeded != 1 || count != 1 {
+		t.Errorf("Expected exactly one user, %d registrations succeeded and %d users exist", succeeded, count)
+	}
+}
+
+func TestMemoryUserRepository_ReturnsCopies(t *testing.T) {
+	ctx := context.Background()
+	repo, _ := repository.NewMemoryUserRepository()
+	repo.Register(ctx, nil, &models.RegisterRequest{Username: "copy", Email: "copy@example.com", Password: "hash"})
+
+	user, _ := repo.GetUserByUsername(ctx, nil, "copy")
+	repo.MarkEmailVerified(ctx, nil, user.UserId, user.Email)
+	user, _ = repo.GetUserByUsername(ctx, nil, "copy")
+
+	verifiedAt := *user.EmailVerifiedAt
+	*user.EmailVerifiedAt = verifiedAt.AddDate(1, 0, 0)
+
+	again, _ := repo.GetUserByUsername(ctx, nil, "copy")
+	if !again.EmailVerifiedAt.Equal(verifiedAt) {
+		t.Error("Expected changing a returned user not to change the stored one")
+	}
+}
+
+func TestMemoryUserRepository_CommitChecksConstraints(t *testing.T) {
+	ctx := context.Background()
+	repo, db := repository.NewMemoryUserRepository()
+
+	first, _ := db.BeginTx(ctx, nil)
+	defer first.Rollback()
+	second, _ := db.BeginTx(ctx, nil)
+	defer second.Rollback()
+
+	// neither sees the other's insert, the later commit loses
+	for i, tx := range []*sql.Tx{first, second} {
+		req := &models.RegisterRequest{Username: "twin", Email: "twin" + strconv.Itoa(i) + "@example.com", Password: "hash"}
+		if err := repo.Register(ctx, tx, req); err != nil {
+			t.Fatalf("Failed to register in transaction %d: %v", i, err)
+		}
+	}
+
+	if err := first.Commit(); err != nil {
+		t.Fatalf("Failed to commit the first transaction: %v", err)
+	}
+	if err := second.Commit(); !errors.Is(err, repository.ErrDuplicateUser) {
+		t.Errorf("Expected ErrDuplicateUser on commit, got %v", err)
+	}
+
+	user, err := repo.GetUserByUsername(ctx, nil, "twin")
+	if err != nil || user.Email != "twin0@example.com" {
+		t.Errorf("Expected the first user to be kept, got (%+v, %v)", user, err)
+	}
+	if count, _ := repo.CountUser(ctx); count != 1 {
+		t.Errorf("Expected one user, got %d", count)
+	}
+}
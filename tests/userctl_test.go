@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"go-crud-database/models"
+	"go-crud-database/repository"
+	"go-crud-database/userctl"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubUserRepo knows a single user, the methods it doesn't override panic
+type stubUserRepo struct {
+	repository.UserRepository
+	user models.User
+}
+
+func (s *stubUserRepo) GetUserByUsername(ctx context.Context, tx *sql.Tx, username string) (models.User, error) {
+	if username != s.user.Username {
+		return models.User{}, sql.ErrNoRows
+	}
+	return s.user, nil
+}
+
+func (s *stubUserRepo) GetUserById(ctx context.Context, tx *sql.Tx, id string) (models.DetailUser, error) {
+	if id != strconv.Itoa(s.user.UserId) {
+		return models.DetailUser{}, sql.ErrNoRows
+	}
+	return models.DetailUser{
+		UserId:          s.user.UserId,
+		Username:        s.user.Username,
+		Email:           s.user.Email,
+		IsAdmin:         s.user.IsAdmin,
+		EmailVerifiedAt: s.user.EmailVerifiedAt,
+		DisabledAt:      s.user.DisabledAt,
+		CreatedAt:       s.user.CreatedAt,
+		UpdatedAt:       s.user.UpdatedAt,
+	}, nil
+}
+
+func newStubApp(input string) (*userctl.App, *bytes.Buffer) {
+	out := &bytes.Buffer{}
+	return &userctl.App{
+		Users: &stubUserRepo{user: models.User{
+			UserId:    7,
+			Username:  "alice",
+			Email:     "alice@example.com",
+			Password:  "secret-hash",
+			CreatedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		}},
+		In:  strings.NewReader(input),
+		Out: out,
+	}, out
+}
+
+func TestUserctl_Arguments(t *testing.T) {
+	tests := [][]string{
+		{},
+		{"unknown"},
+		{"get"},
+		{"get", "alice", "bob"},
+		{"delete"},
+		{"list", "extra"},
+		{"list", "-limit", "0"},
+		{"export", "-format", "xml"},
+	}
+
+	for _, args := range tests {
+		app, _ := newStubApp("")
+		if err := app.Run(context.Background(), args); err == nil {
+			t.Errorf("Run(%q): expected an error", args)
+		}
+	}
+}
+
+func TestUserctl_GetOutput(t *testing.T) {
+	app, out := newStubApp("")
+	if err := app.Run(context.Background(), []string{"get", "alice", "-o", "json"}); err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+
+	var user models.DetailUser
+	if err := json.Unmarshal(out.Bytes(), &user); err != nil {
+		t.Fatalf("Expected a JSON object, got %q: %v", out.String(), err)
+	}
+	if user.UserId != 7 || user.Username != "alice" {
+		t.Errorf("Unexpected user %+v", user)
+	}
+	if strings.Contains(out.String(), "secret-hash") {
+		t.Error("Expected the password hash to stay out of the output")
+	}
+
+	app, out = newStubApp("")
+	if err := app.Run(context.Background(), []string{"get", "alice"}); err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "ID") || !strings.Contains(out.String(), "alice@example.com") {
+		t.Errorf("Expected a table, got %q", out.String())
+	}
+
+	app, _ = newStubApp("")
+	if err := app.Run(context.Background(), []string{"get", "alice", "-o", "yaml"}); err == nil {
+		t.Error("Expected an error for an unknown output format")
+	}
+
+	app, _ = newStubApp("")
+	if err := app.Run(context.Background(), []string{"get", "bob"}); !errors.Is(err, userctl.ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+}
+
+func TestUserctl_ConfirmationAborts(t *testing.T) {
+	// App has no database, getting past the prompt would panic
+	for _, command := range []string{"delete", "disable", "demote"} {
+		for _, answer := range []string{"n\n", "\n", "", "maybe\n"} {
+			app, out := newStubApp(answer)
+			if command == "demote" {
+				app.Users.(*stubUserRepo).user.IsAdmin = true
+			}
+
+			err := app.Run(context.Background(), []string{command, "alice"})
+			if !errors.Is(err, userctl.ErrAborted) {
+				t.Errorf("%s with answer %q: expected ErrAborted, got %v", command, answer, err)
+			}
+			if !strings.Contains(out.String(), "[y/N]") {
+				t.Errorf("%s: expected a prompt, got %q", command, out.String())
+			}
+		}
+	}
+}
@@ -101,10 +101,10 @@ func TestValidateLoginRequest(t *testing.T) {
 			wantBool: false,
 		},
 		{
-			name:     "Short password",
+			name:     "Short password is left to the policy",
 			input:    models.LoginRequest{Username: "user123", Password: "123"},
-			wantMsg:  "Password must be at least 5 characters",
-			wantBool: false,
+			wantMsg:  "",
+			wantBool: true,
 		},
 	}
 
@@ -129,49 +129,37 @@ func TestValidateUpdateUserRequest(t *testing.T) {
 	}{
 		{
 			name:     "Valid input",
-			input:    models.UpdateUserRequest{Username: "user123", Email: "user@example.com", Password: "password"},
+			input:    models.UpdateUserRequest{Username: "user123", Email: "user@example.com"},
 			wantMsg:  "",
 			wantBool: true,
 		},
 		{
 			name:     "Empty username",
-			input:    models.UpdateUserRequest{Username: "", Email: "user@example.com", Password: "password"},
+			input:    models.UpdateUserRequest{Username: "", Email: "user@example.com"},
 			wantMsg:  "Username cannot be empty",
 			wantBool: false,
 		},
 		{
 			name:     "Empty email",
-			input:    models.UpdateUserRequest{Username: "user123", Email: "", Password: "password"},
+			input:    models.UpdateUserRequest{Username: "user123", Email: ""},
 			wantMsg:  "Email cannot be empty",
 			wantBool: false,
 		},
-		{
-			name:     "Empty password",
-			input:    models.UpdateUserRequest{Username: "user123", Email: "user@example.com", Password: ""},
-			wantMsg:  "Password cannot be empty",
-			wantBool: false,
-		},
-		{
-			name:     "Short password",
-			input:    models.UpdateUserRequest{Username: "user123", Email: "user@example.com", Password: "123"},
-			wantMsg:  "Password must be at least 5 characters",
-			wantBool: false,
-		},
 		{
 			name:     "Invalid email format (missing @)",
-			input:    models.UpdateUserRequest{Username: "user123", Email: "userexample.com", Password: "password"},
+			input:    models.UpdateUserRequest{Username: "user123", Email: "userexample.com"},
 			wantMsg:  "Invalid email format",
 			wantBool: false,
 		},
 		{
 			name:     "Invalid email format (missing domain)",
-			input:    models.UpdateUserRequest{Username: "user123", Email: "user@.com", Password: "password"},
+			input:    models.UpdateUserRequest{Username: "user123", Email: "user@.com"},
 			wantMsg:  "Invalid email format",
 			wantBool: false,
 		},
 		{
 			name:     "Invalid email format (missing TLD)",
-			input:    models.UpdateUserRequest{Username: "user123", Email: "user@example", Password: "password"},
+			input:    models.UpdateUserRequest{Username: "user123", Email: "user@example"},
 			wantMsg:  "Invalid email format",
 			wantBool: false,
 		},
@@ -186,3 +174,101 @@ func TestValidateUpdateUserRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateUpdateProfileRequest(t *testing.T) {
+	username := "user123"
+	email := "user@example.com"
+	blank := " "
+	invalidEmail := "userexample.com"
+
+	tests := []struct {
+		name     string
+		input    models.UpdateProfileRequest
+		wantMsg  string
+		wantBool bool
+	}{
+		{
+			name:     "Valid input",
+			input:    models.UpdateProfileRequest{Username: &username, Email: &email},
+			wantMsg:  "",
+			wantBool: true,
+		},
+		{
+			name:     "Only username",
+			input:    models.UpdateProfileRequest{Username: &username},
+			wantMsg:  "",
+			wantBool: true,
+		},
+		{
+			name:     "Nothing to update",
+			input:    models.UpdateProfileRequest{},
+			wantMsg:  "Nothing to update",
+			wantBool: false,
+		},
+		{
+			name:     "Empty username",
+			input:    models.UpdateProfileRequest{Username: &blank},
+			wantMsg:  "Username cannot be empty",
+			wantBool: false,
+		},
+		{
+			name:     "Invalid email format",
+			input:    models.UpdateProfileRequest{Email: &invalidEmail},
+			wantMsg:  "Invalid email format",
+			wantBool: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotMsg, gotBool := utils.ValidateUpdateProfileRequest(tt.input)
+			if gotMsg != tt.wantMsg || gotBool != tt.wantBool {
+				t.Errorf("ValidateUpdateProfileRequest(%v) = (%v, %v), want (%v, %v)", tt.input, gotMsg, gotBool, tt.wantMsg, tt.wantBool)
+			}
+		})
+	}
+}
+
+func TestValidateChangePasswordRequest(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		input    models.ChangePasswordRequest
+		wantMsg  string
+		wantBool bool
+	}{
+		{
+			name:     "Valid input",
+			input:    models.ChangePasswordRequest{CurrentPassword: "password", NewPassword: "newpassword"},
+			wantMsg:  "",
+			wantBool: true,
+		},
+		{
+			name:     "Empty current password",
+			input:    models.ChangePasswordRequest{CurrentPassword: "", NewPassword: "newpassword"},
+			wantMsg:  "Current password cannot be empty",
+			wantBool: false,
+		},
+		{
+			name:     "Empty new password",
+			input:    models.ChangePasswordRequest{CurrentPassword: "password", NewPassword: ""},
+			wantMsg:  "New password cannot be empty",
+			wantBool: false,
+		},
+		{
+			name:     "Same password",
+			input:    models.ChangePasswordRequest{CurrentPassword: "password", NewPassword: "password"},
+			wantMsg:  "New password must be different from the current password",
+			wantBool: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotMsg, gotBool := utils.ValidateChangePasswordRequest(tt.input)
+			if gotMsg != tt.wantMsg || gotBool != tt.wantBool {
+				t.Errorf("ValidateChangePasswordRequest(%v) = (%v, %v), want (%v, %v)", tt.input, gotMsg, gotBool, tt.wantMsg, tt.wantBool)
+			}
+		})
+	}
+}
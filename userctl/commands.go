@@ -0,0 +1,391 @@
+package userctl
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"go-crud-database/models"
+	"go-crud-database/repository"
+	"go-crud-database/utils"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportPageSize is how many users export reads at a time.
+const exportPageSize = 500
+
+func (a *App) create(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("create", flag.ContinueOnError)
+	username := fs.String("username", "", "username, required")
+	email := fs.String("email", "", "email address, required")
+	admin := fs.Bool("admin", false, "give the user admin rights")
+	verified := fs.Bool("verified", false, "mark the email address as verified")
+	passwordStdin := fs.Bool("password-stdin", false, "read the password from the first line of stdin instead of generating one")
+	output := outputFlag(fs)
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	password, generated, err := a.readPassword(*passwordStdin, *username, *email)
+	if err != nil {
+		return err
+	}
+
+	req := models.RegisterRequest{Username: *username, Email: *email, Password: password, IsAdmin: *admin}
+	if msg, ok := utils.ValidateRegisterRequest(req); !ok {
+		return fmt.Errorf("invalid user: %s", msg)
+	}
+
+	if exists, err := a.Users.CheckUsernameExists(ctx, req.Username); err != nil || exists {
+		return existsError("username", req.Username, err)
+	}
+	if exists, err := a.Users.CheckEmailExists(ctx, req.Email); err != nil || exists {
+		return existsError("email", req.Email, err)
+	}
+
+	req.Password, err = utils.EncryptPassword(password)
+	if err != nil {
+		return err
+	}
+
+	var user models.DetailUser
+	err = a.inTx(ctx, func(tx *sql.Tx) error {
+		// admins are created on purpose here, that's what the command is for
+		if err := a.Users.Register(repository.AllowElevatedInsert(ctx), tx, &req); err != nil {
+			return err
+		}
+
+		created, err := a.Users.GetUserByUsername(ctx, tx, req.Username)
+		if err != nil {
+			return err
+		}
+		if *verified {
+			if err := a.Users.MarkEmailVerified(ctx, tx, created.UserId, created.Email); err != nil {
+				return err
+			}
+		}
+
+		user, err = a.lookup(ctx, tx, strconv.Itoa(created.UserId))
+		if err != nil {
+			return err
+		}
+		return a.audit(ctx, tx, "user.create", user)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := a.printUser(*output, user); err != nil {
+		return err
+	}
+	if generated {
+		// shown once, only the hash is stored
+		fmt.Fprintf(a.Out, "password: %s\n", password)
+	}
+	return nil
+}
+
+func existsError(field, value string, err error) error {
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%s %s is taken already", field, value)
+}
+
+func (a *App) list(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	limit := fs.Int("limit", 20, "number of users")
+	offset := fs.Int("offset", 0, "number of users to skip")
+	output := outputFlag(fs)
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	if *limit < 1 || *offset < 0 {
+		return fmt.Errorf("limit must be at least 1 and offset can't be negative")
+	}
+
+	users, err := a.Users.GetAllUser(ctx, *limit, *offset)
+	if err != nil {
+		return err
+	}
+
+	details := make([]models.DetailUser, 0, len(users))
+	for _, user := range users {
+		details = append(details, detail(user))
+	}
+	return a.printUsers(*output, details)
+}
+
+func (a *App) get(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	output := outputFlag(fs)
+	rest, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	user, err := a.lookup(ctx, nil, rest[0])
+	if err != nil {
+		return err
+	}
+	return a.printUser(*output, user)
+}
+
+func (a *App) setPassword(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("set-password", flag.ContinueOnError)
+	passwordStdin := fs.Bool("password-stdin", false, "read the password from the first line of stdin instead of generating one")
+	yes := fs.Bool("yes", false, "don't ask for confirmation")
+	rest, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	user, err := a.lookup(ctx, nil, rest[0])
+	if err != nil {
+		return err
+	}
+
+	password, generated, err := a.readPassword(*passwordStdin, user.Username, user.Email)
+	if err != nil {
+		return err
+	}
+	if violations := utils.ValidatePassword(password, user.Username, user.Email); len(violations) > 0 {
+		return fmt.Errorf("invalid password: %s", strings.Join(violations, "; "))
+	}
+
+	if !*yes {
+		if err := a.confirm(fmt.Sprintf("Set a new password for %s (id %d) and log them out everywhere?", user.Username, user.UserId)); err != nil {
+			return err
+		}
+	}
+
+	passwordHash, err := utils.EncryptPassword(password)
+	if err != nil {
+		return err
+	}
+
+	err = a.inTx(ctx, func(tx *sql.Tx) error {
+		if err := a.Users.UpdatePassword(ctx, tx, user.UserId, passwordHash); err != nil {
+			return err
+		}
+		return a.audit(ctx, tx, "user.set_password", user)
+	})
+	if err != nil {
+		return err
+	}
+
+	// whoever knew the old password may hold tokens
+	if err := a.Revocations.RevokeUser(ctx, user.UserId); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(a.Out, "password of %s changed\n", user.Username)
+	if generated {
+		fmt.Fprintf(a.Out, "password: %s\n", password)
+	}
+	return nil
+}
+
+func (a *App) setAdmin(ctx context.Context, name string, args []string, isAdmin bool) error {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	yes := fs.Bool("yes", false, "don't ask for confirmation")
+	rest, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	user, err := a.lookup(ctx, nil, rest[0])
+	if err != nil {
+		return err
+	}
+	if user.IsAdmin == isAdmin {
+		fmt.Fprintf(a.Out, "%s is %s already\n", user.Username, map[bool]string{true: "an admin", false: "not an admin"}[isAdmin])
+		return nil
+	}
+
+	if !isAdmin && !*yes {
+		if err := a.confirm(fmt.Sprintf("Take admin rights away from %s (id %d)?", user.Username, user.UserId)); err != nil {
+			return err
+		}
+	}
+
+	err = a.inTx(ctx, func(tx *sql.Tx) error {
+		if err := a.Users.SetAdmin(ctx, tx, user.UserId, isAdmin); err != nil {
+			return err
+		}
+		return a.audit(ctx, tx, "user."+name, user)
+	})
+	if err != nil {
+		return err
+	}
+
+	// the old access tokens still carry the old permissions
+	if err := a.Revocations.RevokeUser(ctx, user.UserId); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(a.Out, "%sd %s\n", name, user.Username)
+	return nil
+}
+
+func (a *App) delete(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("delete", flag.ContinueOnError)
+	yes := fs.Bool("yes", false, "don't ask for confirmation")
+	rest, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	user, err := a.lookup(ctx, nil, rest[0])
+	if err != nil {
+		return err
+	}
+
+	if !*yes {
+		if err := a.confirm(fmt.Sprintf("Delete %s (id %d, %s)? This can't be undone.", user.Username, user.UserId, user.Email)); err != nil {
+			return err
+		}
+	}
+
+	err = a.inTx(ctx, func(tx *sql.Tx) error {
+		if err := a.audit(ctx, tx, "user.delete", user); err != nil {
+			return err
+		}
+		return a.Users.DeleteUser(ctx, tx, strconv.Itoa(user.UserId))
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := a.Revocations.RevokeUser(ctx, user.UserId); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(a.Out, "deleted %s\n", user.Username)
+	return nil
+}
+
+func (a *App) setDisabled(ctx context.Context, name string, args []string, disabled bool) error {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	yes := fs.Bool("yes", false, "don't ask for confirmation")
+	rest, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	user, err := a.lookup(ctx, nil, rest[0])
+	if err != nil {
+		return err
+	}
+
+	if disabled && !*yes {
+		if err := a.confirm(fmt.Sprintf("Disable %s (id %d)? Their sessions and API keys are revoked.", user.Username, user.UserId)); err != nil {
+			return err
+		}
+	}
+
+	err = a.inTx(ctx, func(tx *sql.Tx) error {
+		if err := a.Users.SetDisabled(ctx, tx, user.UserId, disabled); err != nil {
+			return err
+		}
+		return a.audit(ctx, tx, "user."+name, user)
+	})
+	if err != nil {
+		return err
+	}
+
+	if disabled {
+		// new logins are refused, what the user holds already is revoked
+		if err := a.Revocations.RevokeUser(ctx, user.UserId); err != nil {
+			return err
+		}
+		if err := a.revokeAPIKeys(ctx, user.UserId); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(a.Out, "%sd %s\n", name, user.Username)
+	return nil
+}
+
+func (a *App) revokeAPIKeys(ctx context.Context, userId int) error {
+	keys, err := a.APIKeys.ListByUserId(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if key.RevokedAt != nil {
+			continue
+		}
+		if err := a.APIKeys.Revoke(ctx, userId, key.KeyId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *App) export(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "json", "json or csv")
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	if *format != "json" && *format != "csv" {
+		return fmt.Errorf("unknown export format %q, use json or csv", *format)
+	}
+
+	users := []models.DetailUser{}
+	for offset := 0; ; offset += exportPageSize {
+		page, err := a.Users.GetAllUser(ctx, exportPageSize, offset)
+		if err != nil {
+			return err
+		}
+		for _, user := range page {
+			users = append(users, detail(user))
+		}
+		if len(page) < exportPageSize {
+			break
+		}
+	}
+
+	if *format == "json" {
+		return a.writeJSON(users)
+	}
+
+	w := csv.NewWriter(a.Out)
+	w.Write([]string{"user_id", "username", "email", "is_admin", "email_verified_at", "disabled_at", "created_at", "updated_at"})
+	for _, user := range users {
+		w.Write([]string{
+			strconv.Itoa(user.UserId), user.Username, user.Email, strconv.FormatBool(user.IsAdmin),
+			formatTime(user.EmailVerifiedAt), formatTime(user.DisabledAt),
+			user.CreatedAt.Format(time.RFC3339), user.UpdatedAt.Format(time.RFC3339),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// inTx runs fn in a transaction that is committed when fn succeeds.
+func (a *App) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := a.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
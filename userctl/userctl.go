@@ -0,0 +1,255 @@
+// Package userctl manages users from the command line, straight against the
+// database and without the HTTP server. cmd/userctl wires it up.
+package userctl
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"go-crud-database/auth"
+	"go-crud-database/models"
+	"go-crud-database/repository"
+	"go-crud-database/utils"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `usage: userctl [config flags] <command> [flags] [user]
+
+commands:
+  create        create a user
+  list          list users, newest first
+  get           show one user
+  set-password  set a new password and log the user out everywhere
+  promote       give a user admin rights
+  demote        take admin rights away
+  delete        delete a user
+  disable       keep a user from logging in, revoking tokens and API keys
+  enable        allow a disabled user to log in again
+  export        write every user as JSON or CSV
+
+A user is given by id or username. Run "userctl <command> -help" for the
+flags of a command.`
+
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrAborted      = errors.New("aborted")
+)
+
+// App holds what the commands need. In is read for confirmations and
+// passwords, Out gets the results.
+type App struct {
+	DB          *sql.DB
+	Users       repository.UserRepository
+	APIKeys     repository.APIKeyRepository
+	Audit       repository.AuditRepository
+	Revocations *auth.RevocationStore
+
+	// Operator names who runs the command in the audit log, e.g. $USER
+	Operator string
+
+	In  io.Reader
+	Out io.Writer
+
+	in *bufio.Reader
+}
+
+// Run runs the command in args.
+func (a *App) Run(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	commands := map[string]func(context.Context, []string) error{
+		"create":       a.create,
+		"list":         a.list,
+		"get":          a.get,
+		"set-password": a.setPassword,
+		"promote":      func(ctx context.Context, args []string) error { return a.setAdmin(ctx, "promote", args, true) },
+		"demote":       func(ctx context.Context, args []string) error { return a.setAdmin(ctx, "demote", args, false) },
+		"delete":       a.delete,
+		"disable":      func(ctx context.Context, args []string) error { return a.setDisabled(ctx, "disable", args, true) },
+		"enable":       func(ctx context.Context, args []string) error { return a.setDisabled(ctx, "enable", args, false) },
+		"export":       a.export,
+	}
+
+	command, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q\n\n%s", args[0], usage)
+	}
+	return command(ctx, args[1:])
+}
+
+// parse parses the flags of a command and returns the other arguments. Flags
+// may come before or after them, "get alice -o json" works like
+// "get -o json alice".
+func parse(fs *flag.FlagSet, args []string, positional int) ([]string, error) {
+	var rest []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		rest = append(rest, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	if len(rest) != positional {
+		return nil, fmt.Errorf("%s takes %d argument(s), got %d", fs.Name(), positional, len(rest))
+	}
+	return rest, nil
+}
+
+// outputFlag adds -o table|json.
+func outputFlag(fs *flag.FlagSet) *string {
+	return fs.String("o", "table", "output format: table or json")
+}
+
+// lookup finds a user by id or username.
+func (a *App) lookup(ctx context.Context, tx *sql.Tx, identifier string) (models.DetailUser, error) {
+	if _, err := strconv.Atoi(identifier); err == nil {
+		user, err := a.Users.GetUserById(ctx, tx, identifier)
+		if err == sql.ErrNoRows {
+			return user, fmt.Errorf("%w: %s", ErrUserNotFound, identifier)
+		}
+		return user, err
+	}
+
+	user, err := a.Users.GetUserByUsername(ctx, tx, identifier)
+	if err == sql.ErrNoRows {
+		return models.DetailUser{}, fmt.Errorf("%w: %s", ErrUserNotFound, identifier)
+	}
+	if err != nil {
+		return models.DetailUser{}, err
+	}
+	return detail(user), nil
+}
+
+// detail drops the password hash, it is never printed or exported.
+func detail(user models.User) models.DetailUser {
+	return models.DetailUser{
+		UserId:          user.UserId,
+		Username:        user.Username,
+		Email:           user.Email,
+		IsAdmin:         user.IsAdmin,
+		EmailVerifiedAt: user.EmailVerifiedAt,
+		DisabledAt:      user.DisabledAt,
+		CreatedAt:       user.CreatedAt,
+		UpdatedAt:       user.UpdatedAt,
+	}
+}
+
+// confirm asks a yes/no question, anything but y or yes is a no.
+func (a *App) confirm(question string) error {
+	fmt.Fprintf(a.Out, "%s [y/N]: ", question)
+
+	answer, err := a.readLine()
+	if err != nil && err != io.EOF {
+		return err
+	}
+	switch strings.ToLower(answer) {
+	case "y", "yes":
+		return nil
+	default:
+		return ErrAborted
+	}
+}
+
+func (a *App) readLine() (string, error) {
+	if a.in == nil {
+		a.in = bufio.NewReader(a.In)
+	}
+
+	line, err := a.in.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// readPassword reads the password from the first line of In with fromStdin,
+// or generates one that satisfies the password policy.
+func (a *App) readPassword(fromStdin bool, username, email string) (password string, generated bool, err error) {
+	if fromStdin {
+		password, err = a.readLine()
+		if err != nil {
+			return "", false, fmt.Errorf("error reading password: %w", err)
+		}
+		return password, false, nil
+	}
+
+	// random tokens only miss a required character class now and then
+	for i := 0; i < 20; i++ {
+		password, err = utils.GenerateRandomToken(18)
+		if err != nil {
+			return "", false, err
+		}
+		if len(utils.ValidatePassword(password, username, email)) == 0 {
+			return password, true, nil
+		}
+	}
+	return "", false, errors.New("couldn't generate a password for the password policy, use -password-stdin")
+}
+
+// audit records a change made from the command line. There is no acting
+// user, so the actor is 0 and the details name the operator.
+func (a *App) audit(ctx context.Context, tx *sql.Tx, action string, user models.DetailUser) error {
+	return a.Audit.Record(ctx, tx, &models.AuditLog{
+		ActorUserId:  0,
+		Action:       action,
+		TargetUserId: user.UserId,
+		Details:      fmt.Sprintf("username=%s; via=userctl; operator=%s", user.Username, a.Operator),
+	})
+}
+
+// printUser writes one user, as an object with json.
+func (a *App) printUser(format string, user models.DetailUser) error {
+	if format == "json" {
+		return a.writeJSON(user)
+	}
+	return a.printUsers(format, []models.DetailUser{user})
+}
+
+// printUsers writes users, as an array with json.
+func (a *App) printUsers(format string, users []models.DetailUser) error {
+	switch format {
+	case "json":
+		if users == nil {
+			users = []models.DetailUser{}
+		}
+		return a.writeJSON(users)
+	case "table":
+		w := tabwriter.NewWriter(a.Out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tUSERNAME\tEMAIL\tADMIN\tVERIFIED\tDISABLED\tCREATED")
+		for _, user := range users {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				user.UserId, user.Username, user.Email, yesNo(user.IsAdmin),
+				yesNo(user.EmailVerifiedAt != nil), yesNo(user.DisabledAt != nil), user.CreatedAt.Format(time.DateTime))
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown output format %q, use table or json", format)
+	}
+}
+
+func (a *App) writeJSON(value interface{}) error {
+	encoder := json.NewEncoder(a.Out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+	return "no"
+}
@@ -1,18 +1,173 @@
 package utils
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
 
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Argon2Params are the Argon2id settings used for new hashes. Memory is in KiB.
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params follows the OWASP recommendation of 19 MiB, two
+// iterations and one lane.
+var DefaultArgon2Params = Argon2Params{
+	Memory:      19 * 1024,
+	Iterations:  2,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// FastArgon2Params hash in well under a millisecond. They are far too weak
+// for real passwords and meant for seeding development databases and for
+// tests; NeedsRehash reports the hashes, so they are upgraded on the next
+// login.
+var FastArgon2Params = Argon2Params{
+	Memory:      64,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+var (
+	ErrInvalidArgon2Params = errors.New("invalid argon2 parameters")
+	errInvalidPasswordHash = errors.New("invalid password hash")
+)
+
+var (
+	argon2ParamsMu sync.RWMutex
+	argon2Params   = DefaultArgon2Params
+)
+
+// SetArgon2Params changes the parameters of new hashes. Existing hashes keep
+// verifying, NeedsRehash reports them as outdated.
+func SetArgon2Params(params Argon2Params) error {
+	if err := params.Validate(); err != nil {
+		return err
+	}
+
+	argon2ParamsMu.Lock()
+	defer argon2ParamsMu.Unlock()
+	argon2Params = params
+
+	return nil
+}
+
+// Validate returns ErrInvalidArgon2Params for parameters Argon2id can't use
+// or that are too weak.
+func (p Argon2Params) Validate() error {
+	if p.Memory < 8*uint32(p.Parallelism) || p.Iterations < 1 || p.Parallelism < 1 || p.SaltLength < 8 || p.KeyLength < 16 {
+		return ErrInvalidArgon2Params
+	}
+	return nil
+}
+
+func currentArgon2Params() Argon2Params {
+	argon2ParamsMu.RLock()
+	defer argon2ParamsMu.RUnlock()
+	return argon2Params
+}
+
+// EncryptPassword hashes the password with Argon2id and returns it in PHC
+// string format: $argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>
 func EncryptPassword(password string) (string, error) {
-	// before we hash the password, we need to convert it to a byte slice
-	// because the bcrypt.GenerateFromPassword function only accepts a byte slice
-	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
+	params := currentArgon2Params()
+
+	salt := make([]byte, params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
 		return "", err
 	}
-	return string(passwordHash), nil
+
+	key := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
 }
+
+// CheckPassword verifies Argon2id hashes and the bcrypt hashes written
+// before Argon2id was introduced.
 func CheckPassword(hashedPassword, plainPassword string) bool {
+	if strings.HasPrefix(hashedPassword, "$argon2id$") {
+		params, salt, key, err := decodeArgon2Hash(hashedPassword)
+		if err != nil {
+			return false
+		}
+
+		other := argon2.IDKey([]byte(plainPassword), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+		return subtle.ConstantTimeCompare(key, other) == 1
+	}
+
 	// CompareHashAndPassword returns nil on success and an error on failure
 	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(plainPassword))
 	return err == nil
 }
+
+// NeedsRehash reports whether the hash uses bcrypt or other Argon2id
+// parameters than the current ones. It should be replaced on the next
+// successful login.
+func NeedsRehash(hashedPassword string) bool {
+	params, salt, _, err := decodeArgon2Hash(hashedPassword)
+	if err != nil {
+		return true
+	}
+
+	current := currentArgon2Params()
+	return params.Memory != current.Memory ||
+		params.Iterations != current.Iterations ||
+		params.Parallelism != current.Parallelism ||
+		params.KeyLength != current.KeyLength ||
+		uint32(len(salt)) != current.SaltLength
+}
+
+func decodeArgon2Hash(hashedPassword string) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, hash
+	parts := strings.Split(hashedPassword, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, errInvalidPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, errInvalidPasswordHash
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, errInvalidPasswordHash
+	}
+	if params.Iterations < 1 || params.Parallelism < 1 {
+		return params, nil, nil, errInvalidPasswordHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, errInvalidPasswordHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, errInvalidPasswordHash
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
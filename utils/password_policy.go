@@ -0,0 +1,179 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PasswordPolicy are the rules new passwords have to follow.
+type PasswordPolicy struct {
+	MinLength     int  `json:"minLength"`
+	MaxLength     int  `json:"maxLength"` // in bytes, bcrypt ignores everything after 72
+	RequireUpper  bool `json:"requireUpper"`
+	RequireLower  bool `json:"requireLower"`
+	RequireDigit  bool `json:"requireDigit"`
+	RequireSymbol bool `json:"requireSymbol"`
+	// DisallowUserInfo rejects passwords containing the username or email
+	DisallowUserInfo bool `json:"disallowUserInfo"`
+	// BreachedDir holds breached password hashes in the Have I Been Pwned
+	// range format: one file per SHA-1 prefix (e.g. 5BAA6.txt) with lines
+	// of SUFFIX:COUNT. Empty disables the check.
+	BreachedDir string `json:"breachedDir"`
+}
+
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength:        5,
+	MaxLength:        72,
+	DisallowUserInfo: true,
+}
+
+var ErrInvalidPasswordPolicy = errors.New("invalid password policy")
+
+var (
+	passwordPolicyMu sync.RWMutex
+	passwordPolicy   = DefaultPasswordPolicy
+)
+
+// SetPasswordPolicy replaces the policy used by the validators.
+func SetPasswordPolicy(policy PasswordPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+
+	passwordPolicyMu.Lock()
+	defer passwordPolicyMu.Unlock()
+	passwordPolicy = policy
+
+	return nil
+}
+
+// Validate returns ErrInvalidPasswordPolicy when no password could satisfy
+// the length limits.
+func (p PasswordPolicy) Validate() error {
+	if p.MinLength < 1 || p.MaxLength < p.MinLength {
+		return ErrInvalidPasswordPolicy
+	}
+	return nil
+}
+
+func currentPasswordPolicy() PasswordPolicy {
+	passwordPolicyMu.RLock()
+	defer passwordPolicyMu.RUnlock()
+	return passwordPolicy
+}
+
+// LoadPasswordPolicy reads a JSON policy file. Fields missing from the file
+// keep their default value.
+func LoadPasswordPolicy(path string) (PasswordPolicy, error) {
+	policy := DefaultPasswordPolicy
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return policy, err
+	}
+
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return policy, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	return policy, nil
+}
+
+// ValidatePassword returns every rule of the current policy the password
+// breaks. username and email may be empty when they aren't known.
+func ValidatePassword(password, username, email string) []string {
+	return currentPasswordPolicy().violations(password, username, email)
+}
+
+func (p PasswordPolicy) violations(password, username, email string) []string {
+	var violations []string
+
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, fmt.Sprintf("Password must be at least %d characters", p.MinLength))
+	}
+
+	if len(password) > p.MaxLength {
+		violations = append(violations, fmt.Sprintf("Password must be at most %d bytes", p.MaxLength))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, c := range password {
+		switch {
+		case unicode.IsUpper(c):
+			hasUpper = true
+		case unicode.IsLower(c):
+			hasLower = true
+		case unicode.IsDigit(c):
+			hasDigit = true
+		case !unicode.IsLetter(c) && !unicode.IsSpace(c):
+			hasSymbol = true
+		}
+	}
+
+	if p.RequireUpper && !hasUpper {
+		violations = append(violations, "Password must contain an uppercase letter")
+	}
+	if p.RequireLower && !hasLower {
+		violations = append(violations, "Password must contain a lowercase letter")
+	}
+	if p.RequireDigit && !hasDigit {
+		violations = append(violations, "Password must contain a digit")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		violations = append(violations, "Password must contain a symbol")
+	}
+
+	if p.DisallowUserInfo {
+		lower := strings.ToLower(password)
+
+		if username = strings.ToLower(strings.TrimSpace(username)); len(username) >= 3 && strings.Contains(lower, username) {
+			violations = append(violations, "Password must not contain the username")
+		}
+
+		email = strings.ToLower(strings.TrimSpace(email))
+		localPart, _, _ := strings.Cut(email, "@")
+		if email != "" && (strings.Contains(lower, email) || len(localPart) >= 3 && strings.Contains(lower, localPart)) {
+			violations = append(violations, "Password must not contain the email")
+		}
+	}
+
+	if p.BreachedDir != "" && isBreached(p.BreachedDir, password) {
+		violations = append(violations, "Password has appeared in a data breach, please choose another one")
+	}
+
+	return violations
+}
+
+// isBreached looks the password up in the range file of its SHA-1 prefix,
+// so only one small file is read per check.
+func isBreached(dir, password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	file, err := os.Open(filepath.Join(dir, prefix+".txt"))
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), ":")
+		if strings.EqualFold(strings.TrimSpace(line), suffix) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -4,6 +4,7 @@ import (
 	"go-crud-database/models"
 	"regexp"
 	"strings"
+	"time"
 )
 
 // email regex pattern to validate email
@@ -22,8 +23,8 @@ func ValidateRegisterRequest(req models.RegisterRequest) (string, bool) {
 		return "Password cannot be empty", false
 	}
 
-	if len(req.Password) < 5 {
-		return "Password must be at least 5 characters", false
+	if violations := ValidatePassword(req.Password, req.Username, req.Email); len(violations) > 0 {
+		return strings.Join(violations, "; "), false
 	}
 
 	if !emailRegex.MatchString(req.Email) {
@@ -42,10 +43,7 @@ func ValidateLoginRequest(req models.LoginRequest) (string, bool) {
 		return "Password cannot be empty", false
 	}
 
-	if len(req.Password) < 5 {
-		return "Password must be at least 5 characters", false
-	}
-
+	// the policy isn't applied here, existing passwords may predate it
 	return "", true
 }
 
@@ -59,12 +57,114 @@ func ValidateUpdateUserRequest(req models.UpdateUserRequest) (string, bool) {
 		return "Email cannot be empty", false
 	}
 
-	if strings.TrimSpace(req.Password) == "" {
-		return "Password cannot be empty", false
+	if !emailRegex.MatchString(req.Email) {
+		return "Invalid email format", false
+	}
+
+	return "", true
+}
+
+func ValidateUpdateProfileRequest(req models.UpdateProfileRequest) (string, bool) {
+	if req.Username == nil && req.Email == nil {
+		return "Nothing to update", false
+	}
+
+	if req.Username != nil && strings.TrimSpace(*req.Username) == "" {
+		return "Username cannot be empty", false
+	}
+
+	if req.Email != nil && strings.TrimSpace(*req.Email) == "" {
+		return "Email cannot be empty", false
+	}
+
+	if req.Email != nil && !emailRegex.MatchString(*req.Email) {
+		return "Invalid email format", false
+	}
+
+	return "", true
+}
+
+func ValidateChangePasswordRequest(req models.ChangePasswordRequest) (string, bool) {
+	if strings.TrimSpace(req.CurrentPassword) == "" {
+		return "Current password cannot be empty", false
+	}
+
+	if strings.TrimSpace(req.NewPassword) == "" {
+		return "New password cannot be empty", false
+	}
+
+	// the handler checks the policy once it knows the username and email
+	if req.NewPassword == req.CurrentPassword {
+		return "New password must be different from the current password", false
+	}
+
+	return "", true
+}
+
+func ValidateForgotPasswordRequest(req models.ForgotPasswordRequest) (string, bool) {
+	if strings.TrimSpace(req.Email) == "" {
+		return "Email cannot be empty", false
+	}
+
+	if !emailRegex.MatchString(req.Email) {
+		return "Invalid email format", false
 	}
 
-	if len(req.Password) < 5 {
-		return "Password must be at least 5 characters", false
+	return "", true
+}
+
+func ValidateResetPasswordRequest(req models.ResetPasswordRequest) (string, bool) {
+	if strings.TrimSpace(req.Token) == "" {
+		return "Token cannot be empty", false
+	}
+
+	if strings.TrimSpace(req.NewPassword) == "" {
+		return "New password cannot be empty", false
+	}
+
+	// the handler checks the policy once it knows the username and email
+	return "", true
+}
+
+func ValidateMFACodeRequest(req models.MFACodeRequest) (string, bool) {
+	if strings.TrimSpace(req.Code) == "" {
+		return "Code cannot be empty", false
+	}
+
+	return "", true
+}
+
+func ValidateMFALoginRequest(req models.MFALoginRequest) (string, bool) {
+	if strings.TrimSpace(req.MFAToken) == "" {
+		return "MFA token cannot be empty", false
+	}
+
+	if strings.TrimSpace(req.Code) == "" {
+		return "Code cannot be empty", false
+	}
+
+	return "", true
+}
+
+func ValidateCreateAPIKeyRequest(req models.CreateAPIKeyRequest) (string, bool) {
+	if strings.TrimSpace(req.Name) == "" {
+		return "Name cannot be empty", false
+	}
+
+	if len(req.Name) > 100 {
+		return "Name must be at most 100 characters", false
+	}
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return "Expiry must be in the future", false
+	}
+
+	return "", true
+}
+
+func ValidateMagicLinkRequest(req models.MagicLinkRequest) (string, bool) {
+	if strings.TrimSpace(req.Email) == "" {
+		return "Email cannot be empty", false
 	}
 
 	if !emailRegex.MatchString(req.Email) {
@@ -73,3 +173,15 @@ func ValidateUpdateUserRequest(req models.UpdateUserRequest) (string, bool) {
 
 	return "", true
 }
+
+func ValidateImpersonateRequest(req models.ImpersonateRequest) (string, bool) {
+	if strings.TrimSpace(req.Reason) == "" {
+		return "Reason cannot be empty", false
+	}
+
+	if len(req.Reason) > 255 {
+		return "Reason must not be longer than 255 characters", false
+	}
+
+	return "", true
+}